@@ -119,6 +119,10 @@ export function DeviceManager({
 						: (device as StorageDevice).name, // Backend needs a serial, using name for now.
 				DeviceType: device.type,
 				DeviceModel: device.model,
+				identity:
+					device.deviceCategory === "storage"
+						? device.identity
+						: undefined,
 			});
 			setShowConfirmation(false);
 			router.push(
@@ -12,6 +12,13 @@ export interface Partition {
 	type: string;
 }
 
+// Serial/WWN/size of a drive, sent back with a wipe request to pin the target
+export interface DeviceIdentity {
+	serial: string;
+	wwn: string;
+	size: number;
+}
+
 // Specific type for standard storage drives
 export interface StorageDevice extends BaseDevice {
 	deviceCategory: "storage";
@@ -20,6 +27,7 @@ export interface StorageDevice extends BaseDevice {
 	isFrozen: boolean;
 	isOSDrive?: boolean;
 	partitions: Partition[];
+	identity?: DeviceIdentity;
 	status?: "ready" | "wiping" | "completed" | "error" | "not-ready";
 	health?: DriveHealth;
 }
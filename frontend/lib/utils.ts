@@ -1,5 +1,6 @@
 import { clsx, type ClassValue } from "clsx";
 import { twMerge } from "tailwind-merge";
+import type { DeviceIdentity } from "./types";
 
 export function cn(...inputs: ClassValue[]) {
 	return twMerge(clsx(inputs));
@@ -24,20 +25,50 @@ export async function getDriveHealth(deviceName: string) {
 	return response.json();
 }
 
-export async function startWipe(config: {
+type WipeRequest = {
 	DevicePath: string;
 	Method: string;
 	DeviceSerial: string;
 	DeviceType: string;
 	DeviceModel: string;
-}) {
-	const response = await fetch(`${API_BASE_URL}/wipe`, {
+	identity?: DeviceIdentity;
+};
+
+// Runs every server-side check without writing and returns a confirmation
+// token that the wipe request must carry.
+export async function preflightWipe(config: WipeRequest) {
+	const response = await fetch(`${API_BASE_URL}/wipe/preflight`, {
 		method: "POST",
 		headers: {
 			"Content-Type": "application/json",
 		},
 		body: JSON.stringify(config),
 	});
+	if (!response.ok) {
+		throw new Error("Failed to run wipe preflight");
+	}
+	return response.json();
+}
+
+export async function startWipe(config: WipeRequest) {
+	const preflight = await preflightWipe(config);
+	if (!preflight.ok) {
+		const failed = preflight.checks
+			.filter((c: { passed: boolean }) => !c.passed)
+			.map((c: { name: string; detail: string }) => `${c.name}: ${c.detail}`);
+		throw new Error(`Preflight failed: ${failed.join("; ")}`);
+	}
+
+	const response = await fetch(`${API_BASE_URL}/wipe`, {
+		method: "POST",
+		headers: {
+			"Content-Type": "application/json",
+		},
+		body: JSON.stringify({
+			...config,
+			confirmationToken: preflight.confirmationToken,
+		}),
+	});
 	if (!response.ok) {
 		throw new Error("Failed to start wipe process");
 	}
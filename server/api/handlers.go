@@ -5,11 +5,13 @@ import (
 	"dzap-backend/realtime"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 var hub *realtime.Hub
@@ -28,7 +30,7 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 func GetDrivesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	drives, err := core.DetectDevices()
+	drives, err := core.Devices()
 	if err != nil {
 		log.Printf("ERROR in GetDrivesHandler: %v", err) // ADDED LOGGING
 		respondWithError(w, http.StatusInternalServerError, "Failed to detect drives: "+err.Error())
@@ -55,6 +57,55 @@ func GetDriveHealthHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
+// GetDriveProcessesHandler lists the processes holding a drive open.
+func GetDriveProcessesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	driveName := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/drive/"), "/processes")
+	devicePath := "/dev/" + driveName
+
+	users, err := core.DeviceUsers(devicePath)
+	if err != nil {
+		log.Printf("ERROR in GetDriveProcessesHandler: %v", err)
+		respondWithError(w, http.StatusNotFound, "Failed to list processes: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// runWipe runs a confirmed wipe job and streams its progress to the hub.
+func runWipe(config core.WipeConfig) (*core.WipeJob, error) {
+	progressChan := make(chan string)
+	go func() {
+		for msg := range progressChan {
+			hub.Broadcast <- []byte(msg)
+		}
+	}()
+
+	job, err := core.RunWipeJob(config, progressChan)
+	if err != nil {
+		log.Printf("ERROR in runWipe (sanitization): %v", err)
+		hub.Broadcast <- []byte("ERROR: " + err.Error())
+	} else {
+		done := map[string]string{
+			"status":   "done",
+			"deviceId": config.DevicePath,
+			"jobId":    job.ID,
+		}
+		if job.Reprovision != nil {
+			done["reprovision"] = job.Reprovision.Status
+		}
+		if job.CertificatePath != "" {
+			done["certificateId"] = job.ID
+		}
+		doneMsg, _ := json.Marshal(done)
+		hub.Broadcast <- doneMsg
+	}
+	close(progressChan)
+	return job, err
+}
+
 func WipeDriveHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
@@ -77,31 +128,279 @@ func WipeDriveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := core.ConsumeConfirmationToken(config); err != nil {
+		log.Printf("ERROR in WipeDriveHandler (confirmation): %v", err)
+		respondWithError(w, http.StatusForbidden, "Wipe not confirmed: "+err.Error())
+		return
+	}
+
+	go runWipe(config)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "Wipe process started"})
+}
+
+// PreflightWipeHandler runs every wipe check without touching the device and
+// returns a confirmation token that WipeDriveHandler requires.
+func PreflightWipeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var config core.WipeConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	result, err := core.Preflight(config)
+	if err != nil {
+		log.Printf("ERROR in PreflightWipeHandler: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Preflight failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	jobs, err := core.ListJobs()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to list jobs: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	id := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+
+	job, err := core.GetJob(id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// StartScheduler starts due scheduled wipes like interactive ones and relays
+// their countdowns to the hub. RegisterHub must be called first.
+func StartScheduler() {
+	events := make(chan string)
 	go func() {
-		progressChan := make(chan string)
-		go func() {
-			for msg := range progressChan {
-				hub.Broadcast <- []byte(msg)
-			}
-		}()
+		for msg := range events {
+			hub.Broadcast <- []byte(msg)
+		}
+	}()
+	core.StartScheduler(runWipe, events)
+}
 
-		err := core.SanitizeDevice(config, progressChan)
-		if err != nil {
-			log.Printf("ERROR in WipeDriveHandler (sanitization): %v", err) // ADDED LOGGING
-			hub.Broadcast <- []byte("ERROR: " + err.Error())
-		} else {
-			doneMsg, _ := json.Marshal(map[string]string{
-				"status":   "done",
-				"deviceId": config.DevicePath,
-			})
-			hub.Broadcast <- doneMsg
+// StartDeviceMonitor keeps the device registry current and relays
+// device_added, device_removed and device_changed events to the hub.
+// RegisterHub must be called first.
+func StartDeviceMonitor() {
+	events := make(chan string)
+	go func() {
+		for msg := range events {
+			hub.Broadcast <- []byte(msg)
 		}
-		close(progressChan)
 	}()
+	core.StartDeviceMonitor(events)
+}
+
+// SimHotplugHandler plugs ("add") or unplugs ("remove") a simulated disk.
+func SimHotplugHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		Disk   string `json:"disk"`
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if err := core.SimHotplug(req.Disk, req.Action); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{"status": "Wipe process started"})
+	json.NewEncoder(w).Encode(map[string]string{"disk": req.Disk, "action": req.Action})
+}
+
+// StartKiosk starts the wipe-station watcher and relays slot updates and wipe
+// progress to the hub. RegisterHub must be called first.
+func StartKiosk() {
+	events := make(chan string)
+	go func() {
+		for msg := range events {
+			hub.Broadcast <- []byte(msg)
+		}
+	}()
+	core.StartKiosk(events)
+}
+
+// KioskHandler reports the wipe station (GET), arms it with a policy (POST) or
+// disarms it (DELETE).
+func KioskHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	var status *core.KioskStatus
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodGet:
+		status = core.GetKioskStatus()
+	case http.MethodPost:
+		var policy core.KioskPolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		var err error
+		if status, err = core.ArmKiosk(policy); err != nil {
+			log.Printf("ERROR in KioskHandler (arm): %v", err)
+			respondWithError(w, http.StatusBadRequest, "Failed to arm kiosk: "+err.Error())
+			return
+		}
+		msg, _ := json.Marshal(map[string]interface{}{"status": "kiosk_armed", "policy": status.Policy})
+		hub.Broadcast <- msg
+	case http.MethodDelete:
+		status = core.DisarmKiosk()
+		hub.Broadcast <- []byte(`{"status":"kiosk_disarmed"}`)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+type scheduleRequest struct {
+	core.WipeConfig
+	StartAt time.Time `json:"startAt"`
+	Cron    string    `json:"cron"`
+}
+
+// SchedulesHandler lists scheduled wipes (GET) or schedules a confirmed wipe
+// (POST: the wipe request plus "startAt" or "cron").
+func SchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(core.ListScheduledWipes())
+	case http.MethodPost:
+		var req scheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if err := core.ConsumeConfirmationToken(req.WipeConfig); err != nil {
+			log.Printf("ERROR in SchedulesHandler (confirmation): %v", err)
+			respondWithError(w, http.StatusForbidden, "Wipe not confirmed: "+err.Error())
+			return
+		}
+		scheduled, err := core.ScheduleWipe(req.WipeConfig, req.StartAt, req.Cron)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(scheduled)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Invalid request method")
+	}
+}
+
+// ScheduleHandler cancels (DELETE) or reschedules (PUT with "startAt" or
+// "cron") a scheduled wipe that has not started yet.
+func ScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	id := strings.TrimPrefix(r.URL.Path, "/api/schedules/")
+
+	var scheduled *core.ScheduledWipe
+	var err error
+	event := "schedule_cancelled"
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodDelete:
+		scheduled, err = core.CancelScheduledWipe(id)
+	case http.MethodPut:
+		var req struct {
+			StartAt time.Time `json:"startAt"`
+			Cron    string    `json:"cron"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		scheduled, err = core.RescheduleWipe(id, req.StartAt, req.Cron)
+		event = "schedule_rescheduled"
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	msg, _ := json.Marshal(map[string]interface{}{
+		"status":     event,
+		"scheduleId": scheduled.ID,
+		"deviceId":   scheduled.Config.DevicePath,
+		"startAt":    scheduled.NextRun,
+	})
+	hub.Broadcast <- msg
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduled)
 }
 
 func GetWipeMethodsHandler(w http.ResponseWriter, r *http.Request) {
@@ -127,25 +426,79 @@ func GetWipeMethodsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(methods)
 }
 
-type CertRequest struct {
-	Model  string `json:"model"`
-	Serial string `json:"serial"`
-	Method string `json:"method"`
+// InspectFileTargetHandler describes a raw file or disk image so the client can
+// pin its identity and choose a method before wiping it.
+func InspectFileTargetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	target, err := core.InspectFileTarget(req.Path)
+	if err != nil {
+		log.Printf("ERROR in InspectFileTargetHandler for '%s': %v", req.Path, err)
+		respondWithError(w, http.StatusBadRequest, "Cannot use file target: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target)
 }
 
+// GenerateCertificateHandler issues the certificate of a succeeded job again,
+// e.g. after issuing failed when the job finished. Certificates are only
+// signed from the backend's own job record and log.
 func GenerateCertificateHandler(w http.ResponseWriter, r *http.Request) {
-	// This is a placeholder.
-	var data map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Invalid request method")
 		return
 	}
 
-	// For now, just log the data.
-	fmt.Printf("Received data for certificate generation: %+v\n", data)
+	var req struct {
+		JobID string `json:"jobId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if _, err := core.GetJob(req.JobID); err != nil {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Certificate generation request received"})
+	cert, err := core.IssueJobCertificate(req.JobID)
+	if err != nil {
+		log.Printf("ERROR in GenerateCertificateHandler: %v", err)
+		respondWithError(w, http.StatusConflict, "Failed to issue certificate: "+err.Error())
+		return
+	}
+	msg, _ := json.Marshal(map[string]string{
+		"status":        "certificate_issued",
+		"jobId":         req.JobID,
+		"certificateId": req.JobID,
+	})
+	hub.Broadcast <- msg
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cert)
 }
 
 func PauseWipeHandler(w http.ResponseWriter, r *http.Request) {
@@ -219,13 +572,8 @@ func ListCertificatesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(certs)
 }
 
-type certRequest struct {
-	Model   string `json:"model"`
-	Serial  string `json:"serial"`
-	Method  string `json:"method"`
-	LogHash string `json:"logHash"`
-}
-
+// UnmountDriveHandler releases a device and everything stacked on it: mounts,
+// swap, LVM, LUKS, md and ZFS. With "dryRun" it only lists the actions.
 func UnmountDriveHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
@@ -242,7 +590,9 @@ func UnmountDriveHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Device string `json:"device"`
+		Device          string `json:"device"`
+		DryRun          bool   `json:"dryRun"`
+		AllowSystemDisk bool   `json:"allowSystemDisk"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -251,51 +601,126 @@ func UnmountDriveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := core.UnmountDevice(req.Device); err != nil {
-		log.Printf("Error unmounting device %s: %v\n", req.Device, err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to unmount device: "+err.Error())
+	if !req.DryRun && !req.AllowSystemDisk {
+		if err := core.CheckSystemDisk(req.Device); err != nil {
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	report, err := core.ReleaseDevice(req.Device, req.DryRun)
+	if err != nil {
+		log.Printf("Error releasing device %s: %v\n", req.Device, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to release device: "+err.Error())
+		return
+	}
+	if failed := report.Failed(); len(failed) > 0 {
+		var msgs []string
+		for _, a := range failed {
+			msgs = append(msgs, fmt.Sprintf("%s %s: %s", a.Kind, a.Target, a.Error))
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to release device: "+strings.Join(msgs, "; "))
 		return
 	}
 
-	log.Printf("Successfully processed unmount for device %s\n", req.Device)
+	log.Printf("Successfully processed release for device %s (%d actions, dry run: %v)\n", req.Device, len(report.Actions), req.DryRun)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "Device unmounted successfully"})
+	json.NewEncoder(w).Encode(report)
 }
 
-func CertificateHandler(w http.ResponseWriter, r *http.Request) {
+// maxCertificateUpload bounds what VerifyCertificateHandler reads.
+const maxCertificateUpload = 16 << 20
+
+// VerifyCertificateHandler checks a certificate posted as its JSON, the
+// content of its QR code or its PDF, either as the request body or as the
+// "certificate" file of a form upload. It answers 200 with the field-level
+// result whether or not the certificate is valid.
+func VerifyCertificateHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	if r.Method != http.MethodPost {
 		respondWithError(w, http.StatusMethodNotAllowed, "Invalid request method")
 		return
 	}
 
-	var req certRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+	r.Body = http.MaxBytesReader(w, r.Body, maxCertificateUpload)
+	var input []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, ferr := r.FormFile("certificate")
+		if ferr != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid upload: "+ferr.Error())
+			return
+		}
+		defer file.Close()
+		input, err = io.ReadAll(file)
+	} else {
+		input, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not read certificate: "+err.Error())
+		return
+	}
+	if len(input) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No certificate given")
 		return
 	}
 
-	// In a real app, the logHash would be more meaningful
-	signedCert, err := core.GenerateCertificate(req.Model, req.Serial, req.Method, "placeholder_hash")
+	result, err := core.VerifyCertificate(input)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate certificate: "+err.Error())
+		log.Printf("ERROR in VerifyCertificateHandler: %v", err)
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Check if user requested PDF format
-	if r.URL.Query().Get("format") == "pdf" {
-		pdfBytes, err := signedCert.GeneratePDF()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetCertificateHandler serves a stored certificate by its ID (its job's ID)
+// as JSON, or with ?format=pdf or ?format=html as a printable document.
+func GetCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	id := strings.TrimPrefix(r.URL.Path, "/api/certificates/")
+
+	cert, err := core.LoadCertificate(id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cert)
+	case "pdf":
+		pdfBytes, err := cert.GeneratePDF()
 		if err != nil {
+			log.Printf("ERROR in GetCertificateHandler (pdf): %v", err)
 			respondWithError(w, http.StatusInternalServerError, "Failed to generate PDF: "+err.Error())
 			return
 		}
 		w.Header().Set("Content-Type", "application/pdf")
-		w.Header().Set("Content-Disposition", "attachment; filename=certificate.pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=certificate-%s.pdf", id))
 		w.Write(pdfBytes)
-	} else {
-		// Default to JSON
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(signedCert)
+	case "html":
+		page, err := cert.GenerateHTML()
+		if err != nil {
+			log.Printf("ERROR in GetCertificateHandler (html): %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate HTML: "+err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	default:
+		respondWithError(w, http.StatusBadRequest, "Unknown format "+format+" (expected json, pdf or html)")
 	}
 }
@@ -0,0 +1,597 @@
+// Package certverify checks DZap data destruction certificates. It does not
+// depend on the rest of the backend, so the offline dzap-verify command can
+// use it on any machine.
+package certverify
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Certificate format versions. Certificates without a version were signed
+// over their fields joined by "|", which a field containing "|" makes
+// ambiguous; CurrentVersion signs each field prefixed with its length.
+const (
+	LegacyVersion  = 0
+	CurrentVersion = 1
+)
+
+// versionTag starts the signed encoding, so a digest cannot be taken for one
+// of another version.
+const versionTag = "dzap-certificate-v1\n"
+
+// Data is the signed content of a certificate. SignatureCheck and Device are
+// kept as the JSON they were signed as, so certificates from any version of
+// the backend can be checked.
+type Data struct {
+	Version          int             `json:"version,omitempty"`
+	JobID            string          `json:"jobId,omitempty"`
+	DeviceModel      string          `json:"deviceModel"`
+	DeviceSerial     string          `json:"deviceSerial"`
+	WipeMethod       string          `json:"wipeMethod"`
+	Timestamp        time.Time       `json:"timestamp"`
+	VerificationHash string          `json:"verificationHash"`
+	SignatureCheck   json.RawMessage `json:"signatureCheck,omitempty"`
+	Device           json.RawMessage `json:"device,omitempty"`
+}
+
+// Certificate is a certificate as stored, served and attached to its PDF.
+type Certificate struct {
+	Data      json.RawMessage `json:"data"`
+	Signature string          `json:"signature"` // hex, RSA PKCS#1 v1.5 over Digest
+	PublicKey string          `json:"publicKey"` // PEM, PKIX
+}
+
+// Digest returns the SHA-256 digest a certificate's signature covers. The
+// post-wipe signature check and the device details are included as hashes of
+// their compact JSON.
+func Digest(d Data) ([]byte, error) {
+	fields, err := d.signedFields()
+	if err != nil {
+		return nil, err
+	}
+	return fields.digest()
+}
+
+// signedFields is what a signature covers, with the post-wipe signature check
+// and the device details reduced to their hashes ("" when absent). It is all
+// the QR code needs to carry to be checked.
+type signedFields struct {
+	Version            int
+	JobID              string
+	DeviceModel        string
+	DeviceSerial       string
+	WipeMethod         string
+	Timestamp          time.Time
+	VerificationHash   string
+	SignatureCheckHash string
+	DeviceHash         string
+}
+
+func (d Data) signedFields() (signedFields, error) {
+	fields := signedFields{
+		Version:          d.Version,
+		JobID:            d.JobID,
+		DeviceModel:      d.DeviceModel,
+		DeviceSerial:     d.DeviceSerial,
+		WipeMethod:       d.WipeMethod,
+		Timestamp:        d.Timestamp,
+		VerificationHash: d.VerificationHash,
+	}
+	var err error
+	if fields.SignatureCheckHash, err = optionalHash(d.SignatureCheck); err != nil {
+		return fields, fmt.Errorf("invalid signature check: %w", err)
+	}
+	if fields.DeviceHash, err = optionalHash(d.Device); err != nil {
+		return fields, fmt.Errorf("invalid device details: %w", err)
+	}
+	return fields, nil
+}
+
+func (f signedFields) digest() ([]byte, error) {
+	var payload string
+	var err error
+	switch f.Version {
+	case LegacyVersion:
+		payload, err = f.legacyPayload()
+	case CurrentVersion:
+		payload = f.canonicalPayload()
+	default:
+		return nil, fmt.Errorf("unsupported certificate version %d", f.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(payload))
+	return hash[:], nil
+}
+
+// canonicalPayload writes the version tag, then every field in a fixed order
+// as its length in bytes, ":", the value and ";". Absent fields are empty.
+func (f signedFields) canonicalPayload() string {
+	var b strings.Builder
+	b.WriteString(versionTag)
+	for _, field := range []string{
+		f.JobID,
+		f.DeviceModel,
+		f.DeviceSerial,
+		f.WipeMethod,
+		f.Timestamp.Format(time.RFC3339Nano),
+		f.VerificationHash,
+		f.SignatureCheckHash,
+		f.DeviceHash,
+	} {
+		fmt.Fprintf(&b, "%d:%s;", len(field), field)
+	}
+	return b.String()
+}
+
+// legacyPayload is the encoding of certificates without a version: the fields
+// joined by "|". It is refused when a field contains "|", since its signature
+// would then also cover other splits of the same text.
+func (f signedFields) legacyPayload() (string, error) {
+	for _, field := range []string{f.JobID, f.DeviceModel, f.DeviceSerial, f.WipeMethod, f.VerificationHash} {
+		if strings.Contains(field, "|") {
+			return "", errors.New(`unversioned certificate with "|" in a signed field; its signature is ambiguous`)
+		}
+	}
+	payload := fmt.Sprintf("%s|%s|%s|%s|%s", f.DeviceModel, f.DeviceSerial, f.WipeMethod, f.Timestamp.Format(time.RFC3339), f.VerificationHash)
+	if f.SignatureCheckHash != "" {
+		payload += "|" + f.SignatureCheckHash
+	}
+	if f.JobID != "" {
+		payload += "|job:" + f.JobID
+	}
+	if f.DeviceHash != "" {
+		payload += "|device:" + f.DeviceHash
+	}
+	return payload, nil
+}
+
+func optionalHash(raw json.RawMessage) (string, error) {
+	if !present(raw) {
+		return "", nil
+	}
+	return compactHash(raw)
+}
+
+func present(raw json.RawMessage) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+func compactHash(raw json.RawMessage) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Input sources.
+const (
+	SourceJSON = "json" // the certificate file or the API response
+	SourcePDF  = "pdf"
+	SourceQR   = "qr" // the content of the QR code
+)
+
+var (
+	embeddedFile = []byte("/Type /EmbeddedFile")
+	streamLength = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+)
+
+// Parse reads a certificate from its JSON or from a DZap PDF, which carries
+// the JSON as an attached file, and returns which of the two it was.
+func Parse(input []byte) (*Certificate, string, error) {
+	source := SourceJSON
+	if bytes.HasPrefix(input, []byte("%PDF-")) {
+		source = SourcePDF
+		var err error
+		if input, err = fromPDF(input); err != nil {
+			return nil, source, err
+		}
+	}
+	cert := &Certificate{}
+	if err := json.Unmarshal(bytes.TrimSpace(input), cert); err != nil {
+		return nil, source, fmt.Errorf("not a DZap certificate: %w", err)
+	}
+	if !present(cert.Data) || cert.Signature == "" {
+		return nil, source, errors.New("not a DZap certificate: data or signature missing")
+	}
+	return cert, source, nil
+}
+
+// fromPDF returns the first attached file that holds a certificate.
+func fromPDF(pdf []byte) ([]byte, error) {
+	for rest := pdf; ; {
+		i := bytes.Index(rest, embeddedFile)
+		if i < 0 {
+			break
+		}
+		rest = rest[i+len(embeddedFile):]
+		end := bytes.Index(rest, []byte("stream"))
+		if end < 0 {
+			break
+		}
+		dict := rest[:end]
+		m := streamLength.FindSubmatch(dict)
+		if m == nil || m[2] != nil {
+			continue // Indirect lengths are not written by DZap
+		}
+		var length int
+		fmt.Sscan(string(m[1]), &length)
+		start := end + len("stream")
+		if bytes.HasPrefix(rest[start:], []byte("\r\n")) {
+			start += 2
+		} else if bytes.HasPrefix(rest[start:], []byte("\n")) {
+			start++
+		}
+		if start+length > len(rest) {
+			continue
+		}
+		content := rest[start : start+length]
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			r, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			content, err = io.ReadAll(r)
+			if err != nil {
+				continue
+			}
+		}
+		var probe Certificate
+		if json.Unmarshal(content, &probe) == nil && probe.Signature != "" {
+			return content, nil
+		}
+	}
+	return nil, errors.New("the PDF has no attached DZap certificate; verify its JSON or QR code instead")
+}
+
+// TrustedKey is a public key certificates may be signed with.
+type TrustedKey struct {
+	Name        string         `json:"name"`
+	Fingerprint string         `json:"fingerprint"`
+	Key         *rsa.PublicKey `json:"-"`
+}
+
+// Fingerprint is the SHA-256 of a key's PKIX encoding, in hex.
+func Fingerprint(key *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParsePublicKey reads the first RSA public key of a PEM document.
+func ParsePublicKey(pemData []byte) (*rsa.PublicKey, error) {
+	keys, err := ParsePublicKeys("", pemData)
+	if err != nil {
+		return nil, err
+	}
+	return keys[0].Key, nil
+}
+
+// ParsePublicKeys reads every RSA public key ("PUBLIC KEY" or "RSA PUBLIC
+// KEY") of a PEM document. Keys are named after name, numbered from the
+// second on.
+func ParsePublicKeys(name string, pemData []byte) ([]TrustedKey, error) {
+	var keys []TrustedKey
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+		var key *rsa.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid public key: %w", err)
+			}
+			rsaKey, ok := parsed.(*rsa.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("%T is not an RSA public key", parsed)
+			}
+			key = rsaKey
+		case "RSA PUBLIC KEY":
+			parsed, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid public key: %w", err)
+			}
+			key = parsed
+		default:
+			continue
+		}
+		keyName := name
+		if len(keys) > 0 {
+			keyName = fmt.Sprintf("%s#%d", name, len(keys)+1)
+		}
+		keys = append(keys, TrustedKey{Name: keyName, Fingerprint: Fingerprint(key), Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA public key found")
+	}
+	return keys, nil
+}
+
+// LoadTrustedKeys reads the keys in each path, a PEM file or a directory of
+// *.pem files.
+func LoadTrustedKeys(paths ...string) ([]TrustedKey, error) {
+	var keys []TrustedKey
+	for _, path := range paths {
+		files := []string{path}
+		if info, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("could not read trusted keys: %w", err)
+		} else if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*.pem")); err != nil {
+				return nil, err
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("could not read trusted keys: %w", err)
+			}
+			fileKeys, err := ParsePublicKeys(filepath.Base(file), data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			keys = append(keys, fileKeys...)
+		}
+	}
+	return keys, nil
+}
+
+// Field statuses.
+const (
+	FieldValid      = "valid"      // covered by a signature from a trusted key, and plausible
+	FieldUntrusted  = "untrusted"  // covered by a valid signature from a key that is not trusted
+	FieldUnverified = "unverified" // the signature does not verify, so it may have been altered
+	FieldInvalid    = "invalid"    // fails its own check, see Detail
+	FieldUnsigned   = "unsigned"   // not covered by the signature at all
+)
+
+// FieldResult is the outcome for one field of the certificate.
+type FieldResult struct {
+	Field  string `json:"field"`
+	Value  string `json:"value,omitempty"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Result is the outcome of verifying a certificate. It is Valid when the
+// signature verifies with a trusted key, the embedded key is that key, and no
+// field is invalid or unsigned.
+type Result struct {
+	Valid  bool   `json:"valid"`
+	Source string `json:"source,omitempty"`
+	// SignatureValid is whether the signature verifies with the key embedded
+	// in the certificate, or for a QR code with the trusted key it names.
+	SignatureValid bool          `json:"signatureValid"`
+	Trusted        bool          `json:"trusted"`
+	TrustedKey     string        `json:"trustedKey,omitempty"` // name of the trusted key that verified it
+	KeyFingerprint string        `json:"keyFingerprint,omitempty"`
+	Fields         []FieldResult `json:"fields"`
+	Data           *Data         `json:"data,omitempty"`
+}
+
+func (r *Result) add(field, value, status, detail string) {
+	r.Fields = append(r.Fields, FieldResult{Field: field, Value: value, Status: status, Detail: detail})
+}
+
+// clockSkew is how far in the future a certificate's timestamp may be.
+const clockSkew = 5 * time.Minute
+
+// Verify checks a certificate, given as JSON, QR code content or PDF, against
+// the key embedded in it and the trusted keys. The QR code carries no key, so
+// its content can only be checked against the trusted keys.
+func Verify(input []byte, trusted []TrustedKey, now time.Time) *Result {
+	if qr, ok := parseQRCode(input); ok {
+		return verifyQRCode(qr, trusted, now)
+	}
+	result := &Result{}
+	cert, source, err := Parse(input)
+	result.Source = source
+	if err != nil {
+		result.add("certificate", "", FieldInvalid, err.Error())
+		return result
+	}
+	var data Data
+	if err := json.Unmarshal(cert.Data, &data); err != nil {
+		result.add("data", "", FieldInvalid, err.Error())
+		return result
+	}
+	result.Data = &data
+
+	digest, digestErr := Digest(data)
+	signature, sigErr := hex.DecodeString(cert.Signature)
+
+	// Authenticity: a trusted key made the signature.
+	var signer *TrustedKey
+	if digestErr == nil && sigErr == nil {
+		for i := range trusted {
+			if rsa.VerifyPKCS1v15(trusted[i].Key, crypto.SHA256, digest, signature) == nil {
+				signer = &trusted[i]
+				break
+			}
+		}
+	}
+	if signer != nil {
+		result.Trusted, result.TrustedKey = true, signer.Name
+	}
+
+	// Integrity: the embedded key made the signature.
+	embedded, keyErr := ParsePublicKey([]byte(cert.PublicKey))
+	switch {
+	case keyErr != nil:
+		result.add("publicKey", "", FieldInvalid, keyErr.Error())
+	default:
+		result.KeyFingerprint = Fingerprint(embedded)
+		if digestErr == nil && sigErr == nil {
+			result.SignatureValid = rsa.VerifyPKCS1v15(embedded, crypto.SHA256, digest, signature) == nil
+		}
+		switch {
+		case signer != nil && signer.Fingerprint != result.KeyFingerprint:
+			result.add("publicKey", result.KeyFingerprint, FieldInvalid, "not the key that signed the certificate, which is trusted key "+signer.Name)
+		case signer != nil:
+			result.add("publicKey", result.KeyFingerprint, FieldValid, "trusted key "+signer.Name)
+		case result.SignatureValid:
+			result.add("publicKey", result.KeyFingerprint, FieldUntrusted, "not in the trusted-key list")
+		default:
+			result.add("publicKey", result.KeyFingerprint, FieldUnverified, "")
+		}
+	}
+
+	switch {
+	case sigErr != nil:
+		result.add("signature", "", FieldInvalid, "not hex: "+sigErr.Error())
+	case digestErr != nil:
+		result.add("signature", "", FieldInvalid, digestErr.Error())
+	case signer != nil || result.SignatureValid:
+		status := FieldValid
+		if signer == nil {
+			status = FieldUntrusted
+		}
+		result.add("signature", "", status, "RSA PKCS#1 v1.5 over SHA-256")
+	default:
+		result.add("signature", "", FieldInvalid, "does not match the certificate's content with the embedded key or any trusted key")
+	}
+
+	// Every signed field shares the signature's outcome unless it fails its
+	// own check.
+	signed := FieldUnverified
+	switch {
+	case signer != nil:
+		signed = FieldValid
+	case result.SignatureValid:
+		signed = FieldUntrusted
+	}
+	check := result.checker(signed)
+	result.checkSignedFields(data, check, now)
+	if present(data.SignatureCheck) {
+		var scan struct {
+			Clean      bool              `json:"clean"`
+			Signatures []json.RawMessage `json:"signatures"`
+		}
+		value, problem := "clean", ""
+		if err := json.Unmarshal(data.SignatureCheck, &scan); err != nil {
+			problem = err.Error()
+		} else if !scan.Clean {
+			value = fmt.Sprintf("%d signatures", len(scan.Signatures))
+			problem = "recognizable signatures remained after the wipe"
+		}
+		check("signatureCheck", value, problem)
+	}
+	if present(data.Device) {
+		var device struct {
+			Model  string `json:"model"`
+			Serial string `json:"serial"`
+		}
+		value, problem := "", ""
+		if err := json.Unmarshal(data.Device, &device); err != nil {
+			problem = err.Error()
+		} else {
+			value = strings.TrimSpace(device.Model + " " + device.Serial)
+			if device.Model != data.DeviceModel || device.Serial != data.DeviceSerial {
+				problem = "does not match deviceModel and deviceSerial"
+			}
+		}
+		check("device", value, problem)
+	}
+
+	// Anything else in the data was added after signing.
+	var all map[string]json.RawMessage
+	json.Unmarshal(cert.Data, &all)
+	var extra []string
+	for field := range all {
+		switch field {
+		case "version", "jobId", "deviceModel", "deviceSerial", "wipeMethod", "timestamp", "verificationHash", "signatureCheck", "device":
+		default:
+			extra = append(extra, field)
+		}
+	}
+	sort.Strings(extra)
+	for _, field := range extra {
+		result.add(field, string(all[field]), FieldUnsigned, "not covered by the signature")
+	}
+
+	result.conclude()
+	return result
+}
+
+// checker returns a function that adds a signed field with status signed, or
+// as invalid when problem is set.
+func (r *Result) checker(signed string) func(field, value, problem string) {
+	return func(field, value, problem string) {
+		if problem != "" {
+			r.add(field, value, FieldInvalid, problem)
+		} else {
+			r.add(field, value, signed, "")
+		}
+	}
+}
+
+// checkSignedFields checks the fields that every form of a certificate
+// carries in full.
+func (r *Result) checkSignedFields(data Data, check func(field, value, problem string), now time.Time) {
+	if data.JobID != "" {
+		check("jobId", data.JobID, "")
+	}
+	check("deviceModel", data.DeviceModel, missing(data.DeviceModel))
+	check("deviceSerial", data.DeviceSerial, "")
+	check("wipeMethod", data.WipeMethod, missing(data.WipeMethod))
+	var timeProblem string
+	switch {
+	case data.Timestamp.IsZero():
+		timeProblem = "missing"
+	case data.Timestamp.After(now.Add(clockSkew)):
+		timeProblem = "in the future"
+	}
+	check("timestamp", data.Timestamp.Format(time.RFC3339), timeProblem)
+	check("verificationHash", data.VerificationHash, notSHA256(data.VerificationHash))
+}
+
+// conclude sets Valid: signed by a trusted key, with every field valid.
+func (r *Result) conclude() {
+	r.Valid = r.Trusted
+	for _, f := range r.Fields {
+		if f.Status != FieldValid {
+			r.Valid = false
+		}
+	}
+}
+
+func notSHA256(value string) string {
+	if b, err := hex.DecodeString(value); err != nil || len(b) != sha256.Size {
+		return "not a SHA-256 hash"
+	}
+	return ""
+}
+
+func missing(value string) string {
+	if value == "" {
+		return "missing"
+	}
+	return ""
+}
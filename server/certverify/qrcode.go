@@ -0,0 +1,162 @@
+package certverify
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// qrFormat marks the content of a certificate's QR code.
+const qrFormat = "dzap-qr-1"
+
+// QRCode is the compact form of a certificate printed as its QR code. A full
+// certificate, with its key and the post-wipe scan, is too large to scan, so
+// the QR code carries the signed fields with the post-wipe signature check
+// and the device details as the hashes the signature covers, the signature in
+// base64 and the fingerprint of the signing key. The PDF attachment keeps the
+// full certificate.
+type QRCode struct {
+	Format             string    `json:"dzap"`
+	Version            int       `json:"version,omitempty"`
+	JobID              string    `json:"jobId,omitempty"`
+	DeviceModel        string    `json:"deviceModel"`
+	DeviceSerial       string    `json:"deviceSerial"`
+	WipeMethod         string    `json:"wipeMethod"`
+	Timestamp          time.Time `json:"timestamp"`
+	VerificationHash   string    `json:"verificationHash"`
+	SignatureCheckHash string    `json:"signatureCheckHash,omitempty"`
+	DeviceHash         string    `json:"deviceHash,omitempty"`
+	Signature          string    `json:"signature"`
+	KeyFingerprint     string    `json:"keyFingerprint"`
+}
+
+// NewQRCode returns the compact form of cert.
+func NewQRCode(cert *Certificate) (*QRCode, error) {
+	var data Data
+	if err := json.Unmarshal(cert.Data, &data); err != nil {
+		return nil, fmt.Errorf("invalid certificate data: %w", err)
+	}
+	fields, err := data.signedFields()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(cert.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	key, err := ParsePublicKey([]byte(cert.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	return &QRCode{
+		Format:             qrFormat,
+		Version:            fields.Version,
+		JobID:              fields.JobID,
+		DeviceModel:        fields.DeviceModel,
+		DeviceSerial:       fields.DeviceSerial,
+		WipeMethod:         fields.WipeMethod,
+		Timestamp:          fields.Timestamp,
+		VerificationHash:   fields.VerificationHash,
+		SignatureCheckHash: fields.SignatureCheckHash,
+		DeviceHash:         fields.DeviceHash,
+		Signature:          base64.StdEncoding.EncodeToString(signature),
+		KeyFingerprint:     Fingerprint(key),
+	}, nil
+}
+
+func (q *QRCode) signedFields() signedFields {
+	return signedFields{
+		Version:            q.Version,
+		JobID:              q.JobID,
+		DeviceModel:        q.DeviceModel,
+		DeviceSerial:       q.DeviceSerial,
+		WipeMethod:         q.WipeMethod,
+		Timestamp:          q.Timestamp,
+		VerificationHash:   q.VerificationHash,
+		SignatureCheckHash: q.SignatureCheckHash,
+		DeviceHash:         q.DeviceHash,
+	}
+}
+
+// parseQRCode reads input as QR code content, reporting false for anything
+// else.
+func parseQRCode(input []byte) (*QRCode, bool) {
+	qr := &QRCode{}
+	if json.Unmarshal(bytes.TrimSpace(input), qr) != nil || qr.Format != qrFormat {
+		return nil, false
+	}
+	return qr, true
+}
+
+// verifyQRCode checks QR code content against the trusted keys.
+func verifyQRCode(qr *QRCode, trusted []TrustedKey, now time.Time) *Result {
+	result := &Result{Source: SourceQR, KeyFingerprint: qr.KeyFingerprint}
+	result.Data = &Data{
+		Version:          qr.Version,
+		JobID:            qr.JobID,
+		DeviceModel:      qr.DeviceModel,
+		DeviceSerial:     qr.DeviceSerial,
+		WipeMethod:       qr.WipeMethod,
+		Timestamp:        qr.Timestamp,
+		VerificationHash: qr.VerificationHash,
+	}
+
+	digest, digestErr := qr.signedFields().digest()
+	signature, sigErr := base64.StdEncoding.DecodeString(qr.Signature)
+
+	var signer *TrustedKey
+	if digestErr == nil && sigErr == nil {
+		for i := range trusted {
+			if rsa.VerifyPKCS1v15(trusted[i].Key, crypto.SHA256, digest, signature) == nil {
+				signer = &trusted[i]
+				break
+			}
+		}
+	}
+	if signer != nil {
+		result.Trusted, result.TrustedKey = true, signer.Name
+		result.SignatureValid = signer.Fingerprint == qr.KeyFingerprint
+	}
+
+	switch {
+	case signer != nil && !result.SignatureValid:
+		result.add("publicKey", qr.KeyFingerprint, FieldInvalid, "not the key that signed the certificate, which is trusted key "+signer.Name)
+	case signer != nil:
+		result.add("publicKey", qr.KeyFingerprint, FieldValid, "trusted key "+signer.Name)
+	default:
+		result.add("publicKey", qr.KeyFingerprint, FieldUnverified, "the QR code names its key by fingerprint only, and no trusted key verifies it")
+	}
+
+	switch {
+	case sigErr != nil:
+		result.add("signature", "", FieldInvalid, "not base64: "+sigErr.Error())
+	case digestErr != nil:
+		result.add("signature", "", FieldInvalid, digestErr.Error())
+	case signer != nil:
+		result.add("signature", "", FieldValid, "RSA PKCS#1 v1.5 over SHA-256")
+	default:
+		result.add("signature", "", FieldInvalid, "does not match the QR code's content with any trusted key")
+	}
+
+	signed := FieldUnverified
+	if signer != nil {
+		signed = FieldValid
+	}
+	check := result.checker(signed)
+	result.checkSignedFields(*result.Data, check, now)
+	// Only the hashes are signed here; the PDF or JSON has the content.
+	if qr.SignatureCheckHash != "" {
+		check("signatureCheck", qr.SignatureCheckHash, notSHA256(qr.SignatureCheckHash))
+	}
+	if qr.DeviceHash != "" {
+		check("device", qr.DeviceHash, notSHA256(qr.DeviceHash))
+	}
+
+	result.conclude()
+	return result
+}
@@ -0,0 +1,134 @@
+// Command dzap-verify checks DZap data destruction certificates offline.
+//
+//	dzap-verify [-trusted keys.pem]... [-json] certificate.json|certificate.pdf|-
+//
+// A certificate is given as its JSON file, its PDF, or the content of its QR
+// code ("-" reads standard input). Its signature is checked against the key
+// embedded in it and against the trusted keys: the files or directories of
+// *.pem files given with -trusted or, by default, ~/.config/DZap/public.pem
+// and ~/.config/DZap/trusted-keys. QR code content carries no key and is only
+// checked against the trusted keys. The exit status is 0 for a valid
+// certificate, 1 for an invalid one and 2 if it could not be checked.
+package main
+
+import (
+	"dzap-backend/certverify"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// pathList collects a repeatable flag.
+type pathList []string
+
+func (p *pathList) String() string { return strings.Join(*p, ",") }
+
+func (p *pathList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func main() {
+	var trustedPaths pathList
+	flag.Var(&trustedPaths, "trusted", "PEM file or directory of *.pem files with trusted public keys (repeatable; defaults to ~/.config/DZap/public.pem and ~/.config/DZap/trusted-keys)")
+	asJSON := flag.Bool("json", false, "print the result as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-trusted keys.pem]... [-json] certificate.json|certificate.pdf|-\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var input []byte
+	var err error
+	if name := flag.Arg(0); name == "-" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dzap-verify: %v\n", err)
+		os.Exit(2)
+	}
+
+	if len(trustedPaths) == 0 {
+		trustedPaths = defaultTrustedPaths()
+	}
+	keys, err := certverify.LoadTrustedKeys(trustedPaths...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dzap-verify: %v\n", err)
+		os.Exit(2)
+	}
+
+	result := certverify.Verify(input, keys, time.Now())
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	} else {
+		printResult(result, len(keys))
+	}
+	if !result.Valid {
+		os.Exit(1)
+	}
+}
+
+// defaultTrustedPaths are the station's own exported key and its trusted-key
+// directory, where they exist.
+func defaultTrustedPaths() []string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, name := range []string{"public.pem", "trusted-keys"} {
+		path := filepath.Join(configDir, "DZap", name)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func printResult(result *certverify.Result, trustedKeys int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, f := range result.Fields {
+		value := f.Value
+		if len(value) > 64 {
+			value = value[:61] + "..."
+		}
+		line := fmt.Sprintf("%s\t%s\t%s", f.Field, f.Status, value)
+		if f.Detail != "" {
+			line += " (" + f.Detail + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+	fmt.Println()
+
+	switch {
+	case result.Data == nil:
+		fmt.Println("INVALID: not a readable DZap certificate")
+	case result.Valid:
+		fmt.Printf("VALID: signed by trusted key %s\n", result.TrustedKey)
+	case trustedKeys == 0 && result.Source == certverify.SourceQR:
+		fmt.Println("NOT VERIFIED: a QR code carries no key; give the signing key with -trusted")
+	case trustedKeys == 0 && result.SignatureValid:
+		fmt.Println("NOT VERIFIED: the signature matches the embedded key, but no trusted keys were given (-trusted)")
+	case result.Trusted:
+		fmt.Printf("INVALID: signed by trusted key %s, but some fields fail their checks\n", result.TrustedKey)
+	case result.SignatureValid:
+		fmt.Println("UNTRUSTED: the signature matches the embedded key, which is not a trusted key")
+	default:
+		fmt.Println("INVALID: the signature does not match the certificate")
+	}
+}
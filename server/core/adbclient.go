@@ -0,0 +1,270 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The adb host protocol: every request is its length as four hex digits
+// followed by the request itself, and the server answers "OKAY" or "FAIL"
+// plus a length-prefixed message. After "host:transport:<serial>" the same
+// connection carries a request to the phone, such as "shell:<command>".
+
+// errAdbServerUnavailable is returned when no adb server answers.
+var errAdbServerUnavailable = errors.New("adb server unavailable")
+
+const (
+	adbDialTimeout  = 2 * time.Second
+	adbShellTimeout = 15 * time.Second
+	// adbRetry is how long device tracking waits before reconnecting.
+	adbRetry = 5 * time.Second
+)
+
+func adbDial() (net.Conn, error) {
+	addr := backend.AdbServer()
+	if addr == "" {
+		return nil, errAdbServerUnavailable
+	}
+	conn, err := net.DialTimeout("tcp", addr, adbDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errAdbServerUnavailable, err)
+	}
+	return conn, nil
+}
+
+// adbRequest sends one request and reads the server's answer.
+func adbRequest(conn net.Conn, request string) error {
+	if _, err := fmt.Fprintf(conn, "%04x%s", len(request), request); err != nil {
+		return fmt.Errorf("could not send %s to the adb server: %w", request, err)
+	}
+	var status [4]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		return fmt.Errorf("adb server closed the connection on %s: %w", request, err)
+	}
+	switch string(status[:]) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		msg, err := adbReadMessage(conn)
+		if err != nil {
+			return fmt.Errorf("adb %s failed", request)
+		}
+		return fmt.Errorf("adb %s failed: %s", request, msg)
+	}
+	return fmt.Errorf("unexpected adb server response %q to %s", status[:], request)
+}
+
+// adbReadMessage reads one length-prefixed message.
+func adbReadMessage(r io.Reader) (string, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("bad adb message length %q", header[:])
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// parseAdbDevices reads a device list, one "serial<TAB>state" per line.
+func parseAdbDevices(list string) map[string]string {
+	states := make(map[string]string)
+	for _, line := range strings.Split(list, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			states[fields[0]] = fields[1]
+		}
+	}
+	return states
+}
+
+// adbNativeShell runs a command on a phone through the adb server. The
+// "shell:" service merges stdout and stderr and does not report the exit
+// status, so it is only used for queries.
+func adbNativeShell(serial, command string) (out string, err error) {
+	started := time.Now()
+	defer func() {
+		if !errors.Is(err, errAdbServerUnavailable) {
+			recordTool("adb", []string{"host:transport:" + serial, "shell:" + command}, started, []byte(out), err)
+		}
+	}()
+	conn, err := adbDial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(adbShellTimeout))
+
+	if err := adbRequest(conn, "host:transport:"+serial); err != nil {
+		return "", err
+	}
+	if err := adbRequest(conn, "shell:"+command); err != nil {
+		return "", err
+	}
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("reading %q from %s failed: %w", command, serial, err)
+	}
+	// Older adbd runs shell commands on a pty, which ends lines with \r\n.
+	return strings.ReplaceAll(string(raw), "\r\n", "\n"), nil
+}
+
+// adbQuery runs a read-only shell command through the adb server, or through
+// the adb client when no server answers.
+func adbQuery(serial, command string) (string, error) {
+	out, err := adbNativeShell(serial, command)
+	if errors.Is(err, errAdbServerUnavailable) {
+		raw, err := backend.Output("adb", "-s", serial, "shell", command)
+		return string(raw), err
+	}
+	return out, err
+}
+
+// adbTracker is the live list of phones the adb server sees, kept current by
+// host:track-devices. Each usable phone's properties are read once, when it
+// comes online, rather than on every detection.
+var adbTracker = struct {
+	sync.Mutex
+	live    bool
+	states  map[string]string
+	devices map[string]MobileDevice
+}{}
+
+// startAdbTracking follows the adb server's device list in the background and
+// calls onChange after every change. If no server is running, "adb
+// start-server" is tried once; until tracking works, detection falls back to
+// the adb client.
+func startAdbTracking(onChange func()) {
+	if backend.AdbServer() == "" {
+		return
+	}
+	go func() {
+		triedStart := false
+		for {
+			err := trackAdbDevices(onChange)
+
+			adbTracker.Lock()
+			wasLive := adbTracker.live
+			adbTracker.live, adbTracker.states, adbTracker.devices = false, nil, nil
+			adbTracker.Unlock()
+			if wasLive {
+				log.Printf("Warning: lost the adb server, retrying every %s: %v", adbRetry, err)
+				onChange()
+			}
+
+			if errors.Is(err, errAdbServerUnavailable) && !triedStart {
+				triedStart = true
+				if _, err := backend.Output("adb", "start-server"); err == nil {
+					continue
+				}
+			}
+			time.Sleep(adbRetry)
+		}
+	}()
+}
+
+func trackAdbDevices(onChange func()) error {
+	conn, err := adbDial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := adbRequest(conn, "host:track-devices"); err != nil {
+		return err
+	}
+
+	for {
+		list, err := adbReadMessage(conn)
+		if err != nil {
+			return fmt.Errorf("device tracking stopped: %w", err)
+		}
+		states := parseAdbDevices(list)
+
+		adbTracker.Lock()
+		if !adbTracker.live {
+			log.Printf("Tracking Android devices through the adb server at %s", backend.AdbServer())
+			adbTracker.live = true
+			adbTracker.devices = make(map[string]MobileDevice)
+		}
+		// A phone that left the "device" state is read again when it returns.
+		for serial := range adbTracker.devices {
+			if states[serial] != MobileStateDevice {
+				delete(adbTracker.devices, serial)
+			}
+		}
+		adbTracker.states = states
+		adbTracker.Unlock()
+		onChange()
+	}
+}
+
+// refreshTrackedAdbDevice replaces the cached listing of a tracked phone.
+func refreshTrackedAdbDevice(device MobileDevice) {
+	adbTracker.Lock()
+	defer adbTracker.Unlock()
+	if _, cached := adbTracker.devices[device.Serial]; cached {
+		adbTracker.devices[device.Serial] = device
+	}
+}
+
+// trackedAdbStates returns the tracked device states, or false when tracking
+// is not running.
+func trackedAdbStates() (map[string]string, bool) {
+	adbTracker.Lock()
+	defer adbTracker.Unlock()
+	if !adbTracker.live {
+		return nil, false
+	}
+	states := make(map[string]string, len(adbTracker.states))
+	for serial, state := range adbTracker.states {
+		states[serial] = state
+	}
+	return states, true
+}
+
+// trackedAdbDevices lists the usable phones from the tracked states, reading
+// the properties and attestation of phones not seen before. A phone is read
+// again when it returns to the "device" state, and its attestation whenever
+// attestAndroid refreshes it before the phone is acted on.
+func trackedAdbDevices(states map[string]string) []MobileDevice {
+	serials := make([]string, 0, len(states))
+	for serial, state := range states {
+		if state == MobileStateDevice {
+			serials = append(serials, serial)
+		}
+	}
+	sort.Strings(serials)
+
+	var devices []MobileDevice
+	for _, serial := range serials {
+		adbTracker.Lock()
+		device, cached := adbTracker.devices[serial]
+		adbTracker.Unlock()
+		if !cached {
+			props, err := androidProps(serial)
+			if err != nil {
+				continue // Read again on the next detection
+			}
+			device = newAdbDevice(serial, props)
+			adbTracker.Lock()
+			if adbTracker.live && adbTracker.states[serial] == MobileStateDevice {
+				adbTracker.devices[serial] = device
+			}
+			adbTracker.Unlock()
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
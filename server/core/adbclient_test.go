@@ -0,0 +1,274 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// useBackend makes b the active backend for the rest of the test.
+func useBackend(t *testing.T, b Backend) {
+	t.Helper()
+	old, oldSysfs, oldProc := backend, sysfsRoot, procRoot
+	SetBackend(b)
+	t.Cleanup(func() { backend, sysfsRoot, procRoot = old, oldSysfs, oldProc })
+}
+
+// useSimBackend makes a simulated backend for the scenario in
+// testdata/<scenario> the active backend.
+func useSimBackend(t *testing.T, scenario string) *SimBackend {
+	t.Helper()
+	sim, err := NewSimBackend("testdata/"+scenario, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSimBackend: %v", err)
+	}
+	useBackend(t, sim)
+	return sim
+}
+
+// adbServerBackend points adb at a scripted server.
+type adbServerBackend struct {
+	Backend
+	addr string
+}
+
+func (b adbServerBackend) AdbServer() string { return b.addr }
+
+// adbExchange is one request a scripted adb server expects and its reply.
+type adbExchange struct {
+	request, reply string
+}
+
+// scriptedAdbServer accepts one connection, answers each exchange in turn
+// and hangs up.
+func scriptedAdbServer(t *testing.T, exchanges ...adbExchange) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, e := range exchanges {
+			if got, err := adbReadMessage(conn); err != nil || got != e.request {
+				t.Errorf("server got request %q (%v), want %q", got, err, e.request)
+				return
+			}
+			io.WriteString(conn, e.reply)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// adbMessage frames s as the adb server does.
+func adbMessage(s string) string {
+	return fmt.Sprintf("%04x%s", len(s), s)
+}
+
+func resetAdbTracker(t *testing.T) {
+	t.Cleanup(func() {
+		adbTracker.Lock()
+		adbTracker.live, adbTracker.states, adbTracker.devices = false, nil, nil
+		adbTracker.Unlock()
+	})
+}
+
+func TestAdbReadMessage(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "0005hello", want: "hello"},
+		{input: "0000", want: ""},
+		{input: "000dserial\tdevice", want: "serial\tdevice"},
+		{input: "00", wantErr: true},      // truncated length
+		{input: "0005hel", wantErr: true}, // truncated message
+		{input: "zzzzhello", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := adbReadMessage(strings.NewReader(tt.input))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("adbReadMessage(%q) = %q, %v; want %q, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAdbRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		wantErr string
+	}{
+		{name: "okay", reply: "OKAY"},
+		{name: "fail", reply: "FAIL" + adbMessage("device 'X' not found"), wantErr: "adb host:transport:X failed: device 'X' not found"},
+		{name: "fail without message", reply: "FAIL00", wantErr: "adb host:transport:X failed"},
+		{name: "truncated status", reply: "OK", wantErr: "closed the connection"},
+		{name: "unknown status", reply: "WHAT", wantErr: `unexpected adb server response "WHAT"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", scriptedAdbServer(t, adbExchange{"host:transport:X", tt.reply}))
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			err = adbRequest(conn, "host:transport:X")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("adbRequest: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("adbRequest error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdbNativeShell(t *testing.T) {
+	useSimBackend(t, "adb")
+
+	out, err := adbNativeShell("28131FDH2000QK", "getprop ro.product.model")
+	if err != nil || out != "Pixel 7\n" {
+		t.Errorf("getprop ro.product.model = %q, %v; want \"Pixel 7\\n\"", out, err)
+	}
+
+	_, err = adbNativeShell("missing", "getprop")
+	if err == nil || !strings.Contains(err.Error(), "device 'missing' not found") {
+		t.Errorf("shell on an unknown phone: error = %v, want the server's FAIL message", err)
+	}
+}
+
+func TestAdbNativeShellWithoutServer(t *testing.T) {
+	useBackend(t, adbServerBackend{Backend: hostBackend{}})
+	if _, err := adbNativeShell("28131FDH2000QK", "getprop"); !errors.Is(err, errAdbServerUnavailable) {
+		t.Errorf("error = %v, want errAdbServerUnavailable", err)
+	}
+}
+
+func TestAdbNativeShellScripted(t *testing.T) {
+	tests := []struct {
+		name      string
+		exchanges []adbExchange
+		want      string
+		wantErr   string
+	}{
+		{
+			name: "pty line endings",
+			exchanges: []adbExchange{
+				{"host:transport:A", "OKAY"},
+				{"shell:getprop", "OKAY[a]: [1]\r\n[b]: [2]\r\n"},
+			},
+			want: "[a]: [1]\n[b]: [2]\n",
+		},
+		{
+			name: "phone offline",
+			exchanges: []adbExchange{
+				{"host:transport:A", "FAIL" + adbMessage("device offline")},
+			},
+			wantErr: "device offline",
+		},
+		{
+			name: "hang-up before the shell",
+			exchanges: []adbExchange{
+				{"host:transport:A", "OKAY"},
+			},
+			wantErr: "closed the connection",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useBackend(t, adbServerBackend{Backend: hostBackend{}, addr: scriptedAdbServer(t, tt.exchanges...)})
+			out, err := adbNativeShell("A", "getprop")
+			switch {
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			case tt.wantErr == "" && (err != nil || out != tt.want):
+				t.Errorf("adbNativeShell = %q, %v; want %q", out, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackAdbDevices(t *testing.T) {
+	resetAdbTracker(t)
+	addr := scriptedAdbServer(t, adbExchange{"host:track-devices", "OKAY" +
+		adbMessage("A\tdevice\nB\tdevice\n") +
+		adbMessage("A\tdevice\nB\toffline\n") +
+		adbMessage("A\tdevice\n")})
+	useBackend(t, adbServerBackend{Backend: hostBackend{}, addr: addr})
+
+	var seen []map[string]string
+	var cachedB []bool
+	err := trackAdbDevices(func() {
+		states, ok := trackedAdbStates()
+		if !ok {
+			t.Fatal("tracking not live after a device list")
+		}
+		seen = append(seen, states)
+		adbTracker.Lock()
+		if len(seen) == 1 {
+			// As trackedAdbDevices would after reading both phones.
+			adbTracker.devices["A"] = MobileDevice{Serial: "A", State: MobileStateDevice}
+			adbTracker.devices["B"] = MobileDevice{Serial: "B", State: MobileStateDevice}
+		}
+		_, cached := adbTracker.devices["B"]
+		cachedB = append(cachedB, cached)
+		adbTracker.Unlock()
+	})
+	if err == nil || !strings.Contains(err.Error(), "device tracking stopped") {
+		t.Errorf("trackAdbDevices returned %v, want the stop error once the server hangs up", err)
+	}
+
+	if len(seen) != 3 {
+		t.Fatalf("got %d device lists, want 3", len(seen))
+	}
+	if seen[1]["B"] != "offline" {
+		t.Errorf("second list: B is %q, want offline", seen[1]["B"])
+	}
+	if _, ok := seen[2]["B"]; ok {
+		t.Errorf("third list still has the removed phone: %v", seen[2])
+	}
+	if want := []bool{true, false, false}; cachedB[0] != want[0] || cachedB[1] != want[1] || cachedB[2] != want[2] {
+		t.Errorf("B cached after each list = %v, want %v (dropped when it left the device state)", cachedB, want)
+	}
+	adbTracker.Lock()
+	_, cachedA := adbTracker.devices["A"]
+	adbTracker.Unlock()
+	if !cachedA {
+		t.Error("A was dropped from the cache while it stayed in the device state")
+	}
+}
+
+func TestTrackedAdbDevices(t *testing.T) {
+	useSimBackend(t, "adb")
+	resetAdbTracker(t)
+	adbTracker.Lock()
+	adbTracker.live = true
+	adbTracker.states = map[string]string{"28131FDH2000QK": MobileStateDevice, "emulator-5556": "offline"}
+	adbTracker.devices = make(map[string]MobileDevice)
+	adbTracker.Unlock()
+
+	states, _ := trackedAdbStates()
+	devices := trackedAdbDevices(states)
+	if len(devices) != 1 || devices[0].Serial != "28131FDH2000QK" || devices[0].Model != "Pixel 7" {
+		t.Fatalf("trackedAdbDevices = %+v, want only the Pixel 7", devices)
+	}
+	a := devices[0].Attestation
+	if a == nil || a.CryptoState != "encrypted" || a.GoogleAccounts != 1 {
+		t.Errorf("listing attestation = %+v, want the phone's encryption and accounts", a)
+	}
+	adbTracker.Lock()
+	_, cached := adbTracker.devices["28131FDH2000QK"]
+	adbTracker.Unlock()
+	if !cached {
+		t.Error("the phone was not cached for later detections")
+	}
+}
@@ -0,0 +1,460 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// androidWipeAction is DZap's own broadcast, not an Android one. Only a
+	// device-owner DPC built to handle it (calling DevicePolicyManager.wipeData
+	// and setting RESULT_OK) answers it; Android offers no shell command that
+	// wipes a phone, and an ordinary MDM app ignores the broadcast, in which
+	// case the reset falls back to the recovery command.
+	androidWipeAction = "dzap.intent.action.WIPE_DATA"
+	// androidFactoryResetAction makes the framework write --wipe_data to the
+	// bootloader message and reboot into recovery. Only root may send it.
+	androidFactoryResetAction = "android.intent.action.FACTORY_RESET"
+)
+
+// Android factory reset strategies.
+const (
+	ResetDeviceOwner = "device_owner"
+	ResetRecovery    = "recovery_command"
+)
+
+const (
+	androidPoll              = 2 * time.Second
+	androidDisconnectTimeout = 3 * time.Minute
+	// androidReconnectTimeout covers the wipe itself and the first boot, which
+	// re-creates the data partition.
+	androidReconnectTimeout = 30 * time.Minute
+	androidBootTimeout      = 5 * time.Minute
+	// androidUSBSettle is how long a new USB enumeration must last before it
+	// counts as the phone's return rather than a step of the reset.
+	androidUSBSettle = time.Minute
+)
+
+// Ways a phone's return after a reset is confirmed.
+const (
+	ReturnedViaAdb = "adb"
+	ReturnedViaUSB = "usb"
+)
+
+var deviceOwnerPattern = regexp.MustCompile(`admin=([^,\s]+),DeviceOwner`)
+
+// AndroidReset records how a phone was factory reset and how its return in
+// setup state was confirmed.
+type AndroidReset struct {
+	Strategy       string     `json:"strategy"`
+	Detail         string     `json:"detail,omitempty"`
+	TriggeredAt    time.Time  `json:"triggeredAt"`
+	DisconnectedAt *time.Time `json:"disconnectedAt,omitempty"`
+	ReconnectedAt  *time.Time `json:"reconnectedAt,omitempty"`
+	// ReturnedVia is "adb" when the phone came back with USB debugging on, or
+	// "usb" when it was only seen re-enumerating on USB with the same serial
+	// (USBDevice, as bus-device numbers); a reset turns USB debugging off.
+	ReturnedVia string `json:"returnedVia,omitempty"`
+	USBDevice   string `json:"usbDevice,omitempty"`
+	// SetupComplete and Provisioned are secure/user_setup_complete and
+	// global/device_provisioned once the phone is back over adb; neither is
+	// "1" in setup state.
+	SetupComplete string `json:"setupComplete,omitempty"`
+	Provisioned   string `json:"provisioned,omitempty"`
+	Confirmed     bool   `json:"confirmed"`
+}
+
+// AndroidAttestation is what a phone reports about itself before a wipe.
+// NIST SP 800-88 accepts a factory reset as sanitization only on devices that
+// encrypt their storage, since the reset works by discarding the keys.
+type AndroidAttestation struct {
+	Manufacturer   string `json:"manufacturer"`
+	AndroidVersion string `json:"androidVersion"`
+	SecurityPatch  string `json:"securityPatch,omitempty"`
+	// HardwareSerial is ro.serialno, which unlike the IMEI identifies the
+	// device without its radio.
+	HardwareSerial string `json:"hardwareSerial,omitempty"`
+	CryptoState    string `json:"cryptoState"`          // ro.crypto.state: encrypted, unencrypted or unsupported
+	CryptoType     string `json:"cryptoType,omitempty"` // ro.crypto.type: file or block
+	Encrypted      bool   `json:"encrypted"`
+	// GoogleAccounts counts the Google accounts on the phone. With one present,
+	// Factory Reset Protection asks for it after a reset not started from Settings.
+	GoogleAccounts int    `json:"googleAccounts"`
+	FRP            string `json:"frp"` // active, inactive or unsupported
+}
+
+// androidProps reads every system property of a phone.
+func androidProps(serial string) (map[string]string, error) {
+	out, err := adbQuery(serial, "getprop")
+	if err != nil {
+		return nil, fmt.Errorf("getprop failed: %w", err)
+	}
+	props := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if m := getpropLine.FindStringSubmatch(line); m != nil {
+			props[m[1]] = m[2]
+		}
+	}
+	if len(props) == 0 {
+		return nil, fmt.Errorf("getprop on %s returned no properties", serial)
+	}
+	return props, nil
+}
+
+var getpropLine = regexp.MustCompile(`^\[([^\]]+)\]: \[(.*)\]`)
+
+func androidAttestation(serial string, props map[string]string) *AndroidAttestation {
+	a := &AndroidAttestation{
+		Manufacturer:   props["ro.product.manufacturer"],
+		AndroidVersion: props["ro.build.version.release"],
+		SecurityPatch:  props["ro.build.version.security_patch"],
+		HardwareSerial: props["ro.serialno"],
+		CryptoState:    props["ro.crypto.state"],
+		CryptoType:     props["ro.crypto.type"],
+	}
+	if a.HardwareSerial == "" {
+		a.HardwareSerial = props["ro.boot.serialno"]
+	}
+	if a.CryptoState == "" {
+		a.CryptoState = "unsupported"
+	}
+	a.Encrypted = a.CryptoState == "encrypted"
+
+	// Only the number of accounts is kept, not who they belong to.
+	if out, err := adbQuery(serial, "dumpsys account"); err == nil {
+		a.GoogleAccounts = strings.Count(out, "type=com.google}")
+	}
+	switch {
+	case props["ro.frp.pst"] == "":
+		a.FRP = "unsupported"
+	case a.GoogleAccounts > 0:
+		a.FRP = "active"
+	default:
+		a.FRP = "inactive"
+	}
+	return a
+}
+
+// checkAndroidEncrypted refuses a factory reset of an unencrypted phone.
+func checkAndroidEncrypted(a *AndroidAttestation) error {
+	if a == nil {
+		return fmt.Errorf("the device's encryption state is unknown")
+	}
+	if !a.Encrypted {
+		return fmt.Errorf("storage is not encrypted (ro.crypto.state=%s); a factory reset would leave user data recoverable", a.CryptoState)
+	}
+	return nil
+}
+
+// androidResetPath is a way to factory reset a phone without anyone touching it.
+type androidResetPath struct {
+	Strategy string
+	Detail   string
+	owner    string // device-owner component
+}
+
+func adbShell(serial, command string) (string, error) {
+	out, err := backend.Output("adb", "-s", serial, "shell", command)
+	return strings.TrimSpace(string(out)), err
+}
+
+// findAndroidResetPaths lists the ways to reset a phone, in the order they
+// are tried: a device-owner wipe, which works on production builds when the
+// owner is a DPC that handles androidWipeAction, then a recovery command on
+// debuggable builds, where adbd can run as root.
+func findAndroidResetPaths(serial string) ([]*androidResetPath, error) {
+	var paths []*androidResetPath
+	if out, err := adbShell(serial, "dpm list-owners"); err == nil {
+		if m := deviceOwnerPattern.FindStringSubmatch(out); m != nil {
+			paths = append(paths, &androidResetPath{Strategy: ResetDeviceOwner, Detail: "device owner " + m[1], owner: m[1]})
+		}
+	}
+	if debuggable, _ := adbShell(serial, "getprop ro.debuggable"); debuggable == "1" {
+		paths = append(paths, &androidResetPath{Strategy: ResetRecovery, Detail: "debuggable build; adbd can run as root"})
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s has no device owner and is not a debuggable build; it can only be reset from the device itself", serial)
+	}
+	return paths, nil
+}
+
+// describeResetPaths lists the paths for preflight.
+func describeResetPaths(paths []*androidResetPath) string {
+	var details []string
+	for _, p := range paths {
+		if p.Strategy == ResetDeviceOwner {
+			details = append(details, p.Detail+" (must be a DPC that handles "+androidWipeAction+")")
+		} else {
+			details = append(details, p.Detail)
+		}
+	}
+	return strings.Join(details, ", then ")
+}
+
+// adbStates lists every device adb sees with its state ("device", "recovery",
+// "unauthorized", ...), unlike detectAndroidDevices which only lists usable ones.
+func adbStates() (map[string]string, error) {
+	if states, ok := trackedAdbStates(); ok {
+		return states, nil
+	}
+	out, err := backend.Output("adb", "devices")
+	if err != nil {
+		return nil, fmt.Errorf("adb devices failed: %w", err)
+	}
+	// The "List of devices attached" header does not parse as a device.
+	return parseAdbDevices(string(out)), nil
+}
+
+// sanitizeAndroid factory resets a phone and follows it through the reboot:
+// it must drop off the bus, come back, and report that setup has not been
+// completed before the reset counts as done.
+func sanitizeAndroid(config WipeConfig, progress chan<- string) (*AndroidReset, error) {
+	serial := config.DeviceSerial
+	props, err := androidProps(serial)
+	if err != nil {
+		return nil, err
+	}
+	attestation := androidAttestation(serial, props)
+	if err := checkAndroidEncrypted(attestation); err != nil {
+		return nil, err
+	}
+	paths, err := findAndroidResetPaths(serial)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controls := &WipeControls{
+		cancel: cancel,
+		pause:  make(chan bool), // A factory reset cannot be paused
+	}
+	wipeMutex.Lock()
+	activeWipes[config.DevicePath] = controls
+	wipeMutex.Unlock()
+
+	defer func() {
+		wipeMutex.Lock()
+		delete(activeWipes, config.DevicePath)
+		wipeMutex.Unlock()
+	}()
+
+	var reset *AndroidReset
+	for i, path := range paths {
+		reset = &AndroidReset{Strategy: path.Strategy, Detail: path.Detail}
+		progress <- fmt.Sprintf("Executing Android Factory Reset (NIST Clear) on device %s via %s...", serial, path.Detail)
+		err = triggerAndroidReset(serial, path)
+		if err == nil {
+			break
+		}
+		if i == len(paths)-1 {
+			return reset, err
+		}
+		progress <- fmt.Sprintf("%v; falling back to %s.", err, paths[i+1].Detail)
+	}
+	reset.TriggeredAt = time.Now().UTC()
+	reportAndroidReset(config, "Factory reset started", 10, progress)
+
+	if err := trackAndroidReset(ctx, config, []string{serial, attestation.HardwareSerial}, reset, progress); err != nil {
+		return reset, err
+	}
+
+	completion := WipeProgress{
+		DeviceID: config.DevicePath,
+		Status:   "done",
+		Progress: 100,
+	}
+	jsonMsg, _ := json.Marshal(completion)
+	progress <- string(jsonMsg)
+	return reset, nil
+}
+
+func triggerAndroidReset(serial string, path *androidResetPath) error {
+	if path.Strategy == ResetDeviceOwner {
+		out, err := adbShell(serial, fmt.Sprintf("am broadcast -a %s -n %s", androidWipeAction, path.owner))
+		if err != nil {
+			return fmt.Errorf("wipe broadcast to the device owner failed: %w. Output: %s", err, out)
+		}
+		if !strings.Contains(out, "result=-1") {
+			return fmt.Errorf("device owner %s did not accept the wipe request: %s", path.owner, out)
+		}
+		return nil
+	}
+
+	if out, err := backend.Output("adb", "-s", serial, "root"); err != nil {
+		return fmt.Errorf("adb root failed: %w. Output: %s", err, strings.TrimSpace(string(out)))
+	}
+	// adbd restarts as root, so the phone briefly disappears.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := backend.CombinedOutput(ctx, "adb", "-s", serial, "wait-for-device"); err != nil {
+		return fmt.Errorf("device did not come back after adb root: %w", err)
+	}
+	if uid, _ := adbShell(serial, "id -u"); uid != "0" {
+		return fmt.Errorf("adbd is not running as root (uid %q)", uid)
+	}
+
+	// A/B devices have no /cache; there the framework writes the command to
+	// the bootloader message instead.
+	if _, err := adbShell(serial, "test -d /cache/recovery"); err != nil {
+		out, err := adbShell(serial, fmt.Sprintf("am broadcast -a %s -p android", androidFactoryResetAction))
+		if err != nil {
+			return fmt.Errorf("factory reset broadcast failed: %w. Output: %s", err, out)
+		}
+		return nil
+	}
+	if out, err := adbShell(serial, "echo --wipe_data > /cache/recovery/command"); err != nil {
+		return fmt.Errorf("could not write the recovery command: %w. Output: %s", err, out)
+	}
+	if _, err := backend.Output("adb", "-s", serial, "reboot", "recovery"); err != nil {
+		return fmt.Errorf("failed to reboot into recovery: %w", err)
+	}
+	return nil
+}
+
+// usbEnumeration returns the bus and device number ("1-7") under which a USB
+// device with one of serials is enumerated, or "" if none is. The device
+// number changes every time the device re-enumerates.
+func usbEnumeration(serials []string) string {
+	dirs, _ := filepath.Glob(filepath.Join(sysfsRoot, "bus", "usb", "devices", "*"))
+	for _, dir := range dirs {
+		found := readTrimmedFile(filepath.Join(dir, "serial"))
+		for _, serial := range serials {
+			if serial != "" && found == serial {
+				return readTrimmedFile(filepath.Join(dir, "busnum")) + "-" + readTrimmedFile(filepath.Join(dir, "devnum"))
+			}
+		}
+	}
+	return ""
+}
+
+// trackAndroidReset waits for the phone to leave and to return. A reset
+// turns USB debugging off, so adb usually never sees the phone again: its
+// return is confirmed when it re-enumerates on USB with the same serial and
+// stays enumerated for androidUSBSettle, outside recovery and the
+// bootloader. If adb does see it again, the job also waits for the boot to
+// finish and checks that the phone is in setup state.
+func trackAndroidReset(ctx context.Context, config WipeConfig, serials []string, reset *AndroidReset, progress chan<- string) error {
+	serial := config.DeviceSerial
+	poll := func(timeout time.Duration, done func(states map[string]string) bool) error {
+		deadline := time.Now().Add(timeout)
+		for {
+			if states, err := adbStates(); err == nil && done(states) {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out after %s", timeout)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(androidPoll):
+			}
+		}
+	}
+
+	// The enumeration the phone had before it left does not count as a return.
+	excluded := make(map[string]bool)
+	if err := poll(androidDisconnectTimeout, func(states map[string]string) bool {
+		if states[serial] == "device" {
+			if usb := usbEnumeration(serials); usb != "" {
+				excluded[usb] = true
+			}
+			return false
+		}
+		return true
+	}); err != nil {
+		return fmt.Errorf("device %s never disconnected, so the reset did not start: %w", serial, err)
+	}
+	disconnected := time.Now().UTC()
+	reset.DisconnectedAt = &disconnected
+	reportAndroidReset(config, "Device disconnected; waiting for it to return", 30, progress)
+
+	last, usb := "", ""
+	var usbSince time.Time
+	err := poll(androidReconnectTimeout, func(states map[string]string) bool {
+		state := states[serial]
+		if state != last && state != "" {
+			progress <- fmt.Sprintf("Device %s is in state %q", serial, state)
+		}
+		last = state
+		if state == "device" {
+			reset.ReturnedVia = ReturnedViaAdb
+			return true
+		}
+
+		current := usbEnumeration(serials)
+		if state == "recovery" || state == "sideload" || state == "bootloader" || state == "rescue" {
+			excluded[current] = true
+		}
+		if current != usb {
+			usb, usbSince = current, time.Now()
+			if current != "" && !excluded[current] {
+				progress <- fmt.Sprintf("Device %s re-enumerated on USB as %s", serial, current)
+			}
+		}
+		if usb != "" && !excluded[usb] && time.Since(usbSince) >= androidUSBSettle {
+			reset.ReturnedVia, reset.USBDevice = ReturnedViaUSB, usb
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return fmt.Errorf("device %s did not come back, so the reset could not be confirmed: %w", serial, err)
+	}
+	reconnected := time.Now().UTC()
+	reset.ReconnectedAt = &reconnected
+
+	if reset.ReturnedVia == ReturnedViaUSB {
+		reset.Confirmed = true
+		progress <- fmt.Sprintf("Device %s is back on USB (%s) after the reset. USB debugging is off, as after a factory reset, so its setup state cannot be read.", serial, reset.USBDevice)
+		return nil
+	}
+	reportAndroidReset(config, "Device reconnected; waiting for boot to complete", 80, progress)
+
+	deadline := time.Now().Add(androidBootTimeout)
+	for {
+		if booted, _ := adbShell(serial, "getprop sys.boot_completed"); booted == "1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("device %s did not finish booting within %s", serial, androidBootTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(androidPoll):
+		}
+	}
+
+	reset.SetupComplete, _ = adbShell(serial, "settings get secure user_setup_complete")
+	reset.Provisioned, _ = adbShell(serial, "settings get global device_provisioned")
+	if reset.SetupComplete == "1" || reset.Provisioned == "1" {
+		return fmt.Errorf("device %s came back already set up (user_setup_complete=%s, device_provisioned=%s); user data was not wiped",
+			serial, reset.SetupComplete, reset.Provisioned)
+	}
+	reset.Confirmed = true
+	progress <- fmt.Sprintf("Device %s is back in setup state.", serial)
+	return nil
+}
+
+func reportAndroidReset(config WipeConfig, status string, percent float64, progress chan<- string) {
+	update := WipeProgress{
+		DeviceID:    config.DevicePath,
+		DeviceModel: config.DeviceModel,
+		Method:      config.Method,
+		MethodName:  getWipeMethodName(config.Method),
+		Status:      status,
+		Progress:    percent,
+		CurrentPass: 1,
+		TotalPasses: 1,
+	}
+	msg, _ := json.Marshal(update)
+	progress <- string(msg)
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Backend is everything DZap needs from the machine it runs on: the disk tools
+// it shells out to, the sysfs/procfs trees it reads, and the device nodes it
+// opens. The host backend talks to real hardware; SimBackend replays recorded
+// tool output against file-backed disks so the app can run without root.
+type Backend interface {
+	Name() string
+	// Output runs a tool and returns its stdout.
+	Output(name string, args ...string) ([]byte, error)
+	// CombinedOutput runs a tool until it exits or ctx is cancelled and
+	// returns stdout and stderr together.
+	CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error)
+	// StreamOutput runs a tool like CombinedOutput, but hands each line of its
+	// output to onLine as it is printed. Carriage returns end a line too, so
+	// progress meters that redraw in place are seen.
+	StreamOutput(ctx context.Context, onLine func(string), name string, args ...string) error
+	LookPath(name string) (string, error)
+	// DevicePath maps a /dev path to the file that should actually be opened.
+	DevicePath(path string) string
+	// DeviceRange is the byte range of that file the device occupies, or a
+	// zero length when it is the whole file.
+	DeviceRange(path string) (offset, length int64)
+	// ResetZones resets every sequential zone of the zoned device open as
+	// file (opened from path), through that file rather than the path.
+	ResetZones(file *os.File, path string) error
+	// ZoneWriter returns what writes to the zoned device open as file
+	// (opened from path) go through. Real drives enforce their write
+	// pointers themselves, so the host backend writes to file directly.
+	ZoneWriter(file *os.File, path string) DeviceWriter
+	SysfsRoot() string
+	ProcRoot() string
+	// AdbServer is the address of the adb server's host-protocol socket, or
+	// "" when there is none.
+	AdbServer() string
+	// Uevents streams device hot-plug events until the source fails, when the
+	// channel is closed.
+	Uevents() (<-chan Uevent, error)
+}
+
+// DeviceWriter is an open device as overwrites see it.
+type DeviceWriter interface {
+	io.WriterAt
+	Sync() error
+}
+
+var backend Backend = recordingBackend{hostBackend{}}
+
+// SetBackend replaces the active backend. It must be called before serving requests.
+func SetBackend(b Backend) {
+	backend = recordingBackend{b}
+	sysfsRoot = b.SysfsRoot()
+	procRoot = b.ProcRoot()
+}
+
+// CurrentBackend returns the name of the active backend.
+func CurrentBackend() string {
+	return backend.Name()
+}
+
+type hostBackend struct{}
+
+func (hostBackend) Name() string { return "host" }
+
+func (hostBackend) Output(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+func (hostBackend) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+func (hostBackend) StreamOutput(ctx context.Context, onLine func(string), name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(out)
+	scanner.Split(scanOutputLines)
+	for scanner.Scan() {
+		onLine(scanner.Text())
+	}
+	return cmd.Wait()
+}
+
+// scanOutputLines is bufio.ScanLines, but also splits on '\r'.
+func scanOutputLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func (hostBackend) LookPath(name string) (string, error) { return exec.LookPath(name) }
+
+func (hostBackend) DevicePath(path string) string { return path }
+
+func (hostBackend) DeviceRange(path string) (int64, int64) { return 0, 0 }
+
+func (hostBackend) ZoneWriter(file *os.File, path string) DeviceWriter { return file }
+
+func (hostBackend) SysfsRoot() string { return "/sys" }
+
+func (hostBackend) ProcRoot() string { return "/proc" }
+
+// AdbServer honours the environment variables the adb client uses to find
+// its server.
+func (hostBackend) AdbServer() string {
+	if socket, ok := strings.CutPrefix(os.Getenv("ADB_SERVER_SOCKET"), "tcp:"); ok {
+		if !strings.Contains(socket, ":") {
+			return net.JoinHostPort("127.0.0.1", socket)
+		}
+		return socket
+	}
+	host, port := os.Getenv("ANDROID_ADB_SERVER_ADDRESS"), os.Getenv("ANDROID_ADB_SERVER_PORT")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if port == "" {
+		port = "5037"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// exitCode extracts a tool's exit status from a backend error, or -1.
+func exitCode(err error) int {
+	if e, ok := err.(interface{ ExitCode() int }); ok {
+		return e.ExitCode()
+	}
+	return -1
+}
@@ -5,15 +5,18 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"crypto/x509"
+	"dzap-backend/certverify"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"html/template"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jung-kurt/gofpdf"
@@ -28,6 +31,9 @@ func init() {
 	if err != nil {
 		log.Fatalf("FATAL: Could not load or generate the application private key: %v", err)
 	}
+	if err := exportPublicKey(); err != nil {
+		log.Printf("Warning: could not export the certificate public key: %v", err)
+	}
 }
 
 func loadOrGeneratePrivateKey() (*rsa.PrivateKey, error) {
@@ -71,12 +77,86 @@ func loadOrGeneratePrivateKey() (*rsa.PrivateKey, error) {
 	return privateKey, nil
 }
 
+// publicKeyPEM returns the public half of the signing key, as embedded in
+// certificates.
+func publicKeyPEM() ([]byte, error) {
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&appPrivateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pubKeyBytes,
+	}), nil
+}
+
+// exportPublicKey writes the public key to ~/.config/DZap/public.pem, to be
+// handed to whoever verifies this station's certificates.
+func exportPublicKey() error {
+	pubKeyPem, err := publicKeyPEM()
+	if err != nil {
+		return err
+	}
+	path, err := configPath("public.pem")
+	if err != nil {
+		return err
+	}
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, pubKeyPem) {
+		return nil
+	}
+	return os.WriteFile(path, pubKeyPem, 0644)
+}
+
+// TrustedCertificateKeys returns the keys certificates are verified against:
+// this station's own key, and the PEM files in ~/.config/DZap/trusted-keys.
+func TrustedCertificateKeys() ([]certverify.TrustedKey, error) {
+	pubKeyPem, err := publicKeyPEM()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := certverify.ParsePublicKeys("this station", pubKeyPem)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := configPath("trusted-keys")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return keys, nil
+	}
+	others, err := certverify.LoadTrustedKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, others...), nil
+}
+
+// VerifyCertificate checks a certificate given as JSON, QR code content or
+// PDF against the trusted keys.
+func VerifyCertificate(input []byte) (*certverify.Result, error) {
+	keys, err := TrustedCertificateKeys()
+	if err != nil {
+		return nil, fmt.Errorf("could not load trusted keys: %w", err)
+	}
+	return certverify.Verify(input, keys, time.Now()), nil
+}
+
 type CertificateData struct {
+	// Version is the certverify format the certificate was signed in.
+	Version int `json:"version,omitempty"`
+	// JobID identifies the job the certificate was issued for, and is also
+	// the certificate's ID.
+	JobID            string    `json:"jobId,omitempty"`
 	DeviceModel      string    `json:"deviceModel"`
 	DeviceSerial     string    `json:"deviceSerial"`
 	WipeMethod       string    `json:"wipeMethod"`
 	Timestamp        time.Time `json:"timestamp"`
 	VerificationHash string    `json:"verificationHash"`
+	// SignatureCheck is the post-wipe scan showing no recognizable signatures remain.
+	SignatureCheck *SignatureScan `json:"signatureCheck,omitempty"`
+	// Device is the backend-detected hardware identity of the wiped device.
+	Device *DeviceDetails `json:"device,omitempty"`
 }
 
 type SignedCertificate struct {
@@ -86,13 +166,17 @@ type SignedCertificate struct {
 	QRCodePNG []byte          `json:"-"` // Exclude QR from JSON response
 }
 
-func GenerateCertificate(model, serial, method, logHash string) (*SignedCertificate, error) {
+func GenerateCertificate(jobID, model, serial, method, logHash string, signatureCheck *SignatureScan, device *DeviceDetails) (*SignedCertificate, error) {
 	certData := CertificateData{
+		Version:          certverify.CurrentVersion,
+		JobID:            jobID,
 		DeviceModel:      model,
 		DeviceSerial:     serial,
 		WipeMethod:       method,
 		Timestamp:        time.Now().UTC(),
 		VerificationHash: logHash,
+		SignatureCheck:   signatureCheck,
+		Device:           device,
 	}
 
 	hash, err := hashCertificateData(certData)
@@ -106,14 +190,10 @@ func GenerateCertificate(model, serial, method, logHash string) (*SignedCertific
 	}
 	signature := hex.EncodeToString(signatureBytes)
 
-	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&appPrivateKey.PublicKey)
+	pubKeyPem, err := publicKeyPEM()
 	if err != nil {
 		return nil, err
 	}
-	pubKeyPem := pem.EncodeToMemory(&pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: pubKeyBytes,
-	})
 
 	signedCert := &SignedCertificate{
 		Data:      certData,
@@ -121,54 +201,193 @@ func GenerateCertificate(model, serial, method, logHash string) (*SignedCertific
 		PublicKey: string(pubKeyPem),
 	}
 
-	// Generate QR Code from the JSON representation of the certificate
-	certJSON, err := json.Marshal(signedCert)
+	if err := signedCert.renderQRCode(); err != nil {
+		return nil, err
+	}
+	return signedCert, nil
+}
+
+// renderQRCode encodes the certificate's compact form as a QR code; the full
+// certificate is too large to scan and is attached to the PDF instead. The
+// code is not stored, so loaded certificates render it again.
+func (sc *SignedCertificate) renderQRCode() error {
+	certJSON, err := json.Marshal(sc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal certificate to JSON for QR code: %w", err)
+		return fmt.Errorf("failed to marshal certificate to JSON for QR code: %w", err)
+	}
+	cert, _, err := certverify.Parse(certJSON)
+	if err != nil {
+		return err
+	}
+	qr, err := certverify.NewQRCode(cert)
+	if err != nil {
+		return fmt.Errorf("failed to build QR code content: %w", err)
+	}
+	content, err := json.Marshal(qr)
+	if err != nil {
+		return fmt.Errorf("failed to marshal QR code content: %w", err)
 	}
 
-	qrBytes, err := qrcode.Encode(string(certJSON), qrcode.Medium, 256)
+	qrBytes, err := qrcode.Encode(string(content), qrcode.Medium, 512)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate QR code: %w", err)
+		return fmt.Errorf("failed to generate QR code: %w", err)
 	}
-	signedCert.QRCodePNG = qrBytes
+	sc.QRCodePNG = qrBytes
+	return nil
+}
 
-	return signedCert, nil
+// LoadCertificate reads a stored certificate by its ID, the ID of its job.
+func LoadCertificate(id string) (*SignedCertificate, error) {
+	if strings.ContainsAny(id, `/\`) || id == "" || strings.HasPrefix(id, ".") {
+		return nil, fmt.Errorf("invalid certificate id %q", id)
+	}
+	path, err := configPath("certificates", id+".json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s not found", id)
+	}
+	cert := &SignedCertificate{}
+	if err := json.Unmarshal(data, cert); err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %w", id, err)
+	}
+	if err := cert.renderQRCode(); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// IssueJobCertificate signs a certificate for a succeeded job from the
+// backend's own job record, stores it as ~/.config/DZap/certificates/<job>.json
+// and records the path on the job. Its verification hash is the final hash of
+// the job's log, which is checked first.
+func IssueJobCertificate(jobID string) (*SignedCertificate, error) {
+	job, err := GetJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != JobSucceeded {
+		return nil, fmt.Errorf("job %s has not succeeded (status %s)", job.ID, job.Status)
+	}
+
+	model, serial := job.Config.DeviceModel, job.Config.Identity.Serial
+	if job.Device != nil {
+		model, serial = job.Device.Model, job.Device.Serial
+	}
+	logHash, err := job.VerifiedLogHash()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := GenerateCertificate(job.ID, model, serial, getWipeMethodName(job.Config.Method), logHash, job.SignaturesAfter, job.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := configPath("certificates")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create certificates directory: %w", err)
+	}
+	data, err := json.MarshalIndent(cert, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate: %w", err)
+	}
+	path := filepath.Join(dir, job.ID+".json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("could not save certificate: %w", err)
+	}
+
+	jobsMutex.Lock()
+	live := jobs[job.ID]
+	jobsMutex.Unlock()
+	if live != nil {
+		live.update(func(j *WipeJob) { j.CertificatePath = path })
+	} else {
+		job.CertificatePath = path
+		job.save()
+	}
+	return cert, nil
 }
 
+// hashCertificateData returns the digest the certificate's signature covers,
+// as certverify computes it when checking the certificate.
 func hashCertificateData(data CertificateData) ([]byte, error) {
-	payload := fmt.Sprintf("%s|%s|%s|%s|%s", data.DeviceModel, data.DeviceSerial, data.WipeMethod, data.Timestamp.Format(time.RFC3339), data.VerificationHash)
-	hash := sha256.Sum256([]byte(payload))
-	return hash[:], nil
+	signed := certverify.Data{
+		Version:          data.Version,
+		JobID:            data.JobID,
+		DeviceModel:      data.DeviceModel,
+		DeviceSerial:     data.DeviceSerial,
+		WipeMethod:       data.WipeMethod,
+		Timestamp:        data.Timestamp,
+		VerificationHash: data.VerificationHash,
+	}
+	var err error
+	if data.SignatureCheck != nil {
+		if signed.SignatureCheck, err = json.Marshal(data.SignatureCheck); err != nil {
+			return nil, fmt.Errorf("failed to encode signature check: %w", err)
+		}
+	}
+	if data.Device != nil {
+		if signed.Device, err = json.Marshal(data.Device); err != nil {
+			return nil, fmt.Errorf("failed to encode device details: %w", err)
+		}
+	}
+	return certverify.Digest(signed)
 }
 
 func (sc *SignedCertificate) GeneratePDF() ([]byte, error) {
 	pdf := gofpdf.New("P", "mm", "A4", "")
+	// The certificate itself is attached, so the PDF can be verified as is.
+	certJSON, err := json.Marshal(sc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate: %w", err)
+	}
+	pdf.SetAttachments([]gofpdf.Attachment{{
+		Content:     certJSON,
+		Filename:    "certificate.json",
+		Description: "Signed DZap certificate",
+	}})
 	pdf.AddPage()
 	pdf.SetFont("Arial", "B", 20)
 	pdf.Cell(0, 20, "Data Destruction Certificate")
 	pdf.Ln(25)
 
 	// --- Certificate Details ---
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(40, 10, "Device Model:")
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(0, 10, sc.Data.DeviceModel)
-	pdf.Ln(8)
-	// ... (Add other fields: Serial, Method, Timestamp) ...
-	pdf.Ln(15)
+	// Values stop short of the QR code on the right.
+	field := func(label, value string) {
+		pdf.SetFont("Arial", "B", 12)
+		pdf.Cell(40, 10, label)
+		pdf.SetFont("Arial", "", 12)
+		pdf.Cell(95, 10, value)
+		pdf.Ln(8)
+	}
+	for _, f := range sc.fields() {
+		field(f.Label+":", f.Value)
+	}
+	pdf.Ln(7)
 
 	// --- QR Code for Verification ---
 	// The RegisterImageOptionsReader allows embedding an image from a byte slice in memory
 	pdf.RegisterImageOptionsReader("qr_code", gofpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(sc.QRCodePNG))
 	// Place the image on the PDF (x, y, width, height)
 	pdf.ImageOptions("qr_code", 150, 25, 40, 40, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	y := pdf.GetY()
 	pdf.SetFont("Arial", "I", 9)
 	pdf.SetXY(150, 65)
 	pdf.Cell(40, 10, "Scan to Verify")
 
-	// --- Digital Signature ---
-	pdf.SetXY(10, 100) // Reset position
+	// --- Verification Hash and Digital Signature ---
+	pdf.SetXY(10, max(y, 80))
+	pdf.SetFont("Arial", "B", 10)
+	pdf.MultiCell(0, 5, "Verification Hash (final hash of the wipe log):", "", "L", false)
+	pdf.SetFont("Courier", "", 8)
+	pdf.MultiCell(0, 4, sc.Data.VerificationHash, "1", "L", false)
+	pdf.Ln(5)
+
 	pdf.SetFont("Arial", "B", 10)
 	pdf.MultiCell(0, 5, "Digital Signature (SHA256withRSA):", "", "L", false)
 	pdf.SetFont("Courier", "", 8)
@@ -186,3 +405,89 @@ func (sc *SignedCertificate) GeneratePDF() ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// certificateField is one labelled line of a rendered certificate.
+type certificateField struct {
+	Label string
+	Value string
+}
+
+// fields lists what the PDF and HTML renderings show, in order.
+func (sc *SignedCertificate) fields() []certificateField {
+	d := sc.Data
+	fields := []certificateField{
+		{"Certificate", d.JobID},
+		{"Device Model", d.DeviceModel},
+		{"Serial", d.DeviceSerial},
+		{"Method", d.WipeMethod},
+		{"Completed", d.Timestamp.Format("2006-01-02 15:04:05 MST")},
+	}
+	if dev := d.Device; dev != nil {
+		if dev.Vendor != "" {
+			fields = append(fields, certificateField{"Vendor", dev.Vendor})
+		}
+		if dev.Size > 0 {
+			fields = append(fields, certificateField{"Capacity", fmt.Sprintf("%d bytes", dev.Size)})
+		}
+		if dev.Transport != "" {
+			fields = append(fields, certificateField{"Transport", dev.Transport})
+		}
+	}
+	if d.SignatureCheck != nil {
+		fields = append(fields, certificateField{"Signature Check", d.SignatureCheck.Summary()})
+	}
+	if a := d.Device.android(); a != nil {
+		fields = append(fields,
+			certificateField{"Android", fmt.Sprintf("%s, security patch %s, serial %s", a.AndroidVersion, a.SecurityPatch, a.HardwareSerial)},
+			certificateField{"Encryption", fmt.Sprintf("%s (%s), FRP %s", a.CryptoState, a.CryptoType, a.FRP)},
+		)
+	}
+	return fields
+}
+
+var certificateHTML = template.Must(template.New("certificate").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Data Destruction Certificate {{.Data.JobID}}</title>
+<style>
+body { font-family: sans-serif; max-width: 52em; margin: 2em auto; color: #222; }
+table { border-collapse: collapse; }
+th { text-align: left; padding: .3em 1.5em .3em 0; white-space: nowrap; }
+td { padding: .3em 0; }
+.qr { float: right; text-align: center; font-style: italic; font-size: .8em; }
+pre { white-space: pre-wrap; word-break: break-all; border: 1px solid #999; padding: .5em; font-size: .75em; }
+</style>
+</head>
+<body>
+<div class="qr"><img src="data:image/png;base64,{{.QRCode}}" width="160" height="160" alt="Certificate QR code"><br>Scan to Verify</div>
+<h1>Data Destruction Certificate</h1>
+<table>
+{{range .Fields}}<tr><th>{{.Label}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+<h3>Verification Hash (final hash of the wipe log)</h3>
+<pre>{{.Data.VerificationHash}}</pre>
+<h3>Digital Signature (SHA256withRSA)</h3>
+<pre>{{.Signature}}</pre>
+<h3>Public Key</h3>
+<pre>{{.PublicKey}}</pre>
+</body>
+</html>
+`))
+
+// GenerateHTML renders the certificate as a standalone HTML page with the QR
+// code inlined.
+func (sc *SignedCertificate) GenerateHTML() ([]byte, error) {
+	var buf bytes.Buffer
+	err := certificateHTML.Execute(&buf, map[string]interface{}{
+		"Data":      sc.Data,
+		"Fields":    sc.fields(),
+		"QRCode":    base64.StdEncoding.EncodeToString(sc.QRCodePNG),
+		"Signature": sc.Signature,
+		"PublicKey": sc.PublicKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render certificate: %w", err)
+	}
+	return buf.Bytes(), nil
+}
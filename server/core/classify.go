@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// classifyDrive decides a drive's type from lsblk and sysfs, and records why.
+// The rules run from the most to the least specific: what the device is
+// (optical, loop, device-mapper, zoned) wins over how it is attached.
+func classifyDrive(dev *lsblkDevice) (DriveType, []string) {
+	sys := filepath.Join(sysfsRoot, "class", "block", dev.Name)
+
+	switch {
+	case dev.Type == "rom" || strings.HasPrefix(dev.Name, "sr"):
+		return OPTICAL, []string{fmt.Sprintf("lsblk type %q: optical drive", dev.Type)}
+	case dev.Type == "loop" || strings.HasPrefix(dev.Name, "loop"):
+		reason := "loop device"
+		if file := readTrimmedFile(filepath.Join(sys, "loop", "backing_file")); file != "" {
+			reason += " backed by " + file
+		}
+		return LOOP, []string{reason}
+	case isDeviceMapperType(dev.Type) || strings.HasPrefix(dev.Name, "dm-"):
+		return DM, []string{fmt.Sprintf("device-mapper device (lsblk type %q)", dev.Type)}
+	}
+
+	if zoned := readTrimmedFile(filepath.Join(sys, "queue", "zoned")); zoned != "" && zoned != "none" {
+		return ZONED, []string{fmt.Sprintf("queue/zoned is %q", zoned)}
+	}
+
+	switch {
+	case strings.HasPrefix(dev.Name, "nvme"):
+		return NVME, []string{"nvme namespace"}
+	case strings.HasPrefix(dev.Name, "vd"):
+		return VIRTIO, []string{"virtio block device (vd*)"}
+	case strings.HasPrefix(dev.Name, "xvd"):
+		return XEN, []string{"Xen virtual block device (xvd*)"}
+	case strings.HasPrefix(dev.Name, "mmcblk"):
+		return MMC, []string{"MMC/SD block device (mmcblk*)"}
+	case strings.HasPrefix(dev.Name, "zram") || strings.HasPrefix(dev.Name, "ram"):
+		return UNKN, []string{"RAM-backed block device"}
+	case dev.Tran == "usb":
+		return USB, []string{"attached over USB"}
+	case dev.Tran == "sas":
+		return SAS, []string{"attached over SAS", rotationReason(dev.Rotational)}
+	}
+
+	reasons := []string{rotationReason(dev.Rotational)}
+	if dev.Tran != "" {
+		reasons = append([]string{fmt.Sprintf("transport %q", dev.Tran)}, reasons...)
+	}
+	if dev.Rotational {
+		return HDD, reasons
+	}
+	return SSD, reasons
+}
+
+func isDeviceMapperType(lsblkType string) bool {
+	switch lsblkType {
+	case "dm", "lvm", "crypt", "mpath":
+		return true
+	}
+	return false
+}
+
+func rotationReason(rotational bool) string {
+	if rotational {
+		return "rotational flag set"
+	}
+	return "rotational flag clear"
+}
+
+// listedDevice reports whether a top-level lsblk entry is a wipe candidate.
+// Read-only loop devices (snap and squashfs images) are left out entirely.
+func listedDevice(dev *lsblkDevice) bool {
+	switch {
+	case dev.Type == "disk", dev.Type == "rom":
+		return true
+	case dev.Type == "loop":
+		return dev.Size > 0 && !dev.ReadOnly
+	default:
+		return isDeviceMapperType(dev.Type)
+	}
+}
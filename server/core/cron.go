@@ -0,0 +1,127 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five-field cron expression
+// (minute hour day-of-month month day-of-week), evaluated in local time.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCron accepts "*", numbers, ranges ("1-5"), lists ("1,15") and steps
+// ("*/10", "8-18/2") in each field. Day of week 7 is Sunday, like 0.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields (minute hour day month weekday)", expr)
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		spec := cronFields[i]
+		for _, part := range strings.Split(field, ",") {
+			rangePart, stepPart, hasStep := strings.Cut(part, "/")
+			step := 1
+			if hasStep {
+				n, err := strconv.Atoi(stepPart)
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("invalid step %q in cron %s field", stepPart, spec.name)
+				}
+				step = n
+			}
+
+			lo, hi := spec.min, spec.max
+			if rangePart != "*" {
+				from, to, isRange := strings.Cut(rangePart, "-")
+				var err error
+				if lo, err = strconv.Atoi(from); err != nil {
+					return nil, fmt.Errorf("invalid value %q in cron %s field", from, spec.name)
+				}
+				hi = lo
+				if isRange {
+					if hi, err = strconv.Atoi(to); err != nil {
+						return nil, fmt.Errorf("invalid value %q in cron %s field", to, spec.name)
+					}
+				} else if hasStep {
+					hi = spec.max
+				}
+			}
+			if lo < spec.min || hi > spec.max || lo > hi {
+				return nil, fmt.Errorf("cron %s field %q is outside %d-%d", spec.name, part, spec.min, spec.max)
+			}
+			for v := lo; v <= hi; v += step {
+				sets[i] |= 1 << uint(v)
+			}
+		}
+	}
+
+	// Sunday may be written as 0 or 7.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}, nil
+}
+
+// dayMatches follows cron's rule: when both day fields are restricted, a day
+// matching either one is enough.
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	domOK := c.dom&(1<<uint(t.Day())) != 0
+	dowOK := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
+
+// next returns the first matching minute strictly after t, or the zero time if
+// there is none within five years (e.g. "0 0 30 2 *").
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -2,11 +2,10 @@
 package core
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
-	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -14,11 +13,19 @@ import (
 type DriveType string
 
 const (
-	HDD  DriveType = "HDD"
-	SSD  DriveType = "SATA SSD"
-	NVME DriveType = "NVMe SSD"
-	USB  DriveType = "USB Drive"
-	UNKN DriveType = "Unknown"
+	HDD     DriveType = "HDD"
+	SSD     DriveType = "SATA SSD"
+	NVME    DriveType = "NVMe SSD"
+	USB     DriveType = "USB Drive"
+	SAS     DriveType = "SAS Drive"
+	VIRTIO  DriveType = "VirtIO Disk"
+	XEN     DriveType = "Xen Disk"
+	LOOP    DriveType = "Loop Device"
+	MMC     DriveType = "MMC/SD Card"
+	DM      DriveType = "Device Mapper"
+	OPTICAL DriveType = "Optical Drive"
+	ZONED   DriveType = "Zoned Drive"
+	UNKN    DriveType = "Unknown"
 )
 
 type Partition struct {
@@ -36,6 +43,32 @@ type Drive struct {
 	IsFrozen   bool        `json:"isFrozen"`
 	IsOSDrive  bool        `json:"isOSDrive"`
 	Partitions []Partition `json:"partitions"`
+	// Identity must be echoed back in WipeConfig to pin the wipe to this disk.
+	Identity DeviceIdentity `json:"identity"`
+	// SystemDiskReasons explains why IsOSDrive is set (root, /boot, swap, LVM...).
+	SystemDiskReasons []string `json:"systemDiskReasons,omitempty"`
+	// LUKS lists the LUKS volumes on the drive or its partitions.
+	LUKS []LUKSVolume `json:"luks,omitempty"`
+	// Classification explains how Type was chosen.
+	Classification []string `json:"classification"`
+	// Zoned is the zone model ("host-managed" or "host-aware") of zoned drives.
+	Zoned    string `json:"zoned,omitempty"`
+	ReadOnly bool   `json:"readOnly"`
+	// Optical describes the disc in optical drives.
+	Optical *OpticalMedia `json:"optical,omitempty"`
+
+	// Hardware details from lsblk, sysfs and (for ATA drives) hdparm.
+	Serial             string `json:"serial"`
+	WWN                string `json:"wwn,omitempty"`
+	Vendor             string `json:"vendor,omitempty"`
+	Firmware           string `json:"firmware,omitempty"`
+	LogicalSectorSize  int64  `json:"logicalSectorSize"`
+	PhysicalSectorSize int64  `json:"physicalSectorSize"`
+	Rotational         bool   `json:"rotational"`
+	// RotationRate is the nominal RPM, when the drive reports one.
+	RotationRate int    `json:"rotationRate,omitempty"`
+	Transport    string `json:"transport,omitempty"`
+	Removable    bool   `json:"removable"`
 }
 
 type MobileDevice struct {
@@ -43,6 +76,13 @@ type MobileDevice struct {
 	Model  string `json:"model"`
 	Serial string `json:"serial"`
 	Type   string `json:"type"` // e.g., "Android"
+	// State is "device" for a booted phone reachable through adb and
+	// "fastboot" for one in its bootloader.
+	State string `json:"state"`
+	// Attestation is collected from the device itself.
+	Attestation *AndroidAttestation `json:"attestation,omitempty"`
+	// Fastboot is set for phones in fastboot mode.
+	Fastboot *FastbootInfo `json:"fastboot,omitempty"`
 }
 
 // internal struct for parsing lsblk output
@@ -56,6 +96,14 @@ type lsblkDevice struct {
 	Children    []lsblkDevice `json:"children"`
 	FsType      string        `json:"fstype"`
 	Tran        string        `json:"tran"`
+	Serial      string        `json:"serial"`
+	WWN         string        `json:"wwn"`
+	Vendor      string        `json:"vendor"`
+	Rev         string        `json:"rev"`
+	LogSec      int64         `json:"log-sec"`
+	PhySec      int64         `json:"phy-sec"`
+	Removable   bool          `json:"rm"`
+	ReadOnly    bool          `json:"ro"`
 }
 
 type lsblkOutput struct {
@@ -82,8 +130,7 @@ func DetectDevices() (map[string]interface{}, error) {
 }
 
 func detectStorageDrives() ([]Drive, error) {
-	cmd := exec.Command("lsblk", "-J", "-b", "-o", "NAME,MODEL,SIZE,ROTA,TYPE,MOUNTPOINTS,FSTYPE,TRAN")
-	out, err := cmd.Output()
+	out, err := backend.Output("lsblk", "-J", "-b", "-o", "NAME,MODEL,SIZE,ROTA,TYPE,MOUNTPOINTS,FSTYPE,TRAN,SERIAL,WWN,VENDOR,REV,LOG-SEC,PHY-SEC,RM,RO")
 	if err != nil {
 		return nil, fmt.Errorf("lsblk command failed: %w", err)
 	}
@@ -93,11 +140,17 @@ func detectStorageDrives() ([]Drive, error) {
 		return nil, fmt.Errorf("failed to parse lsblk JSON: %w", err)
 	}
 
+	sysDisks, err := systemDisks()
+	if err != nil {
+		log.Printf("Warning: could not resolve system disks: %v", err)
+	}
+
 	var drives []Drive
 	for _, dev := range lsblkData.BlockDevices {
-		if dev.Type != "disk" && dev.Type != "rom" {
+		if !listedDevice(&dev) {
 			continue
 		}
+		dev.fillFromSysfs()
 
 		isMounted := len(dev.Mountpoints) > 0 && dev.Mountpoints[0] != ""
 		isOSDrive := false
@@ -133,91 +186,64 @@ func detectStorageDrives() ([]Drive, error) {
 			IsMounted:  isMounted,
 			IsOSDrive:  isOSDrive,
 			Partitions: partitions,
+			Identity:   dev.identity(),
+			LUKS:       detectLUKSVolumes(&dev),
+
+			Serial:             strings.TrimSpace(dev.Serial),
+			WWN:                strings.TrimSpace(dev.WWN),
+			Vendor:             strings.TrimSpace(dev.Vendor),
+			Firmware:           strings.TrimSpace(dev.Rev),
+			LogicalSectorSize:  dev.LogSec,
+			PhysicalSectorSize: dev.PhySec,
+			Rotational:         dev.Rotational,
+			Transport:          dev.Tran,
+			Removable:          dev.Removable,
 		}
-		drive.determineDriveType(&dev)
-
-		if drive.Type == SSD {
-			frozen, _ := isDriveFrozen(drive.Name)
-			drive.IsFrozen = frozen
+		if reasons := sysDisks.reasons(dev.Name); len(reasons) > 0 {
+			drive.IsOSDrive = true
+			drive.SystemDiskReasons = reasons
 		}
-		drives = append(drives, drive)
-	}
-	return drives, nil
-}
-
-func UnmountDevice(devicePath string) error {
-	log.Printf("Attempting to unmount device: %s", devicePath)
-
-	cmd := exec.Command("lsblk", "-J", "-o", "NAME,MOUNTPOINTS")
-	out, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("lsblk command failed: %w", err)
-	}
-
-	var lsblkData lsblkOutput
-	if err := json.Unmarshal(out, &lsblkData); err != nil {
-		return fmt.Errorf("failed to parse lsblk JSON: %w", err)
-	}
-
-	var targetDevice *lsblkDevice
-	for i, dev := range lsblkData.BlockDevices {
-		if "/dev/"+dev.Name == devicePath {
-			targetDevice = &lsblkData.BlockDevices[i]
-			break
+		drive.Type, drive.Classification = classifyDrive(&dev)
+		drive.ReadOnly = dev.ReadOnly
+		if drive.Type == ZONED {
+			drive.Zoned = readTrimmedFile(filepath.Join(sysfsRoot, "class", "block", dev.Name, "queue", "zoned"))
+		}
+		if drive.Type == OPTICAL {
+			drive.Optical = opticalMediaInfo(drive.Name)
 		}
-	}
-
-	if targetDevice == nil {
-		return fmt.Errorf("device %s not found in lsblk output", devicePath)
-	}
 
-	var unmountErrors []string
-	// Unmount partitions (children)
-	for _, child := range targetDevice.Children {
-		for _, mp := range child.Mountpoints {
-			if mp != "" {
-				log.Printf("Attempting to unmount partition %s from %s", child.Name, mp)
-				umountCmd := exec.Command("umount", mp)
-				output, err := umountCmd.CombinedOutput()
-				if err != nil {
-					errMsg := fmt.Sprintf("failed to unmount %s: %v. Output: %s", mp, err, string(output))
-					log.Println(errMsg)
-					unmountErrors = append(unmountErrors, errMsg)
-				} else {
-					log.Printf("Successfully unmounted %s", mp)
+		if dev.Tran == "sata" || dev.Tran == "ata" {
+			if ata, err := ataIdentify(drive.Name); err == nil {
+				drive.IsFrozen = drive.Type == SSD && ata.Frozen
+				drive.RotationRate = ata.RotationRate
+				if drive.Firmware == "" {
+					drive.Firmware = ata.Firmware
 				}
 			}
 		}
+		drives = append(drives, drive)
 	}
+	return drives, nil
+}
 
-	// Unmount the device itself
-	for _, mp := range targetDevice.Mountpoints {
-		if mp != "" {
-			log.Printf("Attempting to unmount device %s from %s", targetDevice.Name, mp)
-			umountCmd := exec.Command("umount", mp)
-			output, err := umountCmd.CombinedOutput()
-			if err != nil {
-				errMsg := fmt.Sprintf("failed to unmount %s: %v. Output: %s", mp, err, string(output))
-				log.Println(errMsg)
-				unmountErrors = append(unmountErrors, errMsg)
-			} else {
-				log.Printf("Successfully unmounted %s", mp)
-			}
-		}
+// detectAndroidDevices lists booted phones through adb and phones in their
+// bootloader through fastboot. It fails only if neither tool answers.
+func detectAndroidDevices() ([]MobileDevice, error) {
+	devices, err := detectAdbDevices()
+	fastbootDevices, fastbootErr := detectFastbootDevices()
+	if err != nil && fastbootErr != nil {
+		// This is not a fatal error; adb might just not be installed.
+		return []MobileDevice{}, err
 	}
+	return append(devices, fastbootDevices...), nil
+}
 
-	if len(unmountErrors) > 0 {
-		return fmt.Errorf(strings.Join(unmountErrors, "; "))
+func detectAdbDevices() ([]MobileDevice, error) {
+	if states, ok := trackedAdbStates(); ok {
+		return trackedAdbDevices(states), nil
 	}
-
-	log.Printf("Successfully processed unmount request for %s", devicePath)
-	return nil
-}
-func detectAndroidDevices() ([]MobileDevice, error) {
-	cmd := exec.Command("adb", "devices")
-	out, err := cmd.Output()
+	out, err := backend.Output("adb", "devices")
 	if err != nil {
-		// This is not a fatal error; adb might just not be installed.
 		return []MobileDevice{}, fmt.Errorf("adb command not found or failed: %w", err)
 	}
 
@@ -228,62 +254,74 @@ func detectAndroidDevices() ([]MobileDevice, error) {
 		fields := strings.Fields(line)
 		if len(fields) == 2 && fields[1] == "device" {
 			serial := fields[0]
-			modelCmd := exec.Command("adb", "-s", serial, "shell", "getprop", "ro.product.model")
-			modelOut, err := modelCmd.Output()
+			props, err := androidProps(serial)
 			if err != nil {
 				continue // Skip if we can't get the model
 			}
-			model := strings.TrimSpace(string(modelOut))
-
-			devices = append(devices, MobileDevice{
-				Name:   model,
-				Model:  model,
-				Serial: serial,
-				Type:   "Android",
-			})
+			devices = append(devices, newAdbDevice(serial, props))
 		}
 	}
 
 	return devices, nil
 }
 
-func (d *Drive) determineDriveType(dev *lsblkDevice) {
-	if strings.HasPrefix(dev.Name, "nbd") {
-		d.Type = HDD
-		return
-	}
-	if dev.Tran == "usb" {
-		d.Type = USB
-		return
+func newAdbDevice(serial string, props map[string]string) MobileDevice {
+	model := props["ro.product.model"]
+	return MobileDevice{
+		Name:        model,
+		Model:       model,
+		Serial:      serial,
+		Type:        "Android",
+		State:       MobileStateDevice,
+		Attestation: androidAttestation(serial, props),
 	}
-	if strings.HasPrefix(dev.Name, "nvme") {
-		d.Type = NVME
-		return
+}
+
+// attestAndroid reads a booted phone's attestation from the phone itself,
+// replacing whatever the device listing carried, and refreshes the listing.
+func attestAndroid(device *MobileDevice) error {
+	if device.State != MobileStateDevice {
+		return nil
 	}
-	if dev.Rotational {
-		d.Type = HDD
-	} else {
-		d.Type = SSD
+	props, err := androidProps(device.Serial)
+	if err != nil {
+		return err
 	}
+	device.Attestation = androidAttestation(device.Serial, props)
+	refreshTrackedAdbDevice(*device)
+	return nil
 }
 
-func isDriveFrozen(devicePath string) (bool, error) {
-	cmd := exec.Command("hdparm", "-I", devicePath)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+// ataInfo is what DZap reads from an ATA drive's IDENTIFY data.
+type ataInfo struct {
+	Frozen       bool
+	RotationRate int // RPM; 0 for solid state or unreported
+	Firmware     string
+}
+
+func ataIdentify(devicePath string) (*ataInfo, error) {
+	out, err := backend.Output("hdparm", "-I", devicePath)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	return parseHdparmIdentify(string(out)), nil
+}
 
-	output := out.String()
+func parseHdparmIdentify(output string) *ataInfo {
+	info := &ataInfo{}
 	for _, line := range strings.Split(output, "\n") {
 		trimmedLine := strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(trimmedLine, "Nominal Media Rotation Rate:"); ok {
+			info.RotationRate, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+		if value, ok := strings.CutPrefix(trimmedLine, "Firmware Revision:"); ok {
+			info.Firmware = strings.TrimSpace(value)
+		}
 		if strings.HasPrefix(trimmedLine, "Security:") {
 			if strings.Contains(trimmedLine, "frozen") {
-				return true, nil
+				info.Frozen = true
 			}
 		}
 	}
-	return false, nil
+	return info
 }
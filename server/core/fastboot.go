@@ -0,0 +1,252 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Mobile device states.
+const (
+	MobileStateDevice   = "device"   // booted, reachable through adb
+	MobileStateFastboot = "fastboot" // in the bootloader (or fastbootd), reachable through fastboot
+)
+
+// FastbootInfo is what a phone's bootloader reports through "getvar all".
+type FastbootInfo struct {
+	Product    string `json:"product"`
+	Bootloader string `json:"bootloader,omitempty"` // version-bootloader
+	// Unlocked is required for erasing partitions; locked bootloaders refuse.
+	Unlocked bool `json:"unlocked"`
+	// Userspace is set in fastbootd, Android's userspace fastboot.
+	Userspace bool `json:"userspace"`
+	// Partitions lists the data partitions a wipe erases that the device has:
+	// userdata, and metadata (metadata encryption keys, Android 9 and later)
+	// and cache where present.
+	Partitions []string `json:"partitions"`
+}
+
+// fastbootDataPartitions are erased, in order, by fastboot_erase_userdata.
+var fastbootDataPartitions = []string{"userdata", "metadata", "cache"}
+
+// FastbootErase is the evidence of a fastboot wipe: every step with the
+// bootloader's answer, and the bootloader variables read again afterwards.
+type FastbootErase struct {
+	Steps []FastbootStep `json:"steps"`
+	// Erased lists the partitions the bootloader reported erasing with OKAY.
+	Erased []string      `json:"erased"`
+	After  *FastbootInfo `json:"after,omitempty"`
+}
+
+// FastbootStep is one fastboot command and what it printed.
+type FastbootStep struct {
+	Args   []string `json:"args"`
+	Output string   `json:"output"`
+}
+
+// fastbootErased returns the partitions out reports as erased, from lines
+// such as "Erasing 'userdata'  OKAY [  0.052s]".
+func fastbootErased(out string) []string {
+	var erased []string
+	for _, line := range strings.Split(out, "\n") {
+		m := fastbootEraseLine.FindStringSubmatch(line)
+		if m != nil {
+			erased = append(erased, m[1])
+		}
+	}
+	return erased
+}
+
+var fastbootEraseLine = regexp.MustCompile(`Erasing '([^']+)'\s+OKAY`)
+
+func isFastbootMethod(method string) bool {
+	return strings.HasPrefix(method, "fastboot_")
+}
+
+// detectFastbootDevices lists phones in fastboot mode.
+func detectFastbootDevices() ([]MobileDevice, error) {
+	out, err := backend.Output("fastboot", "devices")
+	if err != nil {
+		return []MobileDevice{}, fmt.Errorf("fastboot command not found or failed: %w", err)
+	}
+
+	var devices []MobileDevice
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != "fastboot" {
+			continue
+		}
+		info, err := fastbootInfo(fields[0])
+		if err != nil {
+			continue
+		}
+		devices = append(devices, MobileDevice{
+			Name:     info.Product + " (fastboot)",
+			Model:    info.Product,
+			Serial:   fields[0],
+			Type:     "Android",
+			State:    MobileStateFastboot,
+			Fastboot: info,
+		})
+	}
+	return devices, nil
+}
+
+// fastbootInfo reads the bootloader variables of a phone. fastboot prints
+// them on stderr, prefixed with "(bootloader) ".
+func fastbootInfo(serial string) (*FastbootInfo, error) {
+	out, err := backend.CombinedOutput(context.Background(), "fastboot", "-s", serial, "getvar", "all")
+	if err != nil {
+		return nil, fmt.Errorf("fastboot getvar failed: %w. Output: %s", err, string(out))
+	}
+	vars := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "(bootloader)"))
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			vars[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		// Partition variables carry the partition in the key:
+		// "partition-type:userdata:f2fs".
+		if rest, ok := strings.CutPrefix(line, "partition-type:"); ok {
+			if name, _, ok := strings.Cut(rest, ":"); ok {
+				vars["partition-type:"+name] = "yes"
+			}
+		}
+	}
+
+	info := &FastbootInfo{
+		Product:    vars["product"],
+		Bootloader: vars["version-bootloader"],
+		Unlocked:   vars["unlocked"] == "yes",
+		Userspace:  vars["is-userspace"] == "yes",
+	}
+	for _, p := range fastbootDataPartitions {
+		if vars["partition-type:"+p] != "" {
+			info.Partitions = append(info.Partitions, p)
+		}
+	}
+	if info.Product == "" {
+		return nil, fmt.Errorf("%s did not report its product", serial)
+	}
+	return info, nil
+}
+
+// checkFastbootUnlocked refuses to erase through a locked bootloader. Locked
+// bootloaders reject erase commands; unlocking ("fastboot flashing unlock")
+// has to be confirmed on the phone and wipes userdata itself.
+func checkFastbootUnlocked(info *FastbootInfo) error {
+	if info == nil {
+		return fmt.Errorf("the bootloader state is unknown")
+	}
+	if !info.Unlocked {
+		return fmt.Errorf("the bootloader is locked; unlock it on the phone (fastboot flashing unlock) first")
+	}
+	if len(info.Partitions) == 0 || info.Partitions[0] != "userdata" {
+		return fmt.Errorf("the bootloader does not report a userdata partition")
+	}
+	return nil
+}
+
+// sanitizeFastboot erases a phone's data partitions from its bootloader.
+// fastboot_erase_userdata erases userdata and, where present, metadata and
+// cache; Android formats them on the next boot. fastboot_wipe runs
+// "fastboot -w", which erases and formats them right away. Every data
+// partition must be reported erased with OKAY, and the bootloader variables
+// are read again afterwards to check that the same unlocked phone answered.
+func sanitizeFastboot(config WipeConfig, progress chan<- string) (*FastbootErase, error) {
+	serial := config.DeviceSerial
+	info, err := fastbootInfo(serial)
+	if err != nil {
+		return nil, fmt.Errorf("device %s is not in fastboot mode: %w", serial, err)
+	}
+	if err := checkFastbootUnlocked(info); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controls := &WipeControls{
+		cancel: cancel,
+		pause:  make(chan bool), // Erasing cannot be paused
+	}
+	wipeMutex.Lock()
+	activeWipes[config.DevicePath] = controls
+	wipeMutex.Unlock()
+
+	defer func() {
+		wipeMutex.Lock()
+		delete(activeWipes, config.DevicePath)
+		wipeMutex.Unlock()
+	}()
+
+	var steps [][]string
+	switch config.Method {
+	case "fastboot_wipe":
+		steps = append(steps, []string{"-w"})
+	case "fastboot_erase_userdata":
+		for _, p := range info.Partitions {
+			steps = append(steps, []string{"erase", p})
+		}
+	default:
+		return nil, fmt.Errorf("unknown fastboot method %s", config.Method)
+	}
+
+	erase := &FastbootErase{Steps: []FastbootStep{}, Erased: []string{}}
+	for i, step := range steps {
+		progress <- fmt.Sprintf("Running fastboot %s on %s...", strings.Join(step, " "), serial)
+		args := append([]string{"-s", serial}, step...)
+		out, err := backend.CombinedOutput(ctx, "fastboot", args...)
+		erase.Steps = append(erase.Steps, FastbootStep{Args: step, Output: strings.TrimSpace(string(out))})
+		if err == nil && strings.Contains(string(out), "FAILED") {
+			err = fmt.Errorf("the bootloader reported a failure")
+		}
+		if err != nil {
+			return erase, fmt.Errorf("fastboot %s failed: %w. Output: %s", strings.Join(step, " "), err, strings.TrimSpace(string(out)))
+		}
+		erase.Erased = append(erase.Erased, fastbootErased(string(out))...)
+		update := WipeProgress{
+			DeviceID:    config.DevicePath,
+			DeviceModel: config.DeviceModel,
+			Method:      config.Method,
+			MethodName:  getWipeMethodName(config.Method),
+			Status:      "Erasing",
+			Progress:    float64(i+1) * 100 / float64(len(steps)),
+			CurrentPass: i + 1,
+			TotalPasses: len(steps),
+		}
+		msg, _ := json.Marshal(update)
+		progress <- string(msg)
+	}
+
+	for _, p := range info.Partitions {
+		found := false
+		for _, e := range erase.Erased {
+			found = found || e == p
+		}
+		if !found {
+			return erase, fmt.Errorf("the bootloader did not report erasing %s", p)
+		}
+	}
+
+	after, err := fastbootInfo(serial)
+	if err != nil {
+		return erase, fmt.Errorf("could not read the bootloader variables after the erase: %w", err)
+	}
+	erase.After = after
+	if after.Product != info.Product || !after.Unlocked {
+		return erase, fmt.Errorf("after the erase %s reports product %q (unlocked: %v), expected %q", serial, after.Product, after.Unlocked, info.Product)
+	}
+	progress <- fmt.Sprintf("Verified: the bootloader of %s reported %s erased.", serial, strings.Join(erase.Erased, ", "))
+
+	completion := WipeProgress{
+		DeviceID: config.DevicePath,
+		Status:   "done",
+		Progress: 100,
+	}
+	jsonMsg, _ := json.Marshal(completion)
+	progress <- string(jsonMsg)
+	return erase, nil
+}
@@ -0,0 +1,279 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// FileTarget is a regular file or disk image that can be wiped like a drive,
+// e.g. a raw VM volume or a qcow2 image being returned to the storage pool.
+type FileTarget struct {
+	Path   string `json:"path"`
+	Format string `json:"format"` // "raw" or "qcow2"
+	Size   int64  `json:"size"`
+	// VirtualSize and AllocatedBytes describe the guest disk inside a qcow2 image.
+	VirtualSize    int64 `json:"virtualSize,omitempty"`
+	AllocatedBytes int64 `json:"allocatedBytes,omitempty"`
+	// BackingFile is the qcow2 image's backing file, which the cluster wipe
+	// refuses.
+	BackingFile string         `json:"backingFile,omitempty"`
+	Identity    DeviceIdentity `json:"identity"`
+	Methods     []WipeMethod   `json:"methods"`
+}
+
+// imageDirs are the directories whose files may be wiped as file targets.
+var imageDirs []string
+
+// SetImageDirs sets the directories whose files and disk images may be wiped.
+// With none, file targets are refused.
+func SetImageDirs(dirs []string) {
+	imageDirs = nil
+	for _, dir := range dirs {
+		if dir != "" {
+			imageDirs = append(imageDirs, dir)
+		}
+	}
+}
+
+// isFileTarget reports whether path names a regular file rather than a device.
+func isFileTarget(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func fileIdentity(path string) (DeviceIdentity, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return DeviceIdentity{}, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return DeviceIdentity{}, fmt.Errorf("unsupported stat for %s", path)
+	}
+	return DeviceIdentity{
+		Size:   info.Size(),
+		FileID: fmt.Sprintf("%d:%d", st.Dev, st.Ino),
+	}, nil
+}
+
+// InspectFileTarget describes a file target and the methods that apply to it.
+func InspectFileTarget(path string) (*FileTarget, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("file target %q must be an absolute path", path)
+	}
+	if !isFileTarget(path) {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	if err := checkImageDir(path); err != nil {
+		return nil, err
+	}
+
+	identity, err := fileIdentity(path)
+	if err != nil {
+		return nil, err
+	}
+	target := &FileTarget{
+		Path:     path,
+		Format:   "raw",
+		Size:     identity.Size,
+		Identity: identity,
+	}
+
+	if img, err := openQcow2(path); err == nil {
+		defer img.file.Close()
+		clusters, err := img.dataClusters()
+		if err != nil {
+			return nil, err
+		}
+		target.Format = "qcow2"
+		target.VirtualSize = int64(img.virtualSize)
+		target.BackingFile = img.backingFile
+		for _, c := range clusters {
+			target.AllocatedBytes += c.length
+		}
+	} else if err != errNotQcow2 {
+		return nil, err
+	}
+
+	target.Methods = GetWipeMethodsForFile(target.Format)
+	return target, nil
+}
+
+// GetWipeMethodsForFile returns the methods for raw files and disk images.
+func GetWipeMethodsForFile(format string) []WipeMethod {
+	overwrite := []WipeMethod{
+		{ID: "overwrite_1_pass", Name: "Clear: 1-Pass Overwrite", Description: "Overwrites every byte of the file in place. For qcow2 this destroys the image itself."},
+		{ID: "overwrite_3_pass", Name: "Purge: 3-Pass Overwrite", Description: "Three passes over every byte of the file in place."},
+	}
+	if format == "qcow2" {
+		return append([]WipeMethod{
+			{ID: "qcow2_cluster_wipe", Name: "Clear: qcow2 Cluster Wipe", Description: "Overwrites every allocated guest data cluster, then discards them, leaving a valid empty image."},
+		}, overwrite...)
+	}
+	return overwrite
+}
+
+// checkImageDir refuses files outside the image directories, so a wipe can
+// never reach system files, binaries or databases.
+func checkImageDir(path string) error {
+	if len(imageDirs) == 0 {
+		return fmt.Errorf("wiping files is disabled; start the backend with -image-dirs to allow it")
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("could not resolve %s: %w", path, err)
+	}
+	for _, dir := range imageDirs {
+		resolvedDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(resolvedDir, resolved)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not in an image directory (%s)", path, strings.Join(imageDirs, ", "))
+}
+
+// checkFileDisk refuses files on a system disk, unless the request overrides
+// the system disk protection.
+func checkFileDisk(config WipeConfig) error {
+	if config.AllowSystemDisk {
+		return nil
+	}
+	disks, err := fileBackingDisks(config.DevicePath)
+	if err != nil {
+		return fmt.Errorf("could not determine the disk holding %s: %w", config.DevicePath, err)
+	}
+	for _, disk := range disks {
+		if err := CheckSystemDisk("/dev/" + disk); err != nil {
+			return fmt.Errorf("%s: %w", config.DevicePath, err)
+		}
+	}
+	return nil
+}
+
+// fileBackingDisks returns the disks under the filesystem holding path, or
+// none for filesystems without a block device, such as tmpfs.
+func fileBackingDisks(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("unsupported stat for %s", path)
+	}
+	name, err := blockNameForDevNumber(fmt.Sprintf("%d:%d", unixMajor(st.Dev), unixMinor(st.Dev)))
+	if err == nil {
+		return resolveBackingDisks(name), nil
+	}
+	// btrfs, ZFS, overlayfs and others report an anonymous device number, so
+	// go by the mount holding the file instead.
+	names, err := mountBlockNames(path)
+	if err != nil {
+		return nil, err
+	}
+	var disks []string
+	for _, name := range names {
+		disks = append(disks, resolveBackingDisks(name)...)
+	}
+	return disks, nil
+}
+
+// mountBlockNames returns the block devices of the innermost mount holding path.
+func mountBlockNames(path string) ([]string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := readMountinfo()
+	if err != nil {
+		return nil, err
+	}
+	var holder *mountEntry
+	for i, m := range mounts {
+		if m.mountpoint != "/" && resolved != m.mountpoint && !strings.HasPrefix(resolved, m.mountpoint+"/") {
+			continue
+		}
+		if holder == nil || len(m.mountpoint) >= len(holder.mountpoint) {
+			holder = &mounts[i]
+		}
+	}
+	if holder == nil {
+		return nil, fmt.Errorf("no mount holds %s", path)
+	}
+	return holder.blockNames()
+}
+
+// checkFileTarget refuses files the running system depends on: active swap
+// files and files attached to loop devices, which may be mounted.
+func checkFileTarget(path string) error {
+	swaps, err := activeSwapDevices()
+	if err != nil {
+		return err
+	}
+	for _, swap := range swaps {
+		if sameFile(swap, path) {
+			return fmt.Errorf("%s is an active swap file", path)
+		}
+	}
+
+	loops, _ := filepath.Glob(filepath.Join(sysfsRoot, "block", "loop*", "loop", "backing_file"))
+	for _, backing := range loops {
+		data, err := os.ReadFile(backing)
+		if err != nil {
+			continue
+		}
+		if sameFile(strings.TrimSpace(string(data)), path) {
+			loop := filepath.Base(filepath.Dir(filepath.Dir(backing)))
+			return fmt.Errorf("%s is attached to /dev/%s; detach it with losetup -d first", path, loop)
+		}
+	}
+	return nil
+}
+
+func sameFile(a, b string) bool {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ia, ib)
+}
+
+func sanitizeFileTarget(config WipeConfig, progress chan<- string) error {
+	if config.Identity.IsZero() {
+		return fmt.Errorf("wipe request for %s does not pin a file identity", config.DevicePath)
+	}
+	if err := checkImageDir(config.DevicePath); err != nil {
+		return err
+	}
+	if err := verifyDeviceIdentity(config.DevicePath, config.Identity); err != nil {
+		return err
+	}
+	if err := checkFileDisk(config); err != nil {
+		return err
+	}
+	if err := checkFileTarget(config.DevicePath); err != nil {
+		return err
+	}
+
+	switch config.Method {
+	case "qcow2_cluster_wipe":
+		return sanitizeQcow2(config, progress)
+	case "overwrite_1_pass":
+		return sanitizeOverwrite(config, 1, progress)
+	case "overwrite_3_pass":
+		return sanitizeOverwrite(config, 3, progress)
+	default:
+		return fmt.Errorf("method %s is not supported for file targets", config.Method)
+	}
+}
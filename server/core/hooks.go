@@ -0,0 +1,194 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Hook events. Each names an executable in the hooks directory.
+const (
+	HookPreWipe    = "pre-wipe"
+	HookPostWipe   = "post-wipe"
+	HookWipeFailed = "wipe-failed"
+)
+
+// hookTimeout bounds each hook so a hung script can't stall the station.
+const hookTimeout = 2 * time.Minute
+
+// maxHookOutput is how much hook output is kept in the job record.
+const maxHookOutput = 16 * 1024
+
+// hooksDir overrides the default ~/.config/DZap/hooks.
+var hooksDir string
+
+// SetHooksDir sets the directory searched for hook executables.
+func SetHooksDir(dir string) {
+	hooksDir = dir
+}
+
+// HookRun records one hook invocation in the job history.
+type HookRun struct {
+	Event     string    `json:"event"`
+	Path      string    `json:"path"`
+	ExitCode  int       `json:"exitCode"`
+	Output    string    `json:"output"`
+	StartedAt time.Time `json:"startedAt"`
+	Duration  float64   `json:"durationSeconds"`
+}
+
+// hookPayload is the job as hooks see it, as JSON on stdin.
+type hookPayload struct {
+	Event           string         `json:"event"`
+	JobID           string         `json:"jobId"`
+	Device          string         `json:"device"`
+	Method          string         `json:"method"`
+	MethodName      string         `json:"methodName"`
+	Model           string         `json:"model,omitempty"`
+	Identity        DeviceIdentity `json:"identity"`
+	Details         *DeviceDetails `json:"details,omitempty"`
+	Status          string         `json:"status"`
+	Error           string         `json:"error,omitempty"`
+	StartedAt       time.Time      `json:"startedAt"`
+	FinishedAt      *time.Time     `json:"finishedAt,omitempty"`
+	CertificatePath string         `json:"certificatePath,omitempty"`
+}
+
+// hookPath returns the executable installed for event, or "" if there is
+// none. Hooks run as the backend's user (root), so a hook and the directory
+// holding it must be owned by root and not writable by group or others;
+// anything else is refused.
+func hookPath(event string) (string, error) {
+	dir := hooksDir
+	if dir == "" {
+		var err error
+		if dir, err = configPath("hooks"); err != nil {
+			return "", nil
+		}
+	}
+	path := filepath.Join(dir, event)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || info.Mode().Perm()&0111 == 0 {
+		return "", nil
+	}
+
+	// Check the files the hook resolves to, so a symlink cannot point the
+	// check somewhere other than what runs.
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%s hook %s could not be resolved: %w", event, path, err)
+	}
+	checks := []string{resolved, filepath.Dir(resolved)}
+	if realDir, err := filepath.EvalSymlinks(dir); err == nil && realDir != filepath.Dir(resolved) {
+		checks = append(checks, realDir)
+	}
+	for _, p := range checks {
+		if err := checkHookOwnership(p); err != nil {
+			return "", fmt.Errorf("%s hook %s refused: %w", event, path, err)
+		}
+	}
+	return path, nil
+}
+
+// checkHookOwnership requires path to be owned by root and not writable by
+// group or others.
+func checkHookOwnership(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("could not read the owner of %s", path)
+	}
+	if st.Uid != 0 {
+		return fmt.Errorf("%s is owned by uid %d, not root", path, st.Uid)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by group or others (mode %04o)", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+// runHook runs the executable for event, if one is installed, and records the
+// run on the job. Hooks integrate with the host (asset systems, label
+// printers), so they always run on the host, even with the simulated backend.
+// The job is passed as JSON on stdin and as DZAP_* environment variables.
+// An installed hook that fails the ownership checks is not run and counts as
+// a failure, so a refused pre-wipe hook still vetoes the wipe.
+func (j *WipeJob) runHook(event string) (*HookRun, error) {
+	path, err := hookPath(event)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		j.log.record("hook", map[string]string{"event": event, "error": err.Error()})
+		return nil, err
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	jobsMutex.Lock()
+	payload := hookPayload{
+		Event:           event,
+		JobID:           j.ID,
+		Device:          j.Config.DevicePath,
+		Method:          j.Config.Method,
+		MethodName:      getWipeMethodName(j.Config.Method),
+		Model:           j.Config.DeviceModel,
+		Identity:        j.Config.Identity,
+		Details:         j.Device,
+		Status:          j.Status,
+		Error:           j.Error,
+		StartedAt:       j.StartedAt,
+		FinishedAt:      j.FinishedAt,
+		CertificatePath: j.CertificatePath,
+	}
+	jobsMutex.Unlock()
+	input, _ := json.Marshal(payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"DZAP_EVENT="+payload.Event,
+		"DZAP_JOB_ID="+payload.JobID,
+		"DZAP_DEVICE="+payload.Device,
+		"DZAP_METHOD="+payload.Method,
+		"DZAP_SERIAL="+payload.Identity.Serial,
+		"DZAP_STATUS="+payload.Status,
+		"DZAP_ERROR="+payload.Error,
+		"DZAP_CERTIFICATE="+payload.CertificatePath,
+	)
+
+	run := &HookRun{Event: event, Path: path, StartedAt: time.Now().UTC()}
+	output, err := cmd.CombinedOutput()
+	run.Duration = time.Since(run.StartedAt).Seconds()
+	if len(output) > maxHookOutput {
+		output = append(output[:maxHookOutput], "\n[output truncated]"...)
+	}
+	run.Output = string(output)
+	run.ExitCode = -1
+	if cmd.ProcessState != nil {
+		run.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", hookTimeout)
+	}
+
+	j.update(func(j *WipeJob) { j.Hooks = append(j.Hooks, *run) })
+	j.log.record("hook", run)
+	if err != nil {
+		log.Printf("Hook %s for job %s failed: %v", path, j.ID, err)
+		return run, fmt.Errorf("%s hook failed: %w", event, err)
+	}
+	return run, nil
+}
@@ -0,0 +1,303 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DeviceIdentity pins a wipe to one physical disk. Device names like /dev/sdb
+// can be reassigned by a hot-plug between the UI click and the first write, so
+// the client sends back the identity it saw and we re-check it before each pass.
+type DeviceIdentity struct {
+	Serial string `json:"serial"`
+	WWN    string `json:"wwn"`
+	Size   int64  `json:"size"`
+	// FileID is "device:inode" for file-backed targets, which have no serial.
+	FileID string `json:"fileId,omitempty"`
+}
+
+// IsZero reports whether no identity was supplied.
+func (id DeviceIdentity) IsZero() bool {
+	return id.Serial == "" && id.WWN == "" && id.Size == 0 && id.FileID == ""
+}
+
+func (id DeviceIdentity) String() string {
+	if id.FileID != "" {
+		return fmt.Sprintf("file=%s size=%d", id.FileID, id.Size)
+	}
+	return fmt.Sprintf("serial=%q wwn=%q size=%d", id.Serial, id.WWN, id.Size)
+}
+
+// readDeviceIdentity asks lsblk for the identity of the disk currently behind devicePath.
+func readDeviceIdentity(devicePath string) (DeviceIdentity, error) {
+	out, err := backend.Output("lsblk", "-J", "-b", "-d", "-o", "NAME,SERIAL,WWN,SIZE", devicePath)
+	if err != nil {
+		return DeviceIdentity{}, fmt.Errorf("lsblk command failed: %w", err)
+	}
+
+	var lsblkData lsblkOutput
+	if err := json.Unmarshal(out, &lsblkData); err != nil {
+		return DeviceIdentity{}, fmt.Errorf("failed to parse lsblk JSON: %w", err)
+	}
+	if len(lsblkData.BlockDevices) == 0 {
+		return DeviceIdentity{}, fmt.Errorf("device %s not found in lsblk output", devicePath)
+	}
+
+	dev := lsblkData.BlockDevices[0]
+	dev.fillFromSysfs()
+	return dev.identity(), nil
+}
+
+// fillFromSysfs completes what lsblk left empty, typically because the udev
+// database is unavailable (containers, early boot), from the device's sysfs
+// attributes.
+func (dev *lsblkDevice) fillFromSysfs() {
+	dir := filepath.Join(sysfsRoot, "class", "block", dev.Name)
+	first := func(names ...string) string {
+		for _, name := range names {
+			if v := readTrimmedFile(filepath.Join(dir, name)); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	if strings.TrimSpace(dev.Serial) == "" {
+		dev.Serial = first("device/serial")
+		if dev.Serial == "" {
+			dev.Serial = vpdSerial(filepath.Join(dir, "device", "vpd_pg80"))
+		}
+	}
+	if strings.TrimSpace(dev.WWN) == "" {
+		dev.WWN = first("wwid", "device/wwid")
+	}
+	if strings.TrimSpace(dev.Vendor) == "" {
+		dev.Vendor = first("device/vendor")
+	}
+	if strings.TrimSpace(dev.Rev) == "" {
+		dev.Rev = first("device/firmware_rev", "device/rev")
+	}
+	if dev.LogSec == 0 {
+		dev.LogSec, _ = strconv.ParseInt(first("queue/logical_block_size"), 10, 64)
+	}
+	if dev.PhySec == 0 {
+		dev.PhySec, _ = strconv.ParseInt(first("queue/physical_block_size"), 10, 64)
+	}
+}
+
+// vpdSerial extracts the unit serial number from a SCSI VPD page 0x80 dump.
+func vpdSerial(path string) string {
+	page, err := os.ReadFile(path)
+	if err != nil || len(page) < 4 || page[1] != 0x80 {
+		return ""
+	}
+	n := int(page[3])
+	if 4+n > len(page) {
+		n = len(page) - 4
+	}
+	return strings.TrimSpace(string(page[4 : 4+n]))
+}
+
+// DeviceDetails is the backend's own record of what a wiped device is, taken
+// from detection rather than from the client's request. Jobs and certificates
+// carry it so they never depend on what the UI claims.
+type DeviceDetails struct {
+	Model              string `json:"model"`
+	Serial             string `json:"serial"`
+	WWN                string `json:"wwn,omitempty"`
+	Vendor             string `json:"vendor,omitempty"`
+	Firmware           string `json:"firmware,omitempty"`
+	Size               int64  `json:"size"`
+	LogicalSectorSize  int64  `json:"logicalSectorSize,omitempty"`
+	PhysicalSectorSize int64  `json:"physicalSectorSize,omitempty"`
+	RotationRate       int    `json:"rotationRate,omitempty"`
+	Transport          string `json:"transport,omitempty"`
+	Removable          bool   `json:"removable"`
+	Type               string `json:"type"`
+	// Android holds what a phone attested about itself before the reset.
+	Android *AndroidAttestation `json:"android,omitempty"`
+}
+
+func (d *DeviceDetails) android() *AndroidAttestation {
+	if d == nil {
+		return nil
+	}
+	return d.Android
+}
+
+func (d *Drive) details() *DeviceDetails {
+	size, _ := strconv.ParseInt(d.Size, 10, 64)
+	return &DeviceDetails{
+		Model:              d.Model,
+		Serial:             d.Serial,
+		WWN:                d.WWN,
+		Vendor:             d.Vendor,
+		Firmware:           d.Firmware,
+		Size:               size,
+		LogicalSectorSize:  d.LogicalSectorSize,
+		PhysicalSectorSize: d.PhysicalSectorSize,
+		RotationRate:       d.RotationRate,
+		Transport:          d.Transport,
+		Removable:          d.Removable,
+		Type:               string(d.Type),
+	}
+}
+
+// detectDeviceDetails looks up the device a wipe targets. It returns nil when
+// the device is gone or, for drives, no longer matches the pinned identity;
+// the wipe itself then fails with a precise error.
+func detectDeviceDetails(config WipeConfig) *DeviceDetails {
+	if config.DeviceType == "Android" {
+		devices, _ := detectAndroidDevices()
+		for _, d := range devices {
+			if d.Serial == config.DeviceSerial {
+				if err := attestAndroid(&d); err != nil {
+					log.Printf("Warning: could not read the attestation of %s: %v", d.Serial, err)
+				}
+				details := &DeviceDetails{Model: d.Model, Serial: d.Serial, Transport: "usb", Type: d.Type, Android: d.Attestation}
+				if d.Attestation != nil {
+					details.Vendor = d.Attestation.Manufacturer
+					details.Firmware = d.Attestation.AndroidVersion
+				}
+				if d.Fastboot != nil {
+					details.Firmware = d.Fastboot.Bootloader
+				}
+				return details
+			}
+		}
+		return nil
+	}
+	if isFileTarget(config.DevicePath) {
+		return nil
+	}
+
+	drives, err := detectStorageDrives()
+	if err != nil {
+		return nil
+	}
+	for i := range drives {
+		if drives[i].Name == config.DevicePath && drives[i].Identity == config.Identity {
+			return drives[i].details()
+		}
+	}
+	return nil
+}
+
+func (dev *lsblkDevice) identity() DeviceIdentity {
+	return DeviceIdentity{
+		Serial: strings.TrimSpace(dev.Serial),
+		WWN:    strings.TrimSpace(dev.WWN),
+		Size:   dev.Size,
+	}
+}
+
+// verifyDeviceIdentity fails if devicePath no longer refers to the pinned disk.
+func verifyDeviceIdentity(devicePath string, expected DeviceIdentity) error {
+	if expected.IsZero() {
+		return fmt.Errorf("no device identity pinned for %s", devicePath)
+	}
+
+	var current DeviceIdentity
+	var err error
+	if isFileTarget(devicePath) {
+		current, err = fileIdentity(devicePath)
+	} else {
+		current, err = readDeviceIdentity(devicePath)
+	}
+	if err != nil {
+		return fmt.Errorf("could not re-read identity of %s: %w", devicePath, err)
+	}
+
+	if current != expected {
+		return fmt.Errorf("device identity mismatch on %s: expected %s, found %s", devicePath, expected, current)
+	}
+	return nil
+}
+
+// openDeviceExclusive opens a block device with O_EXCL. On Linux this fails with
+// EBUSY while the device is mounted or claimed, and stops anyone else from
+// mounting or claiming it for as long as the file stays open.
+func openDeviceExclusive(devicePath string, flag int) (*os.File, error) {
+	file, err := os.OpenFile(backend.DevicePath(devicePath), flag|os.O_EXCL, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s exclusively: %w", devicePath, err)
+	}
+	if isFileTarget(devicePath) {
+		// O_EXCL means nothing for regular files, so take an advisory lock instead.
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s is locked by another process: %w", devicePath, err)
+		}
+	}
+	return file, nil
+}
+
+// deviceExtent returns the byte range of an opened device's file that is
+// devicePath: the whole file on real hardware, a range of the disk's image for
+// simulated partitions.
+func deviceExtent(file *os.File, devicePath string) (extent, error) {
+	if offset, length := backend.DeviceRange(devicePath); length > 0 {
+		return extent{offset: offset, length: length}, nil
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return extent{}, fmt.Errorf("could not determine size of %s: %w", devicePath, err)
+	}
+	return extent{offset: 0, length: size}, nil
+}
+
+// openPinnedDevice claims config.DevicePath exclusively and then confirms that
+// the claimed device is still the pinned disk. The check runs after the open so
+// the identity we verify is the one behind the file descriptor we write to.
+func openPinnedDevice(config WipeConfig, flag int) (*os.File, error) {
+	file, err := openDeviceExclusive(config.DevicePath, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyDeviceIdentity(config.DevicePath, config.Identity); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := sameDeviceNode(file, config.DevicePath); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// sameDeviceNode guards against the node being swapped between open and verify.
+func sameDeviceNode(file *os.File, devicePath string) error {
+	opened, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat opened device: %w", err)
+	}
+	current, err := os.Stat(backend.DevicePath(devicePath))
+	if err != nil {
+		return fmt.Errorf("could not stat %s: %w", devicePath, err)
+	}
+
+	if opened.Mode().IsRegular() {
+		if !os.SameFile(opened, current) {
+			return fmt.Errorf("%s was replaced by another file while it was being opened", devicePath)
+		}
+		return nil
+	}
+
+	openedSys, ok1 := opened.Sys().(*syscall.Stat_t)
+	currentSys, ok2 := current.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	if openedSys.Rdev != currentSys.Rdev {
+		return fmt.Errorf("%s was replaced by another device while it was being opened", devicePath)
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLoggedOutput caps the tool output kept in a job log entry. The hash and
+// length of the full output are always recorded.
+const maxLoggedOutput = 64 * 1024
+
+// JobLogEntry is one record of a job's tamper-evident log. Hash covers the
+// entry and the previous entry's hash, so changing, dropping or reordering an
+// entry breaks the chain from there on. The first entry chains from a hash of
+// the job ID, so a log cannot be passed off as another job's.
+type JobLogEntry struct {
+	Seq  int             `json:"seq"`
+	Time time.Time       `json:"time"`
+	Type string          `json:"type"` // config, device, tool, step, pass, verification, hook, error, result
+	Data json.RawMessage `json:"data"`
+	Prev string          `json:"prev"`
+	Hash string          `json:"hash"`
+}
+
+func (e *JobLogEntry) computeHash() string {
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s\n%d\n%s\n%s\n", e.Prev, e.Seq, e.Time.Format(time.RFC3339Nano), e.Type)
+	sum.Write(e.Data)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func jobLogGenesis(jobID string) string {
+	sum := sha256.Sum256([]byte("DZap job " + jobID))
+	return hex.EncodeToString(sum[:])
+}
+
+// ToolInvocation is a job log entry for one external program run on the
+// job's device.
+type ToolInvocation struct {
+	Name         string   `json:"name"`
+	Args         []string `json:"args"`
+	ExitCode     int      `json:"exitCode"`
+	Error        string   `json:"error,omitempty"`
+	DurationMs   int64    `json:"durationMs"`
+	Output       string   `json:"output"`
+	OutputBytes  int      `json:"outputBytes"`
+	OutputSHA256 string   `json:"outputSha256"`
+	Truncated    bool     `json:"truncated,omitempty"`
+}
+
+// jobLog appends hash-chained entries to a job's log file, stored with the
+// certificates as <job>.log.jsonl.
+type jobLog struct {
+	mutex   sync.Mutex
+	file    *os.File
+	path    string
+	seq     int
+	last    string
+	err     error
+	targets []string // device path and serial that tool arguments are matched against
+}
+
+var (
+	// jobLogs are the logs of running jobs, which tool invocations are
+	// recorded into.
+	jobLogs      = make(map[*jobLog]bool)
+	jobLogsMutex = &sync.Mutex{}
+)
+
+func jobLogPath(jobID string) (string, error) {
+	return configPath("certificates", jobID+".log.jsonl")
+}
+
+func newJobLog(jobID string, config WipeConfig) (*jobLog, error) {
+	path, err := jobLogPath(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("could not create certificates directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not create job log: %w", err)
+	}
+	l := &jobLog{file: file, path: path, last: jobLogGenesis(jobID)}
+	for _, t := range []string{config.DevicePath, config.DeviceSerial} {
+		if t != "" {
+			l.targets = append(l.targets, t)
+		}
+	}
+	jobLogsMutex.Lock()
+	jobLogs[l] = true
+	jobLogsMutex.Unlock()
+	return l, nil
+}
+
+// record appends an entry. A failed write is remembered, and the log then
+// yields no final hash, since the file no longer matches the chain.
+func (l *jobLog) record(kind string, data interface{}) {
+	if l == nil {
+		return
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		raw, _ = json.Marshal(fmt.Sprintf("unencodable %T: %v", data, err))
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil || l.err != nil {
+		return
+	}
+	l.seq++
+	entry := JobLogEntry{Seq: l.seq, Time: time.Now().UTC(), Type: kind, Data: raw, Prev: l.last}
+	entry.Hash = entry.computeHash()
+	line, _ := json.Marshal(entry)
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		l.err = fmt.Errorf("could not write job log: %w", err)
+		log.Printf("Warning: %v", l.err)
+		return
+	}
+	l.last = entry.Hash
+}
+
+// close stops recording and returns the final chain hash.
+func (l *jobLog) close() (string, error) {
+	jobLogsMutex.Lock()
+	delete(jobLogs, l)
+	jobLogsMutex.Unlock()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return "", fmt.Errorf("job log already closed")
+	}
+	if err := l.file.Sync(); err != nil && l.err == nil {
+		l.err = fmt.Errorf("could not write job log: %w", err)
+	}
+	l.file.Close()
+	l.file = nil
+	if l.err != nil {
+		return "", l.err
+	}
+	return l.last, nil
+}
+
+// names reports whether a tool argument refers to one of the job's targets,
+// including partitions of its device (/dev/sda1, /dev/nvme0n1p1).
+func (l *jobLog) names(args []string) bool {
+	for _, arg := range args {
+		for _, t := range l.targets {
+			i := strings.Index(arg, t)
+			if i < 0 {
+				continue
+			}
+			rest := arg[i+len(t):]
+			if last := t[len(t)-1]; last >= '0' && last <= '9' {
+				if rest != "" && !strings.HasPrefix(rest, "p") {
+					continue
+				}
+				rest = strings.TrimPrefix(rest, "p")
+			}
+			if strings.Trim(rest, "0123456789") == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordTool adds a tool invocation to the log of every running job whose
+// device it names.
+func recordTool(name string, args []string, started time.Time, output []byte, err error) {
+	jobLogsMutex.Lock()
+	var logs []*jobLog
+	for l := range jobLogs {
+		if l.names(append([]string{name}, args...)) {
+			logs = append(logs, l)
+		}
+	}
+	jobLogsMutex.Unlock()
+	if len(logs) == 0 {
+		return
+	}
+
+	sum := sha256.Sum256(output)
+	inv := ToolInvocation{
+		Name:         name,
+		Args:         args,
+		DurationMs:   time.Since(started).Milliseconds(),
+		OutputBytes:  len(output),
+		OutputSHA256: hex.EncodeToString(sum[:]),
+	}
+	if err != nil {
+		inv.ExitCode = exitCode(err)
+		inv.Error = err.Error()
+	}
+	if len(output) > maxLoggedOutput {
+		output = output[:maxLoggedOutput]
+		inv.Truncated = true
+	}
+	inv.Output = string(output)
+	for _, l := range logs {
+		l.record("tool", inv)
+	}
+}
+
+// recordingBackend is the active backend with every tool invocation recorded
+// into the logs of the jobs it concerns.
+type recordingBackend struct {
+	Backend
+}
+
+func (b recordingBackend) Output(name string, args ...string) ([]byte, error) {
+	started := time.Now()
+	out, err := b.Backend.Output(name, args...)
+	recordTool(name, args, started, out, err)
+	return out, err
+}
+
+func (b recordingBackend) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	started := time.Now()
+	out, err := b.Backend.CombinedOutput(ctx, name, args...)
+	recordTool(name, args, started, out, err)
+	return out, err
+}
+
+func (b recordingBackend) StreamOutput(ctx context.Context, onLine func(string), name string, args ...string) error {
+	started := time.Now()
+	var out []byte
+	err := b.Backend.StreamOutput(ctx, func(line string) {
+		out = append(out, line+"\n"...)
+		onLine(line)
+	}, name, args...)
+	recordTool(name, args, started, out, err)
+	return err
+}
+
+func (b recordingBackend) ResetZones(file *os.File, path string) error {
+	started := time.Now()
+	err := b.Backend.ResetZones(file, path)
+	recordTool("ioctl", []string{"BLKRESETZONE", path}, started, nil, err)
+	return err
+}
+
+// teeProgress records a job's progress into its log on the way to progress:
+// plain messages as steps, and WipeProgress updates as pass boundaries
+// whenever the pass or status changes. The returned function waits for the
+// tee to drain after the last message.
+func (l *jobLog) teeProgress(progress chan<- string) (chan<- string, func()) {
+	tee := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var status string
+		pass := -1
+		for msg := range tee {
+			var p WipeProgress
+			if json.Unmarshal([]byte(msg), &p) == nil && p.Status != "" {
+				if p.Status != status || p.CurrentPass != pass {
+					status, pass = p.Status, p.CurrentPass
+					l.record("pass", map[string]interface{}{
+						"status":      p.Status,
+						"pass":        p.CurrentPass,
+						"totalPasses": p.TotalPasses,
+						"progress":    p.Progress,
+					})
+				}
+			} else {
+				l.record("step", msg)
+			}
+			progress <- msg
+		}
+	}()
+	return tee, func() {
+		close(tee)
+		<-done
+	}
+}
+
+// VerifyJobLog checks the hash chain of a job log and returns its final hash.
+func VerifyJobLog(jobID, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("could not open job log: %w", err)
+	}
+	defer file.Close()
+
+	last := jobLogGenesis(jobID)
+	seq := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry JobLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return "", fmt.Errorf("job log entry %d is not valid JSON: %w", seq+1, err)
+		}
+		seq++
+		if entry.Seq != seq || entry.Prev != last {
+			return "", fmt.Errorf("job log is broken at entry %d: missing, reordered or foreign entries", seq)
+		}
+		if entry.computeHash() != entry.Hash {
+			return "", fmt.Errorf("job log entry %d has been modified", seq)
+		}
+		last = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("could not read job log: %w", err)
+	}
+	if seq == 0 {
+		return "", fmt.Errorf("job log is empty")
+	}
+	return last, nil
+}
+
+// VerifiedLogHash checks a finished job's log on disk against the hash
+// recorded when the log was closed, and returns that hash.
+func (j *WipeJob) VerifiedLogHash() (string, error) {
+	if j.LogPath == "" || j.LogHash == "" {
+		return "", fmt.Errorf("job %s has no complete wipe log", j.ID)
+	}
+	hash, err := VerifyJobLog(j.ID, j.LogPath)
+	if err != nil {
+		return "", fmt.Errorf("wipe log of job %s failed verification: %w", j.ID, err)
+	}
+	if hash != j.LogHash {
+		return "", fmt.Errorf("wipe log of job %s does not end in its recorded hash", j.ID)
+	}
+	return hash, nil
+}
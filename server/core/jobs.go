@@ -0,0 +1,338 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// WipeJob is the backend's own record of one wipe, persisted under
+// ~/.config/DZap/jobs so it survives restarts and can back a certificate.
+type WipeJob struct {
+	ID         string     `json:"id"`
+	Config     WipeConfig `json:"config"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Device is what detection found at the start of the job.
+	Device *DeviceDetails `json:"device,omitempty"`
+	// SignaturesBefore records what was on the device; SignaturesAfter is the
+	// post-wipe check that no recognizable signatures remain.
+	SignaturesBefore *SignatureScan `json:"signaturesBefore,omitempty"`
+	SignaturesAfter  *SignatureScan `json:"signaturesAfter,omitempty"`
+	// Reprovision is reported on its own: Status covers only the sanitization.
+	Reprovision *ReprovisionResult `json:"reprovision,omitempty"`
+	// CertificatePath is the certificate issued when the job succeeded, or
+	// CertificateError why none could be.
+	CertificatePath  string `json:"certificatePath,omitempty"`
+	CertificateError string `json:"certificateError,omitempty"`
+	// Hooks records every pre-wipe, post-wipe and wipe-failed hook run. Only
+	// the pre-wipe run is also in the log; the others run after it is closed.
+	Hooks []HookRun `json:"hooks,omitempty"`
+	// AndroidReset follows a phone's factory reset until it is back in setup state.
+	AndroidReset *AndroidReset `json:"androidReset,omitempty"`
+	// FastbootErase records a bootloader wipe and its check.
+	FastbootErase *FastbootErase `json:"fastbootErase,omitempty"`
+	// LogPath is the job's hash-chained log; LogHash is its final chain hash,
+	// set once the job is over and the log is complete.
+	LogPath string `json:"logPath,omitempty"`
+	LogHash string `json:"logHash,omitempty"`
+
+	log *jobLog
+}
+
+var (
+	jobs      = make(map[string]*WipeJob)
+	jobsMutex = &sync.Mutex{}
+)
+
+// configPath returns a path under the DZap config directory.
+func configPath(elem ...string) (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get user config directory: %w", err)
+	}
+	return filepath.Join(append([]string{configDir, "DZap"}, elem...)...), nil
+}
+
+func newJobID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// RunWipeJob sanitizes the device described by config and records the job.
+// Storage targets are scanned for signatures before and after the wipe; the
+// job only succeeds if the post-wipe scan comes back clean. A requested
+// reprovision runs afterwards and its outcome is recorded separately.
+//
+// A pre-wipe hook that exits non-zero vetoes the job. The post-wipe hook runs
+// after every job, once its certificate is issued, the wipe-failed hook
+// additionally after a failure.
+func RunWipeJob(config WipeConfig, progress chan<- string) (*WipeJob, error) {
+	config.ConfirmationToken = ""
+	// Model and serial in progress messages, hooks and the certificate come
+	// from the device itself, not from the request.
+	device := detectDeviceDetails(config)
+	if device != nil {
+		config.DeviceModel = device.Model
+		if config.DeviceType != "Android" {
+			config.DeviceSerial = device.Serial
+		}
+	}
+	job := &WipeJob{
+		ID:        newJobID(),
+		Config:    config,
+		Device:    device,
+		Status:    JobRunning,
+		StartedAt: time.Now().UTC(),
+	}
+	if l, err := newJobLog(job.ID, config); err != nil {
+		log.Printf("Warning: job %s runs without a log and cannot be certified: %v", job.ID, err)
+	} else {
+		job.log, job.LogPath = l, l.path
+	}
+	jobsMutex.Lock()
+	jobs[job.ID] = job
+	jobsMutex.Unlock()
+	job.save()
+	job.log.record("config", config)
+	job.log.record("device", device)
+
+	var err error
+	if _, hookErr := job.runHook(HookPreWipe); hookErr != nil {
+		err = fmt.Errorf("wipe vetoed: %w", hookErr)
+	} else {
+		jobProgress, drain := progress, func() {}
+		if job.log != nil {
+			jobProgress, drain = job.log.teeProgress(progress)
+		}
+		err = runJob(job, jobProgress)
+		if err == nil && config.Reprovision != nil {
+			result := Reprovision(config, jobProgress)
+			job.update(func(j *WipeJob) { j.Reprovision = result })
+			job.log.record("reprovision", result)
+		}
+		drain()
+	}
+	if err != nil {
+		job.log.record("error", err.Error())
+	}
+	job.finish(err)
+	job.log.record("result", map[string]string{"status": job.Status, "error": job.Error})
+	requestRescan()
+
+	// The certificate is issued first so the post-wipe hook receives its
+	// path. Hook runs from here on are kept in the job record only, outside
+	// the log the certificate covers.
+	job.closeLog()
+	if err == nil {
+		job.issueCertificate(progress)
+	}
+	job.runHook(HookPostWipe)
+	if err != nil {
+		job.runHook(HookWipeFailed)
+	}
+	return job, err
+}
+
+// issueCertificate certifies a succeeded job from its own record and log, and
+// announces the certificate on progress. Failing to certify does not fail the
+// wipe; the reason is kept on the job.
+func (j *WipeJob) issueCertificate(progress chan<- string) {
+	if _, err := IssueJobCertificate(j.ID); err != nil {
+		log.Printf("Warning: could not issue a certificate for job %s: %v", j.ID, err)
+		j.update(func(j *WipeJob) { j.CertificateError = err.Error() })
+		return
+	}
+	msg, _ := json.Marshal(map[string]string{
+		"status":        "certificate_issued",
+		"deviceId":      j.Config.DevicePath,
+		"jobId":         j.ID,
+		"certificateId": j.ID,
+	})
+	progress <- string(msg)
+}
+
+// closeLog completes the job's log and records its final hash.
+func (j *WipeJob) closeLog() {
+	if j.log == nil {
+		return
+	}
+	hash, err := j.log.close()
+	if err != nil {
+		log.Printf("Warning: log of job %s is incomplete and cannot back a certificate: %v", j.ID, err)
+		return
+	}
+	j.update(func(j *WipeJob) { j.LogHash = hash })
+}
+
+func runJob(job *WipeJob, progress chan<- string) error {
+	config := job.Config
+	if config.DeviceType == "Android" {
+		return runAndroidJob(job, progress)
+	}
+
+	before, err := ScanSignatures(config.DevicePath)
+	if err != nil {
+		log.Printf("Warning: pre-wipe signature scan of %s failed: %v", config.DevicePath, err)
+	} else {
+		job.update(func(j *WipeJob) { j.SignaturesBefore = before })
+		job.log.record("verification", map[string]interface{}{"check": "signatures before wipe", "scan": before})
+		progress <- fmt.Sprintf("Found before wipe: %s", before.Summary())
+	}
+
+	if err := SanitizeDevice(config, progress); err != nil {
+		return err
+	}
+
+	after, err := ScanSignatures(config.DevicePath)
+	if err != nil {
+		return fmt.Errorf("post-wipe signature check failed: %w", err)
+	}
+	job.update(func(j *WipeJob) { j.SignaturesAfter = after })
+	job.log.record("verification", map[string]interface{}{"check": "signatures after wipe", "scan": after})
+	if !after.Clean {
+		// A cryptographic erase keeps the partition table; it describes only
+		// LUKS partitions whose keyslots are gone.
+		if config.Method == "luks_crypto_erase" && after.onlyPartitionTables() {
+			job.log.record("verification", map[string]interface{}{"check": "partition table kept", "result": "the partition table remains and lists only erased LUKS volumes", "partitionTable": after.PartitionTable})
+			progress <- fmt.Sprintf("Post-wipe check: only the partition table remains (%s); every partition was a LUKS volume.", after.Summary())
+			return nil
+		}
+		return fmt.Errorf("signatures remain after wipe: %s", after.Summary())
+	}
+	progress <- "Post-wipe check: no recognizable signatures remain."
+	return nil
+}
+
+// runAndroidJob wipes a phone, through its bootloader for fastboot methods
+// and by a factory reset otherwise, and records the verification.
+func runAndroidJob(job *WipeJob, progress chan<- string) error {
+	config := job.Config
+	if isFastbootMethod(config.Method) {
+		erase, err := sanitizeFastboot(config, progress)
+		job.update(func(j *WipeJob) { j.FastbootErase = erase })
+		if erase != nil {
+			job.log.record("verification", erase)
+		}
+		return err
+	}
+	reset, err := sanitizeAndroid(config, progress)
+	job.update(func(j *WipeJob) { j.AndroidReset = reset })
+	if reset != nil {
+		job.log.record("verification", reset)
+	}
+	return err
+}
+
+func (j *WipeJob) update(fn func(*WipeJob)) {
+	jobsMutex.Lock()
+	fn(j)
+	jobsMutex.Unlock()
+	j.save()
+}
+
+func (j *WipeJob) finish(err error) {
+	j.update(func(j *WipeJob) {
+		now := time.Now().UTC()
+		j.FinishedAt = &now
+		if err != nil {
+			j.Status = JobFailed
+			j.Error = err.Error()
+		} else {
+			j.Status = JobSucceeded
+		}
+	})
+}
+
+func (j *WipeJob) save() {
+	dir, err := configPath("jobs")
+	if err != nil {
+		log.Printf("Warning: could not persist job %s: %v", j.ID, err)
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("Warning: could not create jobs directory: %v", err)
+		return
+	}
+
+	jobsMutex.Lock()
+	data, err := json.MarshalIndent(j, "", "  ")
+	jobsMutex.Unlock()
+	if err != nil {
+		log.Printf("Warning: could not encode job %s: %v", j.ID, err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, j.ID+".json"), data, 0600); err != nil {
+		log.Printf("Warning: could not persist job %s: %v", j.ID, err)
+	}
+}
+
+// GetJob returns a snapshot of a job from memory or, after a restart, from disk.
+func GetJob(id string) (*WipeJob, error) {
+	jobsMutex.Lock()
+	job, ok := jobs[id]
+	if ok {
+		snapshot := *job
+		jobsMutex.Unlock()
+		return &snapshot, nil
+	}
+	jobsMutex.Unlock()
+
+	if strings.ContainsAny(id, `/\`) || id == "" {
+		return nil, fmt.Errorf("invalid job id %q", id)
+	}
+	dir, err := configPath("jobs")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("job %s not found", id)
+	}
+	job = &WipeJob{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, fmt.Errorf("failed to parse job %s: %w", id, err)
+	}
+	return job, nil
+}
+
+// ListJobs returns every persisted job, newest first.
+func ListJobs() ([]*WipeJob, error) {
+	dir, err := configPath("jobs")
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return []*WipeJob{}, nil
+	}
+
+	list := []*WipeJob{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		if job, err := GetJob(strings.TrimSuffix(file.Name(), ".json")); err == nil {
+			list = append(list, job)
+		}
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].StartedAt.After(list[b].StartedAt) })
+	return list, nil
+}
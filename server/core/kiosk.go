@@ -0,0 +1,391 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kiosk slot states.
+const (
+	SlotPresent  = "present"  // attached when the station was armed; never touched
+	SlotRejected = "rejected" // does not match the policy
+	SlotWiping   = "wiping"
+	SlotDone     = "done"
+	SlotFailed   = "failed"
+	SlotRemoved  = "removed"
+)
+
+// KioskPolicy decides which newly attached drives a wipe station sanitizes.
+type KioskPolicy struct {
+	Method string `json:"method"`
+	// Transports are lsblk TRAN values such as "usb" or "sata".
+	Transports []string `json:"transports"`
+	MinBytes   int64    `json:"minBytes,omitempty"`
+	MaxBytes   int64    `json:"maxBytes,omitempty"`
+	// DenyList holds serials, WWNs or models that are never wiped.
+	DenyList []string `json:"denyList,omitempty"`
+}
+
+// KioskSlot is the station's view of one attached drive.
+type KioskSlot struct {
+	Device        string         `json:"device"`
+	Model         string         `json:"model"`
+	Size          int64          `json:"size"`
+	Transport     string         `json:"transport"`
+	Identity      DeviceIdentity `json:"identity"`
+	State         string         `json:"state"`
+	Reason        string         `json:"reason,omitempty"`
+	Progress      float64        `json:"progress"`
+	JobID         string         `json:"jobId,omitempty"`
+	CertificateID string         `json:"certificateId,omitempty"`
+	AttachedAt    time.Time      `json:"attachedAt"`
+	UpdatedAt     time.Time      `json:"updatedAt"`
+}
+
+// KioskStatus is reported by GetKioskStatus.
+type KioskStatus struct {
+	Armed   bool         `json:"armed"`
+	ArmedAt *time.Time   `json:"armedAt,omitempty"`
+	Policy  *KioskPolicy `json:"policy,omitempty"`
+	Slots   []KioskSlot  `json:"slots"`
+}
+
+var (
+	kioskPolicy  *KioskPolicy
+	kioskArmedAt time.Time
+	kioskSlots   = make(map[string]*KioskSlot)
+	// kioskPresent holds the identities attached at arm time. They are never
+	// wiped while the station stays armed, even if they drop out of a scan and
+	// come back.
+	kioskPresent = make(map[DeviceIdentity]bool)
+	// kioskPresentDevices maps the device names of drives attached at arm
+	// time to their sysfs paths, until a scan sees them detached. A drive at
+	// one of them is still the arm-time drive when its identity changes, as
+	// when udev fills in a serial late or a USB bridge reports differently.
+	kioskPresentDevices = make(map[string]string)
+	kioskMutex          = &sync.Mutex{}
+	kioskEvents         chan<- string
+)
+
+// Validate checks the policy and fills in the default transports.
+func (p *KioskPolicy) Validate() error {
+	if _, ok := wipeMethodNames[p.Method]; !ok || p.Method == "android_factory_reset" {
+		return fmt.Errorf("unsupported kiosk wipe method %q", p.Method)
+	}
+	if len(p.Transports) == 0 {
+		p.Transports = []string{"usb", "sata"}
+	}
+	if p.MinBytes < 0 || (p.MaxBytes > 0 && p.MaxBytes < p.MinBytes) {
+		return fmt.Errorf("invalid size range %d-%d", p.MinBytes, p.MaxBytes)
+	}
+	return nil
+}
+
+// admits returns why drive is not eligible under the policy, or "" if it is.
+func (p *KioskPolicy) admits(drive Drive, size int64) string {
+	transport := false
+	for _, t := range p.Transports {
+		if strings.EqualFold(t, drive.Transport) {
+			transport = true
+		}
+	}
+	if !transport {
+		return fmt.Sprintf("transport %q is not in the policy", drive.Transport)
+	}
+	if size < p.MinBytes || (p.MaxBytes > 0 && size > p.MaxBytes) {
+		return fmt.Sprintf("size %d bytes is outside the policy range", size)
+	}
+	for _, deny := range p.DenyList {
+		deny = strings.TrimSpace(deny)
+		if deny != "" && (deny == drive.Identity.Serial || deny == drive.Identity.WWN || strings.EqualFold(deny, drive.Model)) {
+			return fmt.Sprintf("%s is on the deny-list", deny)
+		}
+	}
+	if drive.IsOSDrive {
+		return "system disk: " + strings.Join(drive.SystemDiskReasons, ", ")
+	}
+	for _, m := range GetWipeMethodsForDrive(drive) {
+		if m.ID == p.Method {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s is not supported on this %s", p.Method, drive.Type)
+}
+
+// StartKiosk hooks the station into the device registry, so it reacts to
+// hot-plugged drives. Slot updates and wipe progress are sent on events. The
+// station does nothing until ArmKiosk is called.
+func StartKiosk(events chan<- string) {
+	kioskEvents = events
+	onDrivesChanged(scanKioskSlots)
+}
+
+// ArmKiosk arms the station with policy. Drives attached at this point are
+// recorded as present and left alone for the whole armed session; only other
+// drives attached later are wiped.
+func ArmKiosk(policy KioskPolicy) (*KioskStatus, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	drives, err := detectStorageDrives()
+	if err != nil {
+		return nil, fmt.Errorf("could not detect drives: %w", err)
+	}
+
+	kioskMutex.Lock()
+	kioskPolicy = &policy
+	kioskArmedAt = time.Now().UTC()
+	kioskSlots = make(map[string]*KioskSlot)
+	kioskPresent = make(map[DeviceIdentity]bool)
+	kioskPresentDevices = make(map[string]string)
+	for _, drive := range drives {
+		slot := newKioskSlot(drive)
+		slot.State = SlotPresent
+		slot.Reason = "attached before the station was armed"
+		kioskSlots[drive.Name] = slot
+		kioskPresent[drive.Identity] = true
+		kioskPresentDevices[drive.Name] = kioskSysfsPath(drive.Name)
+	}
+	kioskMutex.Unlock()
+
+	log.Printf("Kiosk armed: %s for new %s drives", policy.Method, strings.Join(policy.Transports, "/"))
+	return GetKioskStatus(), nil
+}
+
+// DisarmKiosk stops the station from starting new wipes. Wipes already
+// running are left to finish.
+func DisarmKiosk() *KioskStatus {
+	kioskMutex.Lock()
+	kioskPolicy = nil
+	kioskMutex.Unlock()
+	log.Printf("Kiosk disarmed")
+	return GetKioskStatus()
+}
+
+// GetKioskStatus returns the policy and every slot, ordered by device.
+func GetKioskStatus() *KioskStatus {
+	kioskMutex.Lock()
+	defer kioskMutex.Unlock()
+	status := &KioskStatus{Armed: kioskPolicy != nil, Slots: []KioskSlot{}}
+	if kioskPolicy != nil {
+		policy := *kioskPolicy
+		armedAt := kioskArmedAt
+		status.Policy, status.ArmedAt = &policy, &armedAt
+	}
+	for _, slot := range kioskSlots {
+		status.Slots = append(status.Slots, *slot)
+	}
+	sort.Slice(status.Slots, func(a, b int) bool { return status.Slots[a].Device < status.Slots[b].Device })
+	return status
+}
+
+func newKioskSlot(drive Drive) *KioskSlot {
+	size, _ := strconv.ParseInt(drive.Size, 10, 64)
+	now := time.Now().UTC()
+	return &KioskSlot{
+		Device:     drive.Name,
+		Model:      drive.Model,
+		Size:       size,
+		Transport:  drive.Transport,
+		Identity:   drive.Identity,
+		AttachedAt: now,
+		UpdatedAt:  now,
+	}
+}
+
+// scanKioskSlots compares the attached drives with the known slots. A device
+// name that now reports a different identity is a different drive.
+func scanKioskSlots(drives []Drive) {
+	var added []Drive
+	var changed []KioskSlot
+	attached := make(map[string]bool)
+
+	kioskMutex.Lock()
+	policy := kioskPolicy
+	if policy == nil {
+		kioskMutex.Unlock()
+		return
+	}
+	for _, drive := range drives {
+		attached[drive.Name] = true
+		if slot, ok := kioskSlots[drive.Name]; ok && slot.Identity == drive.Identity {
+			continue
+		}
+		added = append(added, drive)
+	}
+	for name, slot := range kioskSlots {
+		if !attached[name] || slot.Identity != identityOf(drives, name) {
+			// A drive pulled mid-wipe shows up as a failed job; the slot goes now.
+			delete(kioskSlots, name)
+			slot.State = SlotRemoved
+			slot.UpdatedAt = time.Now().UTC()
+			changed = append(changed, *slot)
+		}
+	}
+	var start []*KioskSlot
+	for _, drive := range added {
+		slot := newKioskSlot(drive)
+		size, _ := strconv.ParseInt(drive.Size, 10, 64)
+		if kioskPresent[drive.Identity] {
+			slot.State, slot.Reason = SlotPresent, "attached before the station was armed"
+		} else if kioskWasPresent(drive.Name) {
+			slot.State, slot.Reason = SlotPresent, "attached before the station was armed (its identity has changed since)"
+		} else if reason := policy.admits(drive, size); reason != "" {
+			slot.State, slot.Reason = SlotRejected, reason
+		} else {
+			slot.State = SlotWiping
+			start = append(start, slot)
+		}
+		kioskSlots[drive.Name] = slot
+		changed = append(changed, *slot)
+	}
+	// Only once a device is seen detached can a drive there be a new one.
+	for name := range kioskPresentDevices {
+		if !attached[name] {
+			delete(kioskPresentDevices, name)
+		}
+	}
+	kioskMutex.Unlock()
+
+	for _, slot := range changed {
+		kioskBroadcastSlot(slot)
+	}
+	for _, slot := range start {
+		go runKioskWipe(slot, *policy)
+	}
+}
+
+// kioskWasPresent reports whether a drive at name, or at the same sysfs
+// device, was attached at arm time and has not been seen detached since.
+// Callers hold kioskMutex.
+func kioskWasPresent(name string) bool {
+	if _, ok := kioskPresentDevices[name]; ok {
+		return true
+	}
+	path := kioskSysfsPath(name)
+	for _, presentPath := range kioskPresentDevices {
+		if path != "" && path == presentPath {
+			return true
+		}
+	}
+	return false
+}
+
+// kioskSysfsPath is where a block device sits in the sysfs device tree, which
+// follows the port it is attached to rather than what the drive reports.
+func kioskSysfsPath(name string) string {
+	path, err := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "class", "block", filepath.Base(name)))
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+func identityOf(drives []Drive, name string) DeviceIdentity {
+	for _, d := range drives {
+		if d.Name == name {
+			return d.Identity
+		}
+	}
+	return DeviceIdentity{}
+}
+
+// runKioskWipe releases the drive (desktops often auto-mount it), runs the
+// usual preflight checks and the wipe job, then issues the certificate.
+func runKioskWipe(slot *KioskSlot, policy KioskPolicy) {
+	config := WipeConfig{
+		DevicePath:   slot.Device,
+		Method:       policy.Method,
+		DeviceSerial: slot.Identity.Serial,
+		DeviceModel:  slot.Model,
+		Identity:     slot.Identity,
+	}
+	log.Printf("Kiosk: wiping %s (%s, %s) with %s", slot.Device, slot.Model, slot.Identity, policy.Method)
+
+	if err := kioskPrepare(config); err != nil {
+		log.Printf("Kiosk: not wiping %s: %v", slot.Device, err)
+		updateKioskSlot(slot, func(s *KioskSlot) { s.State, s.Reason = SlotFailed, err.Error() })
+		return
+	}
+
+	progress := make(chan string)
+	go func() {
+		for msg := range progress {
+			var p WipeProgress
+			if json.Unmarshal([]byte(msg), &p) == nil && p.DeviceID == slot.Device && p.Status != "done" && p.Status != "certificate_issued" {
+				kioskMutex.Lock()
+				slot.Progress = p.Progress
+				kioskMutex.Unlock()
+			}
+			kioskEvents <- msg
+		}
+	}()
+	job, err := RunWipeJob(config, progress)
+	close(progress)
+	if err != nil {
+		log.Printf("Kiosk: wipe of %s failed: %v", slot.Device, err)
+		updateKioskSlot(slot, func(s *KioskSlot) { s.State, s.Reason, s.JobID = SlotFailed, err.Error(), job.ID })
+		return
+	}
+
+	if job.CertificatePath == "" {
+		log.Printf("Kiosk: no certificate for job %s: %s", job.ID, job.CertificateError)
+		updateKioskSlot(slot, func(s *KioskSlot) {
+			s.State, s.Reason, s.JobID = SlotFailed, "wiped, but certificate failed: "+job.CertificateError, job.ID
+		})
+		return
+	}
+	updateKioskSlot(slot, func(s *KioskSlot) {
+		s.State, s.Progress, s.JobID, s.CertificateID = SlotDone, 100, job.ID, job.ID
+	})
+}
+
+func kioskPrepare(config WipeConfig) error {
+	if err := CheckSystemDisk(config.DevicePath); err != nil {
+		return err
+	}
+	report, err := ReleaseDevice(config.DevicePath, false)
+	if err != nil {
+		return fmt.Errorf("could not release drive: %w", err)
+	}
+	if failed := report.Failed(); len(failed) > 0 {
+		return fmt.Errorf("could not release drive: %s %s: %s", failed[0].Kind, failed[0].Target, failed[0].Error)
+	}
+
+	result, err := Preflight(config)
+	if err != nil {
+		return err
+	}
+	for _, c := range result.Checks {
+		if !c.Passed {
+			return fmt.Errorf("preflight check %s failed: %s", c.Name, c.Detail)
+		}
+	}
+	return nil
+}
+
+// updateKioskSlot changes a slot, unless it has since been removed, and
+// broadcasts it.
+func updateKioskSlot(slot *KioskSlot, fn func(*KioskSlot)) {
+	kioskMutex.Lock()
+	fn(slot)
+	slot.UpdatedAt = time.Now().UTC()
+	current := kioskSlots[slot.Device] == slot
+	snapshot := *slot
+	kioskMutex.Unlock()
+	if current {
+		kioskBroadcastSlot(snapshot)
+	}
+}
+
+func kioskBroadcastSlot(slot KioskSlot) {
+	data, _ := json.Marshal(map[string]interface{}{"status": "kiosk_slot", "slot": slot})
+	kioskEvents <- string(data)
+}
@@ -0,0 +1,365 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const (
+	luks1KeyslotActive = 0x00AC71F3
+	luks1Keyslots      = 8
+	luks1KeyslotSize   = 48
+	luksMagic          = "LUKS\xba\xbe"
+	luks2SecondMagic   = "SKUL\xba\xbe"
+)
+
+// luks2SecondaryOffsets are the places a LUKS2 secondary header may start,
+// one per allowed header size (16 KiB to 4 MiB).
+var luks2SecondaryOffsets = []int64{0x4000, 0x8000, 0x10000, 0x20000, 0x40000, 0x80000, 0x100000, 0x200000, 0x400000}
+
+// LUKSVolume is a LUKS header found on a drive or one of its partitions.
+type LUKSVolume struct {
+	Device  string `json:"device"`
+	Version int    `json:"version"`
+	UUID    string `json:"uuid"`
+	// ActiveKeyslots is the number of keyslots that can unlock the volume.
+	ActiveKeyslots int `json:"activeKeyslots"`
+	// Offset is where the volume starts on the whole drive, in bytes.
+	Offset int64 `json:"offset"`
+	// DataOffset is where the encrypted data starts, relative to Offset.
+	DataOffset int64 `json:"dataOffset"`
+
+	// areas holds the header copies and keyslot areas, relative to Offset.
+	areas []extent
+}
+
+// parseLUKSHeader reads the LUKS1 or LUKS2 header at base. A LUKS2 volume
+// whose primary header is damaged is still found through its secondary header.
+func parseLUKSHeader(r io.ReaderAt, base int64) (*LUKSVolume, error) {
+	hdr := make([]byte, 4096)
+	if _, err := r.ReadAt(hdr, base); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(hdr[0:6]) == luksMagic {
+		switch binary.BigEndian.Uint16(hdr[6:8]) {
+		case 1:
+			return parseLUKS1(hdr, base)
+		case 2:
+			return parseLUKS2(r, hdr, base, 0)
+		default:
+			return nil, fmt.Errorf("unsupported LUKS version %d", binary.BigEndian.Uint16(hdr[6:8]))
+		}
+	}
+	for _, off := range luks2SecondaryOffsets {
+		if _, err := r.ReadAt(hdr, base+off); err != nil && err != io.EOF {
+			continue
+		}
+		if string(hdr[0:6]) == luks2SecondMagic && binary.BigEndian.Uint16(hdr[6:8]) == 2 {
+			return parseLUKS2(r, hdr, base, off)
+		}
+	}
+	return nil, fmt.Errorf("no LUKS header")
+}
+
+func parseLUKS1(hdr []byte, base int64) (*LUKSVolume, error) {
+	v := &LUKSVolume{
+		Version:    1,
+		UUID:       cString(hdr[168:208]),
+		Offset:     base,
+		DataOffset: int64(binary.BigEndian.Uint32(hdr[104:108])) * 512,
+		areas:      []extent{{offset: 0, length: 4096}},
+	}
+	keyBytes := int64(binary.BigEndian.Uint32(hdr[108:112]))
+	for i := 0; i < luks1Keyslots; i++ {
+		slot := hdr[208+i*luks1KeyslotSize : 208+(i+1)*luks1KeyslotSize]
+		if binary.BigEndian.Uint32(slot[0:4]) == luks1KeyslotActive {
+			v.ActiveKeyslots++
+		}
+		// Inactive slots may still hold material from a removed key.
+		materialOffset := int64(binary.BigEndian.Uint32(slot[40:44])) * 512
+		stripes := int64(binary.BigEndian.Uint32(slot[44:48]))
+		if materialOffset > 0 && stripes > 0 {
+			length := (keyBytes*stripes + 511) / 512 * 512
+			v.areas = append(v.areas, extent{offset: materialOffset, length: length})
+		}
+	}
+	// Everything before the payload is header and keyslot material.
+	if v.DataOffset > 0 {
+		v.areas = append(v.areas, extent{offset: 0, length: v.DataOffset})
+	}
+	return v, nil
+}
+
+type luks2Metadata struct {
+	Keyslots map[string]struct {
+		Area struct {
+			Offset string `json:"offset"`
+			Size   string `json:"size"`
+		} `json:"area"`
+	} `json:"keyslots"`
+	Segments map[string]struct {
+		Offset string `json:"offset"`
+	} `json:"segments"`
+	Config struct {
+		JSONSize     string `json:"json_size"`
+		KeyslotsSize string `json:"keyslots_size"`
+	} `json:"config"`
+}
+
+// parseLUKS2 reads a LUKS2 header from the copy at base+hdrOffset. The binary
+// header records its own offset, so either copy locates both.
+func parseLUKS2(r io.ReaderAt, hdr []byte, base, hdrOffset int64) (*LUKSVolume, error) {
+	hdrSize := int64(binary.BigEndian.Uint64(hdr[8:16]))
+	if hdrSize < 0x4000 || hdrSize > 0x400000 {
+		return nil, fmt.Errorf("invalid LUKS2 header size %d", hdrSize)
+	}
+	v := &LUKSVolume{
+		Version: 2,
+		UUID:    cString(hdr[168:208]),
+		Offset:  base,
+		// Primary and secondary binary header plus JSON area.
+		areas: []extent{{offset: 0, length: 2 * hdrSize}},
+	}
+
+	jsonArea := make([]byte, hdrSize-4096)
+	if _, err := r.ReadAt(jsonArea, base+hdrOffset+4096); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read LUKS2 metadata: %w", err)
+	}
+	if i := bytes.IndexByte(jsonArea, 0); i >= 0 {
+		jsonArea = jsonArea[:i]
+	}
+	var meta luks2Metadata
+	if err := json.Unmarshal(jsonArea, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse LUKS2 metadata: %w", err)
+	}
+
+	v.ActiveKeyslots = len(meta.Keyslots)
+	for _, ks := range meta.Keyslots {
+		offset, err1 := strconv.ParseInt(ks.Area.Offset, 10, 64)
+		size, err2 := strconv.ParseInt(ks.Area.Size, 10, 64)
+		if err1 == nil && err2 == nil && size > 0 {
+			v.areas = append(v.areas, extent{offset: offset, length: size})
+		}
+	}
+	// The binary keyslots area also holds material from removed keyslots.
+	if size, err := strconv.ParseInt(meta.Config.KeyslotsSize, 10, 64); err == nil && size > 0 {
+		v.areas = append(v.areas, extent{offset: 2 * hdrSize, length: size})
+	}
+	for _, seg := range meta.Segments {
+		if offset, err := strconv.ParseInt(seg.Offset, 10, 64); err == nil && (v.DataOffset == 0 || offset < v.DataOffset) {
+			v.DataOffset = offset
+		}
+	}
+	return v, nil
+}
+
+// detectLUKSVolumes parses the header of every LUKS device lsblk reported on
+// dev: the disk itself or its partitions.
+func detectLUKSVolumes(dev *lsblkDevice) []LUKSVolume {
+	var candidates []string
+	if dev.FsType == "crypto_LUKS" {
+		candidates = append(candidates, dev.Name)
+	}
+	for _, child := range dev.Children {
+		if child.FsType == "crypto_LUKS" {
+			candidates = append(candidates, child.Name)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(backend.DevicePath("/dev/" + dev.Name))
+	if err != nil {
+		log.Printf("Warning: could not open %s to read LUKS headers: %v", dev.Name, err)
+		return nil
+	}
+	defer file.Close()
+
+	var volumes []LUKSVolume
+	for _, name := range candidates {
+		v, err := parseLUKSHeader(file, partitionStart(name))
+		if err != nil {
+			log.Printf("Warning: %s is reported as LUKS but its header could not be read: %v", name, err)
+			continue
+		}
+		v.Device = "/dev/" + name
+		volumes = append(volumes, *v)
+	}
+	return volumes
+}
+
+// partitionStart returns a partition's offset on its disk, or 0 for a disk.
+func partitionStart(name string) int64 {
+	start, err := strconv.ParseInt(readTrimmedFile(filepath.Join(sysfsRoot, "class", "block", name, "start")), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return start * 512
+}
+
+// checkLUKSClosed refuses to destroy keyslots of a volume that is unlocked:
+// the open mapping would keep the master key and the data readable.
+func checkLUKSClosed(volumes []LUKSVolume) error {
+	for _, v := range volumes {
+		holders, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", filepath.Base(v.Device), "holders"))
+		if len(holders) > 0 {
+			return fmt.Errorf("LUKS volume %s is open (held by %s); close it first", v.Device, holders[0].Name())
+		}
+	}
+	return nil
+}
+
+// checkLUKSCoverage refuses a cryptographic erase alone when the drive holds
+// data outside LUKS: every partition must be a LUKS volume, or the erase
+// would leave it readable.
+func checkLUKSCoverage(drive *Drive) error {
+	for _, p := range drive.Partitions {
+		if p.Type != "crypto_LUKS" {
+			return fmt.Errorf("partition %s is not LUKS-encrypted and would not be erased; use luks_erase_overwrite or an overwrite method", p.Name)
+		}
+	}
+	return nil
+}
+
+// checkTableCoverage checks the partition table read through the pinned
+// handle against the LUKS volumes, so a partition lsblk missed is not left
+// behind either.
+func checkTableCoverage(file *os.File, devicePath string, volumes []LUKSVolume) error {
+	device, err := deviceExtent(file, devicePath)
+	if err != nil {
+		return err
+	}
+	p := &prober{file: io.NewSectionReader(file, device.offset, device.length), size: device.length}
+	_, starts := p.partitionTables()
+	for _, start := range starts {
+		covered := false
+		for _, v := range volumes {
+			if v.Offset == start {
+				covered = true
+			}
+		}
+		if !covered {
+			return fmt.Errorf("the partition at offset %d of %s is not a LUKS volume and would not be erased", start, devicePath)
+		}
+	}
+	return nil
+}
+
+// sanitizeLUKS destroys every LUKS header copy and keyslot area on the drive,
+// which makes the encrypted data unrecoverable without touching it (a
+// cryptographic erase). The partition table is left in place. Without
+// overwrite, drives with partitions outside LUKS are refused.
+func sanitizeLUKS(config WipeConfig, drive *Drive, overwrite bool, progress chan<- string) error {
+	if len(drive.LUKS) == 0 {
+		return fmt.Errorf("no LUKS volumes found on %s", config.DevicePath)
+	}
+	if err := checkLUKSClosed(drive.LUKS); err != nil {
+		return err
+	}
+	if !overwrite {
+		if err := checkLUKSCoverage(drive); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controls := &WipeControls{
+		cancel: cancel,
+		pause:  make(chan bool),
+	}
+	wipeMutex.Lock()
+	activeWipes[config.DevicePath] = controls
+	wipeMutex.Unlock()
+
+	defer func() {
+		wipeMutex.Lock()
+		delete(activeWipes, config.DevicePath)
+		wipeMutex.Unlock()
+	}()
+
+	totalPasses := 1
+	if overwrite {
+		totalPasses = 2
+	}
+
+	file, err := openPinnedDevice(config, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !overwrite {
+		if err := checkTableCoverage(file, config.DevicePath, drive.LUKS); err != nil {
+			return err
+		}
+	}
+
+	// Re-read the headers through the pinned handle rather than trusting detection.
+	var extents []extent
+	for _, detected := range drive.LUKS {
+		v, err := parseLUKSHeader(file, detected.Offset)
+		if err != nil {
+			return fmt.Errorf("LUKS header on %s could not be re-read: %w", detected.Device, err)
+		}
+		progress <- fmt.Sprintf("Destroying LUKS%d header and %d keyslots on %s...", v.Version, v.ActiveKeyslots, detected.Device)
+		for _, a := range v.areas {
+			extents = append(extents, extent{offset: v.Offset + a.offset, length: a.length})
+		}
+	}
+	if err := overwriteExtents(ctx, controls, config, file, mergeExtents(extents), 0x00, 1, totalPasses, progress); err != nil {
+		return err
+	}
+
+	for _, v := range drive.LUKS {
+		if _, err := parseLUKSHeader(file, v.Offset); err == nil {
+			return fmt.Errorf("LUKS header on %s still parses after keyslot destruction", v.Device)
+		}
+	}
+	progress <- "Verified: no LUKS header remains."
+	file.Close()
+
+	if overwrite {
+		progress <- fmt.Sprintf("Executing Pass 2/2 (Pattern: 0x%02X)...", 0x00)
+		if err := overwritePass(ctx, controls, config, 0x00, 2, 2, progress); err != nil {
+			return err
+		}
+	}
+
+	completion := WipeProgress{
+		DeviceID: config.DevicePath,
+		Status:   "done",
+		Progress: 100,
+	}
+	jsonMsg, _ := json.Marshal(completion)
+	progress <- string(jsonMsg)
+	return nil
+}
+
+// mergeExtents sorts extents and merges the ones that touch or overlap.
+func mergeExtents(extents []extent) []extent {
+	sort.Slice(extents, func(i, j int) bool { return extents[i].offset < extents[j].offset })
+
+	var merged []extent
+	for _, e := range extents {
+		if n := len(merged); n > 0 && merged[n-1].offset+merged[n-1].length >= e.offset {
+			if end := e.offset + e.length; end > merged[n-1].offset+merged[n-1].length {
+				merged[n-1].length = end - merged[n-1].offset
+			}
+			continue
+		}
+		merged = append(merged, e)
+	}
+	return merged
+}
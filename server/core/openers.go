@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DeviceUser is a process keeping a device, or a filesystem on it, busy.
+type DeviceUser struct {
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+	// Path is what the process holds: an open file or device node, its cwd or
+	// root, a mapped file, or a mountpoint in another mount namespace.
+	Path string `json:"path"`
+	Kind string `json:"kind"` // "fd", "cwd", "root", "exe", "map" or "mount"
+}
+
+// DeviceUsers lists the processes holding devicePath, its partitions or any
+// device stacked on them (dm, md) open. It looks at open descriptors, working
+// and root directories, memory mappings, and mounts that are only visible in
+// another process's mount namespace.
+func DeviceUsers(devicePath string) ([]DeviceUser, error) {
+	name := filepath.Base(devicePath)
+	if _, err := os.Stat(filepath.Join(sysfsRoot, "class", "block", name)); err != nil {
+		return nil, fmt.Errorf("device %s not found", devicePath)
+	}
+
+	devNums := make(map[string]bool)
+	for _, n := range holderGraph(name) {
+		if dev := readTrimmedFile(filepath.Join(sysfsRoot, "class", "block", n, "dev")); dev != "" {
+			devNums[dev] = true
+		}
+	}
+
+	procs, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", procRoot, err)
+	}
+	ownNamespace, _ := os.Readlink(filepath.Join(procRoot, "self", "ns", "mnt"))
+
+	users := []DeviceUser{}
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		users = append(users, processDeviceUsers(pid, devNums, ownNamespace)...)
+	}
+	sort.SliceStable(users, func(i, j int) bool { return users[i].PID < users[j].PID })
+	return users, nil
+}
+
+// processDeviceUsers collects what one process holds. Processes can exit while
+// being inspected, so unreadable entries are skipped.
+func processDeviceUsers(pid int, devNums map[string]bool, ownNamespace string) []DeviceUser {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	// Kernel threads have no command line and cannot be asked to let go.
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err != nil || len(cmdline) == 0 {
+		return nil
+	}
+	command := readTrimmedFile(filepath.Join(dir, "comm"))
+	var users []DeviceUser
+	add := func(kind, path string) {
+		users = append(users, DeviceUser{PID: pid, Command: command, Path: path, Kind: kind})
+	}
+
+	for _, kind := range []string{"cwd", "root", "exe"} {
+		if path, ok := heldLink(filepath.Join(dir, kind), devNums); ok {
+			add(kind, path)
+		}
+	}
+
+	fds, _ := os.ReadDir(filepath.Join(dir, "fd"))
+	for _, fd := range fds {
+		if path, ok := heldLink(filepath.Join(dir, "fd", fd.Name()), devNums); ok {
+			add("fd", path)
+		}
+	}
+
+	seenMaps := make(map[string]bool)
+	if file, err := os.Open(filepath.Join(dir, "maps")); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// address perms offset dev inode pathname
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 6 || fields[4] == "0" {
+				continue
+			}
+			if devNums[hexDevNumber(fields[3])] && !seenMaps[fields[5]] {
+				seenMaps[fields[5]] = true
+				add("map", fields[5])
+			}
+		}
+		file.Close()
+	}
+
+	// A mount in a private namespace (a container, a systemd service with
+	// PrivateMounts) keeps the device busy without showing up in our mountinfo.
+	if ns, err := os.Readlink(filepath.Join(dir, "ns", "mnt")); err == nil && ns != ownNamespace {
+		if file, err := os.Open(filepath.Join(dir, "mountinfo")); err == nil {
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) >= 5 && devNums[fields[2]] {
+					add("mount", unescapeMountField(fields[4]))
+				}
+			}
+			file.Close()
+		}
+	}
+	return users
+}
+
+// heldLink reports whether the /proc link refers to one of devNums, either as
+// the device node itself or as a file on a filesystem that lives on it.
+func heldLink(link string, devNums map[string]bool) (string, bool) {
+	info, err := os.Stat(link)
+	if err != nil {
+		return "", false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	dev := st.Dev
+	if info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0 {
+		dev = st.Rdev
+	}
+	if !devNums[fmt.Sprintf("%d:%d", unixMajor(dev), unixMinor(dev))] {
+		return "", false
+	}
+	path, _ := os.Readlink(link)
+	return path, true
+}
+
+// hexDevNumber converts the "fd:01" form used in maps to "253:1".
+func hexDevNumber(s string) string {
+	major, minor, ok := strings.Cut(s, ":")
+	if !ok {
+		return ""
+	}
+	ma, err1 := strconv.ParseUint(major, 16, 32)
+	mi, err2 := strconv.ParseUint(minor, 16, 32)
+	if err1 != nil || err2 != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", ma, mi)
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Optical media classes.
+const (
+	MediaRewritable = "rewritable"
+	MediaWriteOnce  = "write_once"
+	MediaReadOnly   = "read_only"
+)
+
+// opticalProfiles maps the media profile names xorriso reports (up to the
+// first space, e.g. "DVD-RW" from "DVD-RW sequential recording") to a class.
+var opticalProfiles = map[string]string{
+	"CD-RW":     MediaRewritable,
+	"DVD-RW":    MediaRewritable,
+	"DVD+RW":    MediaRewritable,
+	"DVD+RW/DL": MediaRewritable,
+	"DVD-RAM":   MediaRewritable,
+	"BD-RE":     MediaRewritable,
+	"CD-R":      MediaWriteOnce,
+	"DVD-R":     MediaWriteOnce,
+	"DVD-R/DL":  MediaWriteOnce,
+	"DVD+R":     MediaWriteOnce,
+	"DVD+R/DL":  MediaWriteOnce,
+	"BD-R":      MediaWriteOnce,
+	"CD-ROM":    MediaReadOnly,
+	"DVD-ROM":   MediaReadOnly,
+	"BD-ROM":    MediaReadOnly,
+}
+
+// OpticalMedia describes the disc in an optical drive.
+type OpticalMedia struct {
+	Present bool   `json:"present"`
+	Profile string `json:"profile,omitempty"` // e.g. "DVD+RW", "CD-R"
+	Class   string `json:"class,omitempty"`   // rewritable, write_once or read_only
+	// Status is "blank", "appendable" or "closed".
+	Status string `json:"status,omitempty"`
+	// DestroyOnly is set for pressed and write-once discs, which cannot be
+	// erased; the only way to sanitize them is to physically destroy them.
+	DestroyOnly bool   `json:"destroyOnly"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+var (
+	// opticalCache keeps the last media probe per drive. xorriso claims the
+	// drive exclusively, so it is not probed again while a blank is running.
+	opticalCache = make(map[string]*OpticalMedia)
+	opticalMutex = &sync.Mutex{}
+)
+
+var blankProgressPattern = regexp.MustCompile(`([0-9]+(?:\.[0-9]+)?)% done`)
+
+// opticalMediaInfo reports the disc in the drive at devicePath.
+func opticalMediaInfo(devicePath string) *OpticalMedia {
+	wipeMutex.Lock()
+	_, busy := activeWipes[devicePath]
+	wipeMutex.Unlock()
+
+	opticalMutex.Lock()
+	cached := opticalCache[devicePath]
+	opticalMutex.Unlock()
+	if busy && cached != nil {
+		return cached
+	}
+
+	// xorriso exits non-zero without a disc, but still describes the drive.
+	out, _ := backend.Output("xorriso", "-outdev", devicePath)
+	media := parseXorrisoMedia(string(out))
+	opticalMutex.Lock()
+	opticalCache[devicePath] = media
+	opticalMutex.Unlock()
+	return media
+}
+
+func parseXorrisoMedia(output string) *OpticalMedia {
+	media := &OpticalMedia{}
+	var current string
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Media current":
+			current = value
+		case "Media status":
+			switch {
+			case strings.Contains(value, "is blank"):
+				media.Status = "blank"
+			case strings.Contains(value, "is closed"):
+				media.Status = "closed"
+			case strings.Contains(value, "is appendable"):
+				media.Status = "appendable"
+			}
+		}
+	}
+
+	if current == "" || strings.HasPrefix(current, "is not present") || current == "none" {
+		media.Reason = "no disc in the drive"
+		return media
+	}
+	media.Present = true
+	media.Profile = strings.Fields(current)[0]
+	media.Class = opticalProfiles[media.Profile]
+	switch media.Class {
+	case MediaRewritable:
+	case MediaWriteOnce:
+		media.DestroyOnly = true
+		media.Reason = media.Profile + " is write-once and cannot be erased; destroy the disc"
+	case MediaReadOnly:
+		media.DestroyOnly = true
+		media.Reason = media.Profile + " is pressed (read-only) and cannot be erased; destroy the disc"
+	default:
+		media.Reason = fmt.Sprintf("unrecognized media %q", current)
+	}
+	return media
+}
+
+// sanitizeOptical blanks the whole of a rewritable disc with xorriso and
+// reports its progress.
+func sanitizeOptical(config WipeConfig, drive *Drive, progress chan<- string) error {
+	if drive.Optical == nil || !drive.Optical.Present {
+		return fmt.Errorf("no disc in %s", config.DevicePath)
+	}
+	if drive.Optical.Class != MediaRewritable {
+		return fmt.Errorf("%s cannot be blanked: %s", config.DevicePath, drive.Optical.Reason)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controls := &WipeControls{
+		cancel: cancel,
+		pause:  make(chan bool), // Blanking cannot be paused
+	}
+	wipeMutex.Lock()
+	activeWipes[config.DevicePath] = controls
+	wipeMutex.Unlock()
+
+	defer func() {
+		wipeMutex.Lock()
+		delete(activeWipes, config.DevicePath)
+		wipeMutex.Unlock()
+	}()
+
+	// xorriso claims the drive itself, so only the identity is checked here.
+	if err := verifyDeviceIdentity(config.DevicePath, config.Identity); err != nil {
+		return err
+	}
+
+	progress <- fmt.Sprintf("Blanking %s (%s)...", config.DevicePath, drive.Optical.Profile)
+
+	start := time.Now()
+	var tail []string
+	err := backend.StreamOutput(ctx, func(line string) {
+		if len(tail) == 5 {
+			tail = tail[1:]
+		}
+		tail = append(tail, line)
+
+		m := blankProgressPattern.FindStringSubmatch(line)
+		if m == nil {
+			return
+		}
+		percent, _ := strconv.ParseFloat(m[1], 64)
+		update := WipeProgress{
+			DeviceID:    config.DevicePath,
+			DeviceModel: config.DeviceModel,
+			Method:      config.Method,
+			MethodName:  getWipeMethodName(config.Method),
+			Status:      "Blanking",
+			Progress:    percent,
+			CurrentPass: 1,
+			TotalPasses: 1,
+		}
+		if elapsed := time.Since(start).Seconds(); percent > 0 {
+			update.ETA = fmt.Sprintf("%.0fs", elapsed*(100-percent)/percent)
+		}
+		msg, _ := json.Marshal(update)
+		progress <- string(msg)
+	}, "xorriso", "-outdev", config.DevicePath, "-blank", "all")
+	if err != nil {
+		return fmt.Errorf("blanking failed: %w. Output: %s", err, strings.Join(tail, "\n"))
+	}
+
+	completion := WipeProgress{
+		DeviceID: config.DevicePath,
+		Status:   "done",
+		Progress: 100,
+	}
+	jsonMsg, _ := json.Marshal(completion)
+	progress <- string(jsonMsg)
+	return nil
+}
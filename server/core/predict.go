@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 
@@ -54,8 +53,7 @@ type PredictionResult struct {
 }
 
 func PredictDriveHealth(devicePath string) (*PredictionResult, error) {
-	cmd := exec.Command("smartctl", "-a", "-j", devicePath)
-	out, err := cmd.Output()
+	out, err := backend.Output("smartctl", "-a", "-j", devicePath)
 	if err != nil {
 		// If smartctl fails, it could be a USB drive or a device that doesn't support S.M.A.R.T.
 		return &PredictionResult{
@@ -0,0 +1,443 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// confirmationTTL is how long a preflight confirmation token stays valid.
+const confirmationTTL = 5 * time.Minute
+
+// Rough sustained write speeds used for the duration estimate, in MB/s.
+var assumedWriteSpeed = map[DriveType]float64{
+	HDD:     150,
+	SSD:     400,
+	NVME:    1500,
+	USB:     30,
+	SAS:     200,
+	VIRTIO:  500,
+	XEN:     300,
+	LOOP:    500,
+	MMC:     20,
+	DM:      200,
+	OPTICAL: 5,
+	ZONED:   150,
+	UNKN:    50,
+}
+
+// methodTools lists the external programs each method shells out to.
+var methodTools = map[string][]string{
+	"nvme_format":             {"nvme"},
+	"sata_secure_erase":       {"hdparm"},
+	"android_factory_reset":   {"adb"},
+	"optical_blank_full":      {"xorriso"},
+	"zoned_overwrite":         {"blkzone"},
+	"fastboot_erase_userdata": {"fastboot"},
+	"fastboot_wipe":           {"fastboot"},
+}
+
+var (
+	// confirmationKey signs preflight tokens. It lives only in memory, so a
+	// backend restart invalidates every outstanding token.
+	confirmationKey  = make([]byte, 32)
+	usedTokens       = make(map[string]time.Time)
+	usedTokensMutex  = &sync.Mutex{}
+	errTokenRequired = fmt.Errorf("a confirmation token from /api/wipe/preflight is required")
+)
+
+func init() {
+	if _, err := rand.Read(confirmationKey); err != nil {
+		log.Fatalf("FATAL: Could not generate the confirmation token key: %v", err)
+	}
+}
+
+type PreflightCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type PreflightResult struct {
+	DevicePath        string           `json:"devicePath"`
+	Method            string           `json:"method"`
+	MethodName        string           `json:"methodName"`
+	OK                bool             `json:"ok"`
+	Checks            []PreflightCheck `json:"checks"`
+	EstimatedSeconds  float64          `json:"estimatedSeconds"`
+	ConfirmationToken string           `json:"confirmationToken,omitempty"`
+	ExpiresAt         time.Time        `json:"expiresAt,omitempty"`
+}
+
+func (r *PreflightResult) check(name string, passed bool, detail string) {
+	r.Checks = append(r.Checks, PreflightCheck{Name: name, Passed: passed, Detail: detail})
+}
+
+// Preflight runs every check SanitizeDevice would run, without writing to the
+// device. If all checks pass it returns a short-lived token bound to config that
+// must be presented to start the wipe.
+func Preflight(config WipeConfig) (*PreflightResult, error) {
+	result := &PreflightResult{
+		DevicePath: config.DevicePath,
+		Method:     config.Method,
+		MethodName: getWipeMethodName(config.Method),
+	}
+
+	if config.DeviceType == "Android" {
+		preflightAndroid(config, result)
+	} else if isFileTarget(config.DevicePath) {
+		preflightFile(config, result)
+	} else if err := preflightStorage(config, result); err != nil {
+		return nil, err
+	}
+
+	result.OK = true
+	for _, c := range result.Checks {
+		if !c.Passed {
+			result.OK = false
+		}
+	}
+	if result.OK {
+		result.ExpiresAt = time.Now().Add(confirmationTTL).UTC()
+		result.ConfirmationToken = signConfirmation(config, result.ExpiresAt)
+	}
+	return result, nil
+}
+
+func preflightStorage(config WipeConfig, result *PreflightResult) error {
+	drives, err := detectStorageDrives()
+	if err != nil {
+		return fmt.Errorf("could not detect drives: %w", err)
+	}
+
+	var drive *Drive
+	for i := range drives {
+		if drives[i].Name == config.DevicePath {
+			drive = &drives[i]
+			break
+		}
+	}
+	if drive == nil {
+		result.check("device", false, fmt.Sprintf("drive %s not found", config.DevicePath))
+		return nil
+	}
+	result.check("device", true, fmt.Sprintf("%s (%s)", drive.Model, drive.Type))
+
+	switch {
+	case config.Identity.IsZero():
+		result.check("identity", false, "request does not pin a device identity")
+	case drive.Identity != config.Identity:
+		result.check("identity", false, fmt.Sprintf("expected %s, found %s", config.Identity, drive.Identity))
+	default:
+		result.check("identity", true, drive.Identity.String())
+	}
+
+	if drive.IsMounted {
+		result.check("mount", false, "drive or one of its partitions is mounted")
+	} else {
+		result.check("mount", true, "not mounted")
+	}
+
+	if err := CheckSystemDisk(config.DevicePath); err != nil {
+		if config.AllowSystemDisk {
+			result.check("system disk", true, "override requested: "+err.Error())
+		} else {
+			result.check("system disk", false, err.Error())
+		}
+	} else {
+		result.check("system disk", true, "not a system disk")
+	}
+
+	if drive.Type == SSD && drive.IsFrozen {
+		result.check("frozen", false, "drive is in a frozen state; suspend/resume or replug it to unfreeze")
+	} else {
+		result.check("frozen", true, "not frozen")
+	}
+
+	if drive.Type == OPTICAL {
+		switch media := drive.Optical; {
+		case media == nil || !media.Present:
+			result.check("media", false, "no disc in the drive")
+		case media.Class != MediaRewritable:
+			result.check("media", false, media.Reason)
+		case media.Status != "":
+			result.check("media", true, fmt.Sprintf("%s, %s", media.Profile, media.Status))
+		default:
+			result.check("media", true, media.Profile)
+		}
+	}
+
+	if strings.HasPrefix(config.Method, "luks_") {
+		if err := checkLUKSClosed(drive.LUKS); err != nil {
+			result.check("luks", false, err.Error())
+		} else {
+			result.check("luks", true, fmt.Sprintf("%d closed LUKS volume(s)", len(drive.LUKS)))
+		}
+		if config.Method == "luks_crypto_erase" {
+			if err := checkLUKSCoverage(drive); err != nil {
+				result.check("luks coverage", false, err.Error())
+			} else {
+				result.check("luks coverage", true, "every partition is LUKS-encrypted")
+			}
+		}
+	}
+
+	preflightMethod(config.Method, GetWipeMethodsForDrive(*drive), result)
+	preflightTools(config.Method, result)
+	if config.Reprovision != nil {
+		preflightReprovision(*config.Reprovision, result)
+	}
+
+	size, _ := strconv.ParseInt(drive.Size, 10, 64)
+	result.EstimatedSeconds = estimateWipeSeconds(config.Method, drive.Type, size)
+	return nil
+}
+
+func preflightFile(config WipeConfig, result *PreflightResult) {
+	target, err := InspectFileTarget(config.DevicePath)
+	if err != nil {
+		result.check("device", false, err.Error())
+		return
+	}
+	result.check("device", true, fmt.Sprintf("%s file", target.Format))
+
+	switch {
+	case config.Identity.IsZero():
+		result.check("identity", false, "request does not pin a file identity")
+	case target.Identity != config.Identity:
+		result.check("identity", false, fmt.Sprintf("expected %s, found %s", config.Identity, target.Identity))
+	default:
+		result.check("identity", true, target.Identity.String())
+	}
+
+	if err := checkFileDisk(config); err != nil {
+		result.check("system disk", false, err.Error())
+	} else {
+		result.check("system disk", true, "not stored on a system disk")
+	}
+
+	if err := checkFileTarget(config.DevicePath); err != nil {
+		result.check("in use", false, err.Error())
+	} else {
+		result.check("in use", true, "not swap or loop-attached")
+	}
+
+	if target.Format == "qcow2" && config.Method == "qcow2_cluster_wipe" {
+		if img, err := openQcow2(config.DevicePath); err == nil {
+			if err := img.checkWipeable(); err != nil {
+				result.check("qcow2 layout", false, err.Error())
+			} else {
+				result.check("qcow2 layout", true, fmt.Sprintf("%d bytes of allocated guest data", target.AllocatedBytes))
+			}
+			img.file.Close()
+		}
+	}
+
+	preflightMethod(config.Method, target.Methods, result)
+	if config.Reprovision != nil {
+		result.check("reprovision", false, "reprovisioning is only supported for block devices")
+	}
+
+	size := target.Size
+	if config.Method == "qcow2_cluster_wipe" {
+		size = target.AllocatedBytes
+	}
+	result.EstimatedSeconds = estimateWipeSeconds(config.Method, UNKN, size)
+}
+
+func preflightAndroid(config WipeConfig, result *PreflightResult) {
+	devices, err := detectAndroidDevices()
+	found := false
+	for _, d := range devices {
+		if d.Serial == config.DeviceSerial {
+			found = true
+			result.check("device", true, d.Model)
+			if d.State == MobileStateFastboot {
+				preflightFastboot(config, d, result)
+				break
+			}
+			if isFastbootMethod(config.Method) {
+				result.check("fastboot", false, "the device is booted; reboot it into the bootloader (adb reboot bootloader) first")
+			}
+			if err := attestAndroid(&d); err != nil {
+				result.check("attestation", false, err.Error())
+			}
+			if err := checkAndroidEncrypted(d.Attestation); err != nil {
+				result.check("encryption", false, err.Error())
+			} else {
+				result.check("encryption", true, d.Attestation.CryptoState+" ("+d.Attestation.CryptoType+")")
+			}
+			if a := d.Attestation; a != nil && a.FRP == "active" {
+				result.check("frp", true, fmt.Sprintf("%d Google account(s) signed in; the phone will ask for one after the reset", a.GoogleAccounts))
+			}
+			preflightMethod(config.Method, GetWipeMethodsForMobile(d), result)
+			if paths, err := findAndroidResetPaths(d.Serial); err != nil {
+				result.check("reset path", false, err.Error())
+			} else {
+				result.check("reset path", true, describeResetPaths(paths))
+			}
+			break
+		}
+	}
+	if !found {
+		detail := fmt.Sprintf("android device %s not connected", config.DeviceSerial)
+		if err != nil {
+			detail += ": " + err.Error()
+		}
+		result.check("device", false, detail)
+	}
+	preflightTools(config.Method, result)
+	if config.Reprovision != nil {
+		result.check("reprovision", false, "reprovisioning is not supported for Android devices")
+	}
+}
+
+func preflightFastboot(config WipeConfig, d MobileDevice, result *PreflightResult) {
+	if err := checkFastbootUnlocked(d.Fastboot); err != nil {
+		result.check("bootloader", false, err.Error())
+	} else {
+		result.check("bootloader", true, "unlocked; partitions: "+strings.Join(d.Fastboot.Partitions, ", "))
+	}
+	preflightMethod(config.Method, GetWipeMethodsForMobile(d), result)
+}
+
+func preflightMethod(method string, available []WipeMethod, result *PreflightResult) {
+	var ids []string
+	for _, m := range available {
+		if m.ID == method {
+			result.check("method", true, m.Name)
+			return
+		}
+		ids = append(ids, m.ID)
+	}
+	result.check("method", false, fmt.Sprintf("%s is not supported here; available: %s", method, strings.Join(ids, ", ")))
+}
+
+func preflightTools(method string, result *PreflightResult) {
+	for _, tool := range methodTools[method] {
+		if path, err := backend.LookPath(tool); err != nil {
+			result.check("tool "+tool, false, tool+" is not installed")
+		} else {
+			result.check("tool "+tool, true, path)
+		}
+	}
+}
+
+func preflightReprovision(rc ReprovisionConfig, result *PreflightResult) {
+	if err := rc.Validate(); err != nil {
+		result.check("reprovision", false, err.Error())
+		return
+	}
+	result.check("reprovision", true, fmt.Sprintf("%s partition table with one %s partition", rc.PartitionTable, rc.Filesystem))
+	for _, tool := range rc.tools() {
+		if path, err := backend.LookPath(tool); err != nil {
+			result.check("tool "+tool, false, tool+" is not installed")
+		} else {
+			result.check("tool "+tool, true, path)
+		}
+	}
+}
+
+// estimateWipeSeconds gives a rough duration. Firmware methods are bounded by
+// the drive itself and usually finish well under the overwrite estimate.
+func estimateWipeSeconds(method string, driveType DriveType, size int64) float64 {
+	speed := assumedWriteSpeed[driveType]
+	if speed == 0 {
+		speed = assumedWriteSpeed[UNKN]
+	}
+	pass := float64(size) / (speed * 1024 * 1024)
+
+	switch method {
+	case "nvme_format":
+		return 60
+	case "sata_secure_erase":
+		return pass
+	case "overwrite_1_pass", "qcow2_cluster_wipe", "luks_erase_overwrite", "zoned_overwrite":
+		return pass
+	case "luks_crypto_erase":
+		return 5
+	case "android_factory_reset":
+		return 300
+	case "fastboot_erase_userdata", "fastboot_wipe":
+		return 60
+	case "optical_blank_full":
+		return pass
+	case "overwrite_2_pass":
+		return 2 * pass
+	case "overwrite_3_pass":
+		return 3 * pass
+	default:
+		return 0
+	}
+}
+
+// confirmationPayload encodes every field a token is bound to with its
+// length, so no characters can move from one field to the next.
+func confirmationPayload(config WipeConfig, expiresAt time.Time) string {
+	var payload strings.Builder
+	for _, field := range []string{
+		config.DevicePath,
+		config.DeviceSerial,
+		config.DeviceType,
+		config.Method,
+		config.Identity.String(),
+		strconv.FormatBool(config.AllowSystemDisk),
+		config.Reprovision.String(),
+		strconv.FormatInt(expiresAt.Unix(), 10),
+	} {
+		fmt.Fprintf(&payload, "%d:%s;", len(field), field)
+	}
+	return payload.String()
+}
+
+func signConfirmation(config WipeConfig, expiresAt time.Time) string {
+	mac := hmac.New(sha256.New, confirmationKey)
+	mac.Write([]byte(confirmationPayload(config, expiresAt)))
+	return strconv.FormatInt(expiresAt.Unix(), 10) + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ConsumeConfirmationToken checks that config.ConfirmationToken was issued by
+// Preflight for exactly this request and has not expired or been used before.
+func ConsumeConfirmationToken(config WipeConfig) error {
+	token := config.ConfirmationToken
+	if token == "" {
+		return errTokenRequired
+	}
+
+	expiry, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return fmt.Errorf("malformed confirmation token")
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed confirmation token")
+	}
+	expiresAt := time.Unix(unix, 0)
+
+	if !hmac.Equal([]byte(token), []byte(signConfirmation(config, expiresAt))) {
+		return fmt.Errorf("confirmation token does not match this wipe request")
+	}
+	if time.Now().After(expiresAt) {
+		return fmt.Errorf("confirmation token expired at %s; run preflight again", expiresAt.UTC().Format(time.RFC3339))
+	}
+
+	usedTokensMutex.Lock()
+	defer usedTokensMutex.Unlock()
+	for t, exp := range usedTokens {
+		if time.Now().After(exp) {
+			delete(usedTokens, t)
+		}
+	}
+	if _, used := usedTokens[token]; used {
+		return fmt.Errorf("confirmation token has already been used")
+	}
+	usedTokens[token] = expiresAt
+	return nil
+}
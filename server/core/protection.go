@@ -0,0 +1,342 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// protectedMountpoints are the mounts whose backing disks are treated as the
+// running system. Wiping any disk underneath them would take the host down.
+var protectedMountpoints = []string{"/", "/boot", "/boot/efi", "/home"}
+
+var (
+	sysfsRoot = "/sys"
+	procRoot  = "/proc"
+)
+
+// SystemDiskOverrideHelp documents how to deliberately wipe a protected disk.
+const SystemDiskOverrideHelp = `set "allowSystemDisk": true in the wipe request to override`
+
+// systemDiskSet holds the disks that back the running system.
+type systemDiskSet struct {
+	// disks maps whole-disk names (e.g. "sda", "nvme0n1") to the reasons
+	// they are considered part of the running system.
+	disks map[string][]string
+	// unresolved lists protected mounts and swaps that could not be traced
+	// to a disk. Any of those could be on any disk, so while the list is not
+	// empty every disk is a system disk.
+	unresolved []string
+}
+
+// reasons returns why the disk name is a system disk, or nil if it is not.
+func (s systemDiskSet) reasons(name string) []string {
+	reasons := s.disks[name]
+	for _, u := range s.unresolved {
+		reasons = appendReason(reasons, u)
+	}
+	return reasons
+}
+
+// disklessFilesystems are filesystem types that live in memory or on the
+// network, so a protected mount of one has no disk to protect.
+var disklessFilesystems = map[string]bool{
+	"tmpfs": true, "ramfs": true, "rootfs": true, "overlay": true, "aufs": true,
+	"nfs": true, "nfs4": true, "cifs": true, "smb3": true, "9p": true, "virtiofs": true,
+}
+
+// systemDisks finds the disks behind the protected mounts and active swap.
+// Mounts and swap are resolved down through partitions, LVM, LUKS and mdraid
+// using the holders/slaves links in sysfs, and ZFS datasets through the
+// members of their pool. It fails closed: a protected mount or swap that
+// cannot be traced to a disk makes every disk a system disk.
+func systemDisks() (systemDiskSet, error) {
+	set := systemDiskSet{disks: make(map[string][]string)}
+
+	mounts, err := readMountinfo()
+	if err != nil {
+		return set, err
+	}
+	for _, protected := range protectedMountpoints {
+		for _, m := range mounts {
+			if m.mountpoint != protected {
+				continue
+			}
+			names, err := m.blockNames()
+			if err == nil && len(names) == 0 && (disklessFilesystems[m.fstype] || strings.HasPrefix(m.fstype, "fuse.")) {
+				continue
+			}
+			if len(names) == 0 {
+				set.unresolved = appendReason(set.unresolved, fmt.Sprintf("%s (%s on %s) could not be traced to a disk, so every disk is protected", protected, m.fstype, m.source))
+				continue
+			}
+			for _, name := range names {
+				for _, disk := range resolveBackingDisks(name) {
+					reason := fmt.Sprintf("backs %s (via %s)", protected, name)
+					set.disks[disk] = appendReason(set.disks[disk], reason)
+				}
+			}
+		}
+	}
+
+	swaps, err := activeSwapDevices()
+	if err != nil {
+		return set, err
+	}
+	for _, swap := range swaps {
+		name, err := blockNameForPath(swap)
+		if err != nil {
+			set.unresolved = appendReason(set.unresolved, fmt.Sprintf("active swap %s could not be traced to a disk, so every disk is protected", swap))
+			continue
+		}
+		for _, disk := range resolveBackingDisks(name) {
+			reason := fmt.Sprintf("backs active swap %s", swap)
+			set.disks[disk] = appendReason(set.disks[disk], reason)
+		}
+	}
+
+	return set, nil
+}
+
+// CheckSystemDisk returns an error naming every reason devicePath is a system disk.
+func CheckSystemDisk(devicePath string) error {
+	set, err := systemDisks()
+	if err != nil {
+		return fmt.Errorf("could not determine system disks: %w", err)
+	}
+	reasons := set.reasons(filepath.Base(devicePath))
+	if len(reasons) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s is a system disk: %s; %s", devicePath, strings.Join(reasons, ", "), SystemDiskOverrideHelp)
+}
+
+func appendReason(reasons []string, reason string) []string {
+	for _, r := range reasons {
+		if r == reason {
+			return reasons
+		}
+	}
+	return append(reasons, reason)
+}
+
+type mountEntry struct {
+	majorMinor string
+	mountpoint string
+	fstype     string
+	source     string
+}
+
+// blockName returns the kernel block device name behind a mount. Filesystems
+// like btrfs report an anonymous 0:N device, so fall back to the mount source.
+func (m mountEntry) blockName() (string, error) {
+	if !strings.HasPrefix(m.majorMinor, "0:") {
+		return blockNameForDevNumber(m.majorMinor)
+	}
+	if strings.HasPrefix(m.source, "/dev/") {
+		return blockNameForPath(m.source)
+	}
+	return "", nil
+}
+
+// blockNames is blockName, extended to ZFS datasets, which are on every member
+// of their pool. It returns nothing for a mount without a block device.
+func (m mountEntry) blockNames() ([]string, error) {
+	name, err := m.blockName()
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		return []string{name}, nil
+	}
+	if m.fstype == "zfs" {
+		pool, _, _ := strings.Cut(m.source, "/")
+		return zpoolMembers(pool)
+	}
+	return nil, nil
+}
+
+// zpoolMembers returns the block devices labelled as members of pool.
+func zpoolMembers(pool string) ([]string, error) {
+	out, err := backend.Output("lsblk", "-J", "-o", "NAME,FSTYPE,LABEL")
+	if err != nil {
+		return nil, fmt.Errorf("lsblk command failed: %w", err)
+	}
+	type lsblkNode struct {
+		Name     string      `json:"name"`
+		FSType   string      `json:"fstype"`
+		Label    string      `json:"label"`
+		Children []lsblkNode `json:"children"`
+	}
+	var data struct {
+		BlockDevices []lsblkNode `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse lsblk JSON: %w", err)
+	}
+	var members []string
+	var walk func([]lsblkNode)
+	walk = func(nodes []lsblkNode) {
+		for _, n := range nodes {
+			if n.FSType == "zfs_member" && n.Label == pool {
+				members = append(members, n.Name)
+			}
+			walk(n.Children)
+		}
+	}
+	walk(data.BlockDevices)
+	return members, nil
+}
+
+func readMountinfo() ([]mountEntry, error) {
+	file, err := os.Open(filepath.Join(procRoot, "self", "mountinfo"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read mountinfo: %w", err)
+	}
+	defer file.Close()
+
+	var mounts []mountEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// id parent major:minor root mountpoint options... - fstype source superopts
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		entry := mountEntry{
+			majorMinor: fields[2],
+			mountpoint: unescapeMountField(fields[4]),
+		}
+		for i, f := range fields {
+			if f == "-" && i+2 < len(fields) {
+				entry.fstype = fields[i+1]
+				entry.source = unescapeMountField(fields[i+2])
+				break
+			}
+		}
+		mounts = append(mounts, entry)
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountField decodes the octal escapes (\040 etc.) used in mountinfo.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var c byte
+			if _, err := fmt.Sscanf(s[i+1:i+4], "%03o", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func activeSwapDevices() ([]string, error) {
+	file, err := os.Open(filepath.Join(procRoot, "swaps"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read swaps: %w", err)
+	}
+	defer file.Close()
+
+	var swaps []string
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Skip the "Filename Type Size Used Priority" header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			swaps = append(swaps, unescapeMountField(fields[0]))
+		}
+	}
+	return swaps, scanner.Err()
+}
+
+// blockNameForDevNumber resolves "8:2" to "sda2" via /sys/dev/block.
+func blockNameForDevNumber(majorMinor string) (string, error) {
+	target, err := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "dev", "block", majorMinor))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+// blockNameForPath resolves a device node (or a swap file) to its block name.
+func blockNameForPath(path string) (string, error) {
+	if strings.HasPrefix(path, "/dev/") {
+		if _, err := os.Stat(filepath.Join(sysfsRoot, "class", "block", filepath.Base(path))); err == nil {
+			return filepath.Base(path), nil
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("unsupported stat for %s", path)
+	}
+	dev := st.Rdev
+	if info.Mode()&os.ModeDevice == 0 {
+		// A swap file lives on the filesystem of the device that holds it.
+		dev = st.Dev
+	}
+	return blockNameForDevNumber(fmt.Sprintf("%d:%d", unixMajor(dev), unixMinor(dev)))
+}
+
+func unixMajor(dev uint64) uint64 {
+	return ((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000)
+}
+
+func unixMinor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) & 0xffffff00)
+}
+
+// resolveBackingDisks walks from a block device down through its slaves
+// (dm, md) and partition parents until it reaches whole disks.
+func resolveBackingDisks(name string) []string {
+	seen := make(map[string]bool)
+	var disks []string
+
+	var walk func(string)
+	walk = func(n string) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+
+		blockDir := filepath.Join(sysfsRoot, "class", "block", n)
+		if _, err := os.Stat(filepath.Join(blockDir, "partition")); err == nil {
+			if target, err := filepath.EvalSymlinks(blockDir); err == nil {
+				walk(filepath.Base(filepath.Dir(target)))
+			}
+			return
+		}
+
+		slaves, _ := os.ReadDir(filepath.Join(blockDir, "slaves"))
+		if len(slaves) == 0 {
+			disks = append(disks, n)
+			return
+		}
+		for _, slave := range slaves {
+			walk(slave.Name())
+		}
+	}
+	walk(name)
+
+	return disks
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useMountinfo replaces the simulated mountinfo and swaps.
+func useMountinfo(t *testing.T, mountinfo, swaps string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(procRoot, "self", "mountinfo"), []byte(mountinfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	swaps = "Filename\tType\tSize\tUsed\tPriority\n" + swaps
+	if err := os.WriteFile(filepath.Join(procRoot, "swaps"), []byte(swaps), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSystemDisks(t *testing.T) {
+	tests := []struct {
+		name      string
+		mountinfo string
+		swaps     string
+		protected []string
+		allDisks  bool
+	}{
+		{
+			name:      "zfs root",
+			mountinfo: "100 1 0:42 / / rw,relatime - zfs rpool/ROOT/ubuntu rw,xattr\n",
+			protected: []string{"sda", "sdb"},
+		},
+		{
+			name:      "zfs root of an unknown pool",
+			mountinfo: "100 1 0:42 / / rw,relatime - zfs bpool/BOOT/ubuntu rw,xattr\n",
+			allDisks:  true,
+		},
+		{
+			name:      "root source outside /dev",
+			mountinfo: "100 1 0:43 /@ / rw,relatime - btrfs UUID=0f6c2b4e rw\n",
+			allDisks:  true,
+		},
+		{
+			name:      "unresolvable /boot",
+			mountinfo: "100 1 0:42 / / rw,relatime - zfs rpool/ROOT/ubuntu rw\n101 100 259:9 / /boot rw - ext4 /dev/nvme9n1p2 rw\n",
+			allDisks:  true,
+		},
+		{
+			name:      "unresolvable swap",
+			mountinfo: "100 1 0:42 / / rw,relatime - zfs rpool/ROOT/ubuntu rw\n",
+			swaps:     "/dev/zd16\tpartition\t1024\t0\t-2\n",
+			allDisks:  true,
+		},
+		{
+			name:      "live system in memory",
+			mountinfo: "100 1 0:30 / / rw,relatime - overlay overlay rw,lowerdir=/run/live/rootfs\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSimBackend(t, "protection")
+			useMountinfo(t, tt.mountinfo, tt.swaps)
+
+			set, err := systemDisks()
+			if err != nil {
+				t.Fatalf("systemDisks: %v", err)
+			}
+			for _, disk := range []string{"sda", "sdb", "sdc"} {
+				want := tt.allDisks
+				for _, p := range tt.protected {
+					want = want || p == disk
+				}
+				if got := len(set.reasons(disk)) > 0; got != want {
+					t.Errorf("%s protected = %v (%v), want %v", disk, got, set.reasons(disk), want)
+				}
+				err := CheckSystemDisk("/dev/" + disk)
+				if (err != nil) != want {
+					t.Errorf("CheckSystemDisk(/dev/%s) = %v, want protected %v", disk, err, want)
+				}
+				if err != nil && tt.allDisks && !strings.Contains(err.Error(), "every disk is protected") {
+					t.Errorf("CheckSystemDisk(/dev/%s) = %v, want it to say every disk is protected", disk, err)
+				}
+			}
+		})
+	}
+}
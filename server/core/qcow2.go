@@ -0,0 +1,260 @@
+package core
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+)
+
+const (
+	qcow2Magic = 0x514649fb // "QFI\xfb"
+
+	qcow2OffsetMask     = 0x00fffffffffffe00
+	qcow2CompressedFlag = 1 << 62
+
+	qcow2IncompatDirty        = 1 << 0
+	qcow2IncompatCorrupt      = 1 << 1
+	qcow2IncompatExternalData = 1 << 2
+	qcow2IncompatExtendedL2   = 1 << 4
+
+	fallocKeepSize  = 0x01
+	fallocPunchHole = 0x02
+)
+
+var errNotQcow2 = errors.New("not a qcow2 image")
+
+type qcow2Image struct {
+	file          *os.File
+	clusterBits   uint32
+	clusterSize   int64
+	virtualSize   uint64
+	l1Size        uint32
+	l1TableOffset int64
+	nbSnapshots   uint32
+	incompatible  uint64
+	// backingFile is the image the guest reads unallocated clusters from.
+	backingFile string
+}
+
+// qcow2DataCluster is one guest data cluster: where its L2 entry lives and
+// which host bytes hold its data.
+type qcow2DataCluster struct {
+	l2EntryOffset int64
+	hostOffset    int64
+	length        int64
+}
+
+// openQcow2 parses the image header. It returns errNotQcow2 for other files.
+func openQcow2(path string) (*qcow2Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := readQcow2Header(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return img, nil
+}
+
+func readQcow2Header(file *os.File) (*qcow2Image, error) {
+	header := make([]byte, 104)
+	n, _ := file.ReadAt(header, 0)
+	if n < 72 || binary.BigEndian.Uint32(header[0:4]) != qcow2Magic {
+		return nil, errNotQcow2
+	}
+	if n < len(header) && binary.BigEndian.Uint32(header[4:8]) >= 3 {
+		return nil, fmt.Errorf("truncated qcow2 header")
+	}
+
+	img := &qcow2Image{
+		file:          file,
+		clusterBits:   binary.BigEndian.Uint32(header[20:24]),
+		virtualSize:   binary.BigEndian.Uint64(header[24:32]),
+		l1Size:        binary.BigEndian.Uint32(header[36:40]),
+		l1TableOffset: int64(binary.BigEndian.Uint64(header[40:48])),
+		nbSnapshots:   binary.BigEndian.Uint32(header[60:64]),
+	}
+	if binary.BigEndian.Uint32(header[4:8]) >= 3 {
+		img.incompatible = binary.BigEndian.Uint64(header[72:80])
+	}
+	if img.clusterBits < 9 || img.clusterBits > 21 {
+		return nil, fmt.Errorf("invalid qcow2 cluster size 2^%d", img.clusterBits)
+	}
+	img.clusterSize = 1 << img.clusterBits
+	if offset := int64(binary.BigEndian.Uint64(header[8:16])); offset != 0 {
+		name := make([]byte, min(binary.BigEndian.Uint32(header[16:20]), 1023))
+		if _, err := file.ReadAt(name, offset); err != nil {
+			return nil, fmt.Errorf("failed to read the qcow2 backing file name: %w", err)
+		}
+		img.backingFile = string(name)
+		if img.backingFile == "" {
+			img.backingFile = "unnamed"
+		}
+	}
+	return img, nil
+}
+
+// checkWipeable refuses images whose layout the cluster wipe can't fully cover.
+func (img *qcow2Image) checkWipeable() error {
+	switch {
+	case img.incompatible&qcow2IncompatDirty != 0:
+		return fmt.Errorf("qcow2 image is dirty (in use or not shut down cleanly); run qemu-img check -r all first")
+	case img.incompatible&qcow2IncompatCorrupt != 0:
+		return fmt.Errorf("qcow2 image is marked corrupt; wipe it as a raw file instead")
+	case img.incompatible&qcow2IncompatExternalData != 0:
+		return fmt.Errorf("qcow2 image stores its data in an external file; wipe that file instead")
+	case img.incompatible&qcow2IncompatExtendedL2 != 0:
+		return fmt.Errorf("qcow2 images with extended L2 entries are not supported; wipe it as a raw file instead")
+	case img.backingFile != "":
+		// Discarded clusters fall through to the backing file, so the guest
+		// would still read data after the wipe.
+		return fmt.Errorf("qcow2 image has a backing file (%s) whose data would show through the wiped clusters; wipe the backing chain as well, or flatten the image with qemu-img convert first", img.backingFile)
+	case img.nbSnapshots > 0:
+		return fmt.Errorf("qcow2 image has %d internal snapshots whose clusters would survive; delete them or wipe it as a raw file", img.nbSnapshots)
+	}
+	return nil
+}
+
+// dataClusters walks the L1 and L2 tables and returns every allocated guest
+// data cluster, including preallocated zero clusters that may hold old data.
+func (img *qcow2Image) dataClusters() ([]qcow2DataCluster, error) {
+	l1 := make([]byte, int64(img.l1Size)*8)
+	if _, err := img.file.ReadAt(l1, img.l1TableOffset); err != nil {
+		return nil, fmt.Errorf("failed to read qcow2 L1 table: %w", err)
+	}
+
+	l2Entries := img.clusterSize / 8
+	l2 := make([]byte, img.clusterSize)
+	var clusters []qcow2DataCluster
+
+	for i := uint32(0); i < img.l1Size; i++ {
+		l2Offset := int64(binary.BigEndian.Uint64(l1[i*8:]) & qcow2OffsetMask)
+		if l2Offset == 0 {
+			continue
+		}
+		if _, err := img.file.ReadAt(l2, l2Offset); err != nil {
+			return nil, fmt.Errorf("failed to read qcow2 L2 table at %d: %w", l2Offset, err)
+		}
+
+		for j := int64(0); j < l2Entries; j++ {
+			entry := binary.BigEndian.Uint64(l2[j*8:])
+			cluster := qcow2DataCluster{l2EntryOffset: l2Offset + j*8}
+
+			if entry&qcow2CompressedFlag != 0 {
+				// Compressed descriptor: x offset bits, then a sector count.
+				x := 62 - (img.clusterBits - 8)
+				offset := int64(entry & (1<<x - 1))
+				sectors := int64((entry>>x)&(1<<(img.clusterBits-8)-1)) + 1
+				cluster.hostOffset = offset
+				cluster.length = sectors*512 - offset%512
+			} else {
+				cluster.hostOffset = int64(entry & qcow2OffsetMask)
+				cluster.length = img.clusterSize
+			}
+			if cluster.hostOffset == 0 {
+				continue
+			}
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters, nil
+}
+
+// clusterExtents merges the host ranges of clusters into sorted extents.
+func clusterExtents(clusters []qcow2DataCluster) []extent {
+	var extents []extent
+	for _, c := range clusters {
+		extents = append(extents, extent{offset: c.hostOffset, length: c.length})
+	}
+	return mergeExtents(extents)
+}
+
+// sanitizeQcow2 overwrites every allocated guest data cluster through the
+// shared overwrite engine, then discards them: the L2 entries are cleared and
+// the host ranges are punched out of the file, leaving a valid empty image.
+func sanitizeQcow2(config WipeConfig, progress chan<- string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controls := &WipeControls{
+		cancel: cancel,
+		pause:  make(chan bool),
+	}
+	wipeMutex.Lock()
+	activeWipes[config.DevicePath] = controls
+	wipeMutex.Unlock()
+
+	defer func() {
+		wipeMutex.Lock()
+		delete(activeWipes, config.DevicePath)
+		wipeMutex.Unlock()
+	}()
+
+	file, err := openPinnedDevice(config, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	img, err := readQcow2Header(file)
+	if err != nil {
+		return err
+	}
+	if err := img.checkWipeable(); err != nil {
+		return err
+	}
+	clusters, err := img.dataClusters()
+	if err != nil {
+		return err
+	}
+	extents := clusterExtents(clusters)
+	progress <- fmt.Sprintf("Wiping %d allocated qcow2 clusters (%d ranges)...", len(clusters), len(extents))
+
+	if err := overwriteExtents(ctx, controls, config, file, extents, 0x00, 1, 1, progress); err != nil {
+		return err
+	}
+
+	progress <- "Discarding wiped clusters..."
+	zero := make([]byte, 8)
+	for _, c := range clusters {
+		if _, err := file.WriteAt(zero, c.l2EntryOffset); err != nil {
+			return fmt.Errorf("failed to clear qcow2 L2 entry at %d: %w", c.l2EntryOffset, err)
+		}
+	}
+	for _, e := range extents {
+		if err := syscall.Fallocate(int(file.Fd()), fallocPunchHole|fallocKeepSize, e.offset, e.length); err != nil {
+			// The data is already overwritten; a filesystem without hole
+			// punching just keeps the zeroed clusters allocated.
+			log.Printf("Warning: could not punch hole at %d in %s: %v", e.offset, config.DevicePath, err)
+			break
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", config.DevicePath, err)
+	}
+
+	// The refcount table still counts the discarded clusters. That is harmless
+	// but qemu-img reports it as leaks, so let it repair them when available.
+	if _, err := backend.LookPath("qemu-img"); err == nil {
+		file.Close()
+		if out, err := backend.CombinedOutput(ctx, "qemu-img", "check", "-r", "leaks", config.DevicePath); err != nil {
+			log.Printf("Warning: qemu-img check -r leaks on %s failed: %v. Output: %s", config.DevicePath, err, string(out))
+		}
+	}
+
+	completion := WipeProgress{
+		DeviceID: config.DevicePath,
+		Status:   "done",
+		Progress: 100,
+	}
+	jsonMsg, _ := json.Marshal(completion)
+	progress <- string(jsonMsg)
+	return nil
+}
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Device event types pushed to clients.
+const (
+	DeviceAdded   = "device_added"
+	DeviceRemoved = "device_removed"
+	DeviceChanged = "device_changed"
+)
+
+const (
+	// storageSettle lets the burst of disk and partition events from one
+	// hot-plug arrive before lsblk runs.
+	storageSettle = 500 * time.Millisecond
+	// mobileSettle gives the adb server time to see a newly plugged phone.
+	// With adb device tracking, phones are refreshed as soon as adb reports them.
+	mobileSettle = 2 * time.Second
+	// registryResync is a full rescan that catches anything missed. Without a
+	// uevent source it is the only way changes are noticed.
+	registryResync = time.Minute
+	registryPoll   = 5 * time.Second
+)
+
+// DeviceEvent describes a change in the device registry.
+type DeviceEvent struct {
+	Status string        `json:"status"`
+	Kind   string        `json:"kind"`   // "storage" or "mobile"
+	Device string        `json:"device"` // /dev path or adb serial
+	Drive  *Drive        `json:"drive,omitempty"`
+	Mobile *MobileDevice `json:"mobile,omitempty"`
+}
+
+// rescanRequests asks the monitor for a storage rescan after changes that
+// produce no uevent, such as unmounting.
+var rescanRequests = make(chan struct{}, 1)
+
+// requestRescan schedules a storage rescan without waiting for it.
+func requestRescan() {
+	select {
+	case rescanRequests <- struct{}{}:
+	default:
+	}
+}
+
+// mobileRequests asks the monitor to refresh the phones now, when adb device
+// tracking reports a change.
+var mobileRequests = make(chan struct{}, 1)
+
+func requestMobileRefresh() {
+	select {
+	case mobileRequests <- struct{}{}:
+	default:
+	}
+}
+
+// registry is the in-memory view of attached devices, kept current from
+// uevents so clients don't have to re-run lsblk on every request.
+var registry = struct {
+	sync.Mutex
+	started   bool
+	drives    []Drive
+	mobile    []MobileDevice
+	listeners []func([]Drive)
+}{}
+
+// StartDeviceMonitor fills the registry and keeps it up to date from the
+// backend's uevents. Changes are sent on events as DeviceEvent JSON.
+func StartDeviceMonitor(events chan<- string) {
+	refreshStorage(events)
+	refreshMobile(events)
+	registry.Lock()
+	registry.started = true
+	registry.Unlock()
+	startAdbTracking(requestMobileRefresh)
+
+	uevents, err := backend.Uevents()
+	if err != nil {
+		log.Printf("Warning: hot-plug events unavailable, polling every %s instead: %v", registryPoll, err)
+	}
+	go monitorDevices(uevents, events)
+}
+
+func monitorDevices(uevents <-chan Uevent, events chan<- string) {
+	resync := registryResync
+	if uevents == nil {
+		resync = registryPoll
+	}
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+
+	// Timers coalesce bursts of uevents into one rescan each.
+	storageTimer := time.NewTimer(0)
+	<-storageTimer.C
+	mobileTimer := time.NewTimer(0)
+	<-mobileTimer.C
+
+	for {
+		select {
+		case event, ok := <-uevents:
+			if !ok {
+				log.Printf("Warning: hot-plug events stopped, polling every %s instead", registryPoll)
+				uevents = nil
+				ticker.Reset(registryPoll)
+				continue
+			}
+			switch {
+			case event.Action == "rescan":
+				storageTimer.Reset(storageSettle)
+				mobileTimer.Reset(mobileSettle)
+			case event.Subsystem == "block":
+				storageTimer.Reset(storageSettle)
+			case event.Subsystem == "usb" && event.DevType == "usb_device":
+				mobileTimer.Reset(mobileSettle)
+			}
+		case <-rescanRequests:
+			storageTimer.Reset(storageSettle)
+		case <-mobileRequests:
+			refreshMobile(events)
+		case <-storageTimer.C:
+			refreshStorage(events)
+		case <-mobileTimer.C:
+			refreshMobile(events)
+		case <-ticker.C:
+			refreshStorage(events)
+			refreshMobile(events)
+		}
+	}
+}
+
+// onDrivesChanged registers fn to be called with every drive after each
+// storage change.
+func onDrivesChanged(fn func([]Drive)) {
+	registry.Lock()
+	registry.listeners = append(registry.listeners, fn)
+	registry.Unlock()
+}
+
+func refreshStorage(events chan<- string) {
+	drives, err := detectStorageDrives()
+	if err != nil {
+		log.Printf("Warning: could not refresh storage drives: %v", err)
+		return
+	}
+	if drives == nil {
+		drives = []Drive{}
+	}
+
+	registry.Lock()
+	previous := make(map[string]Drive, len(registry.drives))
+	for _, d := range registry.drives {
+		previous[d.Name] = d
+	}
+	var changes []DeviceEvent
+	for _, d := range drives {
+		d := d
+		old, known := previous[d.Name]
+		delete(previous, d.Name)
+		switch {
+		case !known:
+			changes = append(changes, DeviceEvent{Status: DeviceAdded, Kind: "storage", Device: d.Name, Drive: &d})
+		case !reflect.DeepEqual(old, d):
+			changes = append(changes, DeviceEvent{Status: DeviceChanged, Kind: "storage", Device: d.Name, Drive: &d})
+		}
+	}
+	for name, old := range previous {
+		old := old
+		changes = append(changes, DeviceEvent{Status: DeviceRemoved, Kind: "storage", Device: name, Drive: &old})
+	}
+	registry.drives = drives
+	listeners := registry.listeners
+	started := registry.started
+	registry.Unlock()
+
+	if !started || len(changes) == 0 {
+		return
+	}
+	publishDeviceEvents(changes, events)
+	for _, fn := range listeners {
+		fn(drives)
+	}
+}
+
+func refreshMobile(events chan<- string) {
+	// adb missing or failing looks the same as no phones attached.
+	devices, _ := detectAndroidDevices()
+	if devices == nil {
+		devices = []MobileDevice{}
+	}
+
+	registry.Lock()
+	previous := make(map[string]MobileDevice, len(registry.mobile))
+	for _, d := range registry.mobile {
+		previous[d.Serial] = d
+	}
+	var changes []DeviceEvent
+	for _, d := range devices {
+		d := d
+		old, known := previous[d.Serial]
+		delete(previous, d.Serial)
+		switch {
+		case !known:
+			changes = append(changes, DeviceEvent{Status: DeviceAdded, Kind: "mobile", Device: d.Serial, Mobile: &d})
+		case !reflect.DeepEqual(old, d):
+			changes = append(changes, DeviceEvent{Status: DeviceChanged, Kind: "mobile", Device: d.Serial, Mobile: &d})
+		}
+	}
+	for serial, old := range previous {
+		old := old
+		changes = append(changes, DeviceEvent{Status: DeviceRemoved, Kind: "mobile", Device: serial, Mobile: &old})
+	}
+	registry.mobile = devices
+	started := registry.started
+	registry.Unlock()
+
+	if started {
+		publishDeviceEvents(changes, events)
+	}
+}
+
+func publishDeviceEvents(changes []DeviceEvent, events chan<- string) {
+	sort.Slice(changes, func(a, b int) bool { return changes[a].Device < changes[b].Device })
+	for _, c := range changes {
+		log.Printf("Device %s: %s", c.Status, c.Device)
+		msg, _ := json.Marshal(c)
+		events <- string(msg)
+	}
+}
+
+// Devices returns the attached devices from the registry, in the same shape as
+// DetectDevices. Before the monitor has started it falls back to detection.
+func Devices() (map[string]interface{}, error) {
+	registry.Lock()
+	started := registry.started
+	drives := append([]Drive{}, registry.drives...)
+	mobile := append([]MobileDevice{}, registry.mobile...)
+	registry.Unlock()
+
+	if !started {
+		return DetectDevices()
+	}
+	return map[string]interface{}{"storage": drives, "mobile": mobile}, nil
+}
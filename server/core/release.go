@@ -0,0 +1,252 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReleaseAction is one step needed to free a device for exclusive use.
+type ReleaseAction struct {
+	Kind    string   `json:"kind"` // "umount", "swapoff", "lvm", "crypt", "dm", "md" or "zpool"
+	Device  string   `json:"device"`
+	Target  string   `json:"target"`
+	Command []string `json:"command"`
+	Done    bool     `json:"done"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// ReleaseReport lists what ReleaseDevice did, or would do in a dry run.
+type ReleaseReport struct {
+	Device  string          `json:"device"`
+	DryRun  bool            `json:"dryRun"`
+	Actions []ReleaseAction `json:"actions"`
+}
+
+// Failed returns the actions that could not be carried out.
+func (r *ReleaseReport) Failed() []ReleaseAction {
+	var failed []ReleaseAction
+	for _, a := range r.Actions {
+		if a.Error != "" {
+			failed = append(failed, a)
+		}
+	}
+	return failed
+}
+
+// ReleaseDevice frees devicePath and everything stacked on it. The holder
+// graph is read from sysfs (partitions, then holders: dm, md), every mount and
+// swap area on it is released first, then the stacked devices are deactivated
+// from the top down: LVM volume groups, LUKS mappings, md arrays and ZFS pools.
+// With dryRun the actions are only listed.
+func ReleaseDevice(devicePath string, dryRun bool) (*ReleaseReport, error) {
+	name := filepath.Base(devicePath)
+	if _, err := os.Stat(filepath.Join(sysfsRoot, "class", "block", name)); err != nil {
+		return nil, fmt.Errorf("device %s not found", devicePath)
+	}
+
+	nodes := holderGraph(name)
+	actions, err := releaseActions(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReleaseReport{Device: devicePath, DryRun: dryRun, Actions: actions}
+	if dryRun {
+		return report, nil
+	}
+	for i := range report.Actions {
+		a := &report.Actions[i]
+		log.Printf("Releasing %s: %s", devicePath, strings.Join(a.Command, " "))
+		output, err := backend.CombinedOutput(context.Background(), a.Command[0], a.Command[1:]...)
+		if err != nil {
+			a.Error = fmt.Sprintf("%v. Output: %s", err, strings.TrimSpace(string(output)))
+			log.Printf("Failed to release %s (%s %s): %s", a.Device, a.Kind, a.Target, a.Error)
+			continue
+		}
+		a.Done = true
+	}
+	if len(report.Actions) > 0 {
+		requestRescan()
+	}
+	return report, nil
+}
+
+// holderGraph returns the disk, its partitions and everything holding them,
+// ordered so that every device comes before the devices it is stacked on.
+func holderGraph(disk string) []string {
+	var order []string
+	seen := make(map[string]bool)
+
+	var visit func(string)
+	visit = func(n string) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		holders, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", n, "holders"))
+		for _, h := range holders {
+			visit(h.Name())
+		}
+		order = append(order, n)
+	}
+
+	for _, part := range partitionNames(disk) {
+		visit(part)
+	}
+	visit(disk)
+	return order
+}
+
+// partitionNames lists the partitions of disk from its sysfs directory.
+func partitionNames(disk string) []string {
+	dir, err := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "class", "block", disk))
+	if err != nil {
+		return nil
+	}
+	entries, _ := os.ReadDir(dir)
+	var parts []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), "partition")); err == nil {
+			parts = append(parts, e.Name())
+		}
+	}
+	return parts
+}
+
+func releaseActions(nodes []string) ([]ReleaseAction, error) {
+	inGraph := make(map[string]bool)
+	for _, n := range nodes {
+		inGraph[n] = true
+	}
+
+	actions := []ReleaseAction{}
+
+	// Mounts first, deepest mountpoint first so nested mounts come off cleanly.
+	mounts, err := readMountinfo()
+	if err != nil {
+		return nil, err
+	}
+	var umounts []ReleaseAction
+	for _, m := range mounts {
+		name, err := m.blockName()
+		if err != nil || !inGraph[name] {
+			continue
+		}
+		umounts = append(umounts, ReleaseAction{Kind: "umount", Device: name, Target: m.mountpoint, Command: []string{"umount", m.mountpoint}})
+	}
+	sort.SliceStable(umounts, func(i, j int) bool {
+		return strings.Count(umounts[i].Target, "/") > strings.Count(umounts[j].Target, "/")
+	})
+	actions = append(actions, umounts...)
+
+	swaps, err := activeSwapDevices()
+	if err != nil {
+		return nil, err
+	}
+	for _, swap := range swaps {
+		if !strings.HasPrefix(swap, "/dev/") {
+			continue
+		}
+		if name, err := blockNameForPath(swap); err == nil && inGraph[name] {
+			actions = append(actions, ReleaseAction{Kind: "swapoff", Device: name, Target: swap, Command: []string{"swapoff", swap}})
+		}
+	}
+
+	pools := zfsPools(nodes)
+	deactivatedVGs := make(map[string]bool)
+	for _, n := range nodes {
+		blockDir := filepath.Join(sysfsRoot, "class", "block", n)
+		switch {
+		case strings.HasPrefix(n, "dm-"):
+			dmName := readTrimmedFile(filepath.Join(blockDir, "dm", "name"))
+			uuid := readTrimmedFile(filepath.Join(blockDir, "dm", "uuid"))
+			switch {
+			case strings.HasPrefix(uuid, "LVM-"):
+				vg := lvmVolumeGroup(dmName)
+				if vg == "" || deactivatedVGs[vg] {
+					continue
+				}
+				deactivatedVGs[vg] = true
+				actions = append(actions, ReleaseAction{Kind: "lvm", Device: n, Target: vg, Command: []string{"vgchange", "-an", vg}})
+			case strings.HasPrefix(uuid, "CRYPT-"):
+				actions = append(actions, ReleaseAction{Kind: "crypt", Device: n, Target: dmName, Command: []string{"cryptsetup", "close", dmName}})
+			case dmName != "":
+				actions = append(actions, ReleaseAction{Kind: "dm", Device: n, Target: dmName, Command: []string{"dmsetup", "remove", dmName}})
+			}
+		case strings.HasPrefix(n, "md"):
+			if _, err := os.Stat(filepath.Join(blockDir, "md")); err == nil {
+				actions = append(actions, ReleaseAction{Kind: "md", Device: n, Target: "/dev/" + n, Command: []string{"mdadm", "--stop", "/dev/" + n}})
+			}
+		}
+		if pool, ok := pools[n]; ok {
+			delete(pools, n)
+			actions = append(actions, ReleaseAction{Kind: "zpool", Device: n, Target: pool, Command: []string{"zpool", "export", pool}})
+		}
+	}
+	return actions, nil
+}
+
+// lvmVolumeGroup extracts the VG from a device-mapper name like "vg--data-root",
+// where dashes inside the VG or LV name are doubled.
+func lvmVolumeGroup(dmName string) string {
+	for i := 0; i < len(dmName); i++ {
+		if dmName[i] != '-' {
+			continue
+		}
+		if i+1 < len(dmName) && dmName[i+1] == '-' {
+			i++
+			continue
+		}
+		return strings.ReplaceAll(dmName[:i], "--", "-")
+	}
+	return ""
+}
+
+// zfsPools maps member devices in nodes to the ZFS pool they belong to. ZFS
+// does not use holders, so membership comes from the zfs_member label.
+func zfsPools(nodes []string) map[string]string {
+	pools := make(map[string]string)
+	if len(nodes) == 0 {
+		return pools
+	}
+	args := []string{"-d", "-J", "-o", "NAME,FSTYPE,LABEL"}
+	for _, n := range nodes {
+		args = append(args, "/dev/"+n)
+	}
+	out, err := backend.Output("lsblk", args...)
+	if err != nil {
+		return pools
+	}
+	var data struct {
+		BlockDevices []struct {
+			Name   string `json:"name"`
+			FSType string `json:"fstype"`
+			Label  string `json:"label"`
+		} `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return pools
+	}
+	claimed := make(map[string]bool)
+	for _, d := range data.BlockDevices {
+		if d.FSType == "zfs_member" && d.Label != "" && !claimed[d.Label] {
+			claimed[d.Label] = true
+			pools[d.Name] = d.Label
+		}
+	}
+	return pools
+}
+
+func readTrimmedFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ReprovisionConfig asks for the disk to be made ready to ship after a
+// successful wipe: a fresh partition table with one partition and filesystem.
+type ReprovisionConfig struct {
+	PartitionTable string `json:"partitionTable"` // "gpt" or "dos"
+	Filesystem     string `json:"filesystem"`     // "exfat", "ext4" or "ntfs"
+	Label          string `json:"label,omitempty"`
+}
+
+// ReprovisionResult is reported separately from the sanitization result: a
+// failed reprovision does not make the wipe itself any less complete.
+type ReprovisionResult struct {
+	Status    string    `json:"status"` // "succeeded" or "failed"
+	Error     string    `json:"error,omitempty"`
+	Partition string    `json:"partition,omitempty"`
+	Steps     []string  `json:"steps"`
+	Finished  time.Time `json:"finished"`
+}
+
+type filesystemSpec struct {
+	mkfs      string
+	labelFlag string
+	extraArgs []string
+	maxLabel  int
+	// partedType picks the partition type: "ntfs" gives MBR type 0x07 and the
+	// Microsoft basic data GUID, which is also what exFAT uses.
+	partedType string
+	labelUpper bool
+}
+
+var filesystemSpecs = map[string]filesystemSpec{
+	"ext4":  {mkfs: "mkfs.ext4", labelFlag: "-L", extraArgs: []string{"-F", "-q"}, maxLabel: 16, partedType: "ext4"},
+	"exfat": {mkfs: "mkfs.exfat", labelFlag: "-n", maxLabel: 11, partedType: "ntfs", labelUpper: true},
+	"ntfs":  {mkfs: "mkfs.ntfs", labelFlag: "-L", extraArgs: []string{"-f", "-F"}, maxLabel: 32, partedType: "ntfs"},
+}
+
+// Validate checks the requested layout before any wipe starts.
+func (rc *ReprovisionConfig) Validate() error {
+	if rc.PartitionTable != "gpt" && rc.PartitionTable != "dos" {
+		return fmt.Errorf("unsupported partition table %q (expected gpt or dos)", rc.PartitionTable)
+	}
+	spec, ok := filesystemSpecs[rc.Filesystem]
+	if !ok {
+		return fmt.Errorf("unsupported filesystem %q (expected exfat, ext4 or ntfs)", rc.Filesystem)
+	}
+	if len(rc.Label) > spec.maxLabel {
+		return fmt.Errorf("label %q is longer than %d characters allowed for %s", rc.Label, spec.maxLabel, rc.Filesystem)
+	}
+	for _, r := range rc.Label {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return fmt.Errorf("label %q must be printable ASCII", rc.Label)
+		}
+	}
+	return nil
+}
+
+// String describes the layout, e.g. "gpt/exfat/BACKUP"; a nil config is "".
+func (rc *ReprovisionConfig) String() string {
+	if rc == nil {
+		return ""
+	}
+	return rc.PartitionTable + "/" + rc.Filesystem + "/" + rc.Label
+}
+
+// tools lists the programs reprovisioning needs.
+func (rc *ReprovisionConfig) tools() []string {
+	return []string{"parted", "blockdev", filesystemSpecs[rc.Filesystem].mkfs}
+}
+
+// partitionPath returns the node of partition n: sda -> sda1, nvme0n1 -> nvme0n1p1.
+func partitionPath(devicePath string, n int) string {
+	if last := devicePath[len(devicePath)-1]; last >= '0' && last <= '9' {
+		return fmt.Sprintf("%sp%d", devicePath, n)
+	}
+	return fmt.Sprintf("%s%d", devicePath, n)
+}
+
+// checkPartitionOf confirms that partition is a partition of the disk at
+// devicePath, as sysfs lays it out, before anything is written to it.
+func checkPartitionOf(partition, devicePath string) error {
+	dir, err := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "class", "block", filepath.Base(partition)))
+	if err != nil {
+		return fmt.Errorf("%s does not exist", partition)
+	}
+	if filepath.Base(filepath.Dir(dir)) != filepath.Base(devicePath) {
+		return fmt.Errorf("%s is not a partition of %s", partition, devicePath)
+	}
+	return nil
+}
+
+// Reprovision writes a fresh partition table with a single partition spanning
+// the wiped disk, asks the kernel to re-read it and creates the filesystem.
+// The disk's pinned identity is checked again before partitioning and before
+// formatting, so a disk swapped in after the wipe is never touched. The tools
+// open the disk themselves (mkfs with O_EXCL), so no claim is held between
+// the steps.
+func Reprovision(config WipeConfig, progress chan<- string) *ReprovisionResult {
+	devicePath, rc := config.DevicePath, *config.Reprovision
+	result := &ReprovisionResult{Steps: []string{}}
+	fail := func(err error) *ReprovisionResult {
+		log.Printf("Reprovisioning %s failed: %v", devicePath, err)
+		result.Status = JobFailed
+		result.Error = err.Error()
+		result.Finished = time.Now().UTC()
+		return result
+	}
+	run := func(name string, args ...string) error {
+		result.Steps = append(result.Steps, strings.Join(append([]string{name}, args...), " "))
+		out, err := backend.CombinedOutput(context.Background(), name, args...)
+		if err != nil {
+			return fmt.Errorf("%s failed: %w. Output: %s", name, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	if err := rc.Validate(); err != nil {
+		return fail(err)
+	}
+	if isFileTarget(devicePath) {
+		return fail(fmt.Errorf("reprovisioning is only supported for block devices"))
+	}
+	if config.Identity.IsZero() {
+		return fail(fmt.Errorf("wipe request for %s does not pin a device identity", devicePath))
+	}
+	spec := filesystemSpecs[rc.Filesystem]
+
+	if err := verifyDeviceIdentity(devicePath, config.Identity); err != nil {
+		return fail(fmt.Errorf("refusing to partition: %w", err))
+	}
+	progress <- fmt.Sprintf("Reprovisioning: writing %s partition table...", rc.PartitionTable)
+	table := rc.PartitionTable
+	if table == "dos" {
+		table = "msdos"
+	}
+	if err := run("parted", "-s", "-a", "optimal", devicePath,
+		"mklabel", table, "mkpart", "primary", spec.partedType, "1MiB", "100%"); err != nil {
+		return fail(err)
+	}
+
+	progress <- "Reprovisioning: asking the kernel to re-read the partition table..."
+	if err := run("blockdev", "--rereadpt", devicePath); err != nil {
+		return fail(err)
+	}
+	if _, err := backend.LookPath("udevadm"); err == nil {
+		run("udevadm", "settle")
+	}
+
+	partition := partitionPath(devicePath, 1)
+	result.Partition = partition
+	label := rc.Label
+	if spec.labelUpper {
+		label = strings.ToUpper(label)
+	}
+	args := append([]string{}, spec.extraArgs...)
+	if label != "" {
+		args = append(args, spec.labelFlag, label)
+	}
+	args = append(args, partition)
+
+	if err := verifyDeviceIdentity(devicePath, config.Identity); err != nil {
+		return fail(fmt.Errorf("refusing to format: %w", err))
+	}
+	if err := checkPartitionOf(partition, devicePath); err != nil {
+		return fail(fmt.Errorf("refusing to format: %w", err))
+	}
+	progress <- fmt.Sprintf("Reprovisioning: creating %s on %s...", rc.Filesystem, partition)
+	if err := run(spec.mkfs, args...); err != nil {
+		return fail(err)
+	}
+
+	result.Status = JobSucceeded
+	result.Finished = time.Now().UTC()
+	progress <- fmt.Sprintf("Reprovisioning complete: %s (%s) on %s.", rc.Filesystem, rc.PartitionTable, partition)
+	return result
+}
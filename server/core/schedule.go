@@ -0,0 +1,383 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchedulePending   = "pending"
+	ScheduleStarted   = "started"
+	ScheduleCancelled = "cancelled"
+	ScheduleMissed    = "missed"
+	ScheduleRefused   = "refused"
+)
+
+// scheduleGrace is how late a one-off wipe may still start, e.g. after a
+// restart. Anything later is marked missed instead of wiping unexpectedly.
+const scheduleGrace = 2 * time.Minute
+
+// scheduleRunHistory is how many runs a schedule remembers.
+const scheduleRunHistory = 50
+
+// ScheduleRun is one time a schedule came due: the preflight refused it, or
+// it started the job JobID. Status is "refused", "running" or the job's
+// final status.
+type ScheduleRun struct {
+	StartedAt time.Time `json:"startedAt"`
+	Status    string    `json:"status"`
+	JobID     string    `json:"jobId,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ScheduledWipe is a confirmed wipe that starts later: once at StartAt, or
+// repeatedly whenever Cron matches. It is persisted under
+// ~/.config/DZap/schedules so it survives restarts.
+type ScheduledWipe struct {
+	ID        string     `json:"id"`
+	Config    WipeConfig `json:"config"`
+	StartAt   *time.Time `json:"startAt,omitempty"`
+	Cron      string     `json:"cron,omitempty"`
+	NextRun   time.Time  `json:"nextRun"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"createdAt"`
+	LastRun   *time.Time `json:"lastRun,omitempty"`
+	// Runs is the run history, oldest first.
+	Runs []ScheduleRun `json:"runs,omitempty"`
+}
+
+// ScheduleRunner runs a due wipe the same way an interactive one is run and
+// returns once the job has finished.
+type ScheduleRunner func(config WipeConfig) (*WipeJob, error)
+
+var (
+	schedules      = make(map[string]*ScheduledWipe)
+	schedulesMutex = &sync.Mutex{}
+)
+
+// nextRunAfter computes when s should next start, after t.
+func (s *ScheduledWipe) nextRunAfter(t time.Time) (time.Time, error) {
+	if s.Cron == "" {
+		return *s.StartAt, nil
+	}
+	cron, err := parseCron(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := cron.next(t)
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never matches", s.Cron)
+	}
+	return next, nil
+}
+
+// ScheduleWipe records a wipe to start at startAt or on every match of cron
+// (exactly one must be given). The caller must already have consumed the
+// preflight confirmation for config; the preflight is repeated before every
+// run, which is refused if the disk is no longer the confirmed one.
+func ScheduleWipe(config WipeConfig, startAt time.Time, cron string) (*ScheduledWipe, error) {
+	if (cron == "") == startAt.IsZero() {
+		return nil, fmt.Errorf("give either a start time or a cron expression")
+	}
+	config.ConfirmationToken = ""
+	s := &ScheduledWipe{
+		ID:        newJobID(),
+		Config:    config,
+		Status:    SchedulePending,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.setNextRun(startAt, cron); err != nil {
+		return nil, err
+	}
+
+	schedulesMutex.Lock()
+	schedules[s.ID] = s
+	schedulesMutex.Unlock()
+	s.save()
+	log.Printf("Scheduled %s of %s for %s (id %s)", config.Method, config.DevicePath, s.NextRun.Local().Format(time.RFC1123), s.ID)
+	return s.snapshot(), nil
+}
+
+func (s *ScheduledWipe) setNextRun(startAt time.Time, cron string) error {
+	if cron == "" && !startAt.After(time.Now()) {
+		return fmt.Errorf("start time %s is in the past", startAt.Format(time.RFC3339))
+	}
+	s.StartAt, s.Cron = nil, cron
+	if cron == "" {
+		start := startAt.UTC()
+		s.StartAt = &start
+	}
+	next, err := s.nextRunAfter(time.Now())
+	if err != nil {
+		return err
+	}
+	s.NextRun = next.UTC()
+	return nil
+}
+
+// RescheduleWipe moves a pending wipe to a new start time or cron expression.
+func RescheduleWipe(id string, startAt time.Time, cron string) (*ScheduledWipe, error) {
+	if (cron == "") == startAt.IsZero() {
+		return nil, fmt.Errorf("give either a start time or a cron expression")
+	}
+	schedulesMutex.Lock()
+	s, ok := schedules[id]
+	if !ok || s.Status != SchedulePending {
+		schedulesMutex.Unlock()
+		return nil, fmt.Errorf("no pending scheduled wipe %s", id)
+	}
+	err := s.setNextRun(startAt, cron)
+	schedulesMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	s.save()
+	return s.snapshot(), nil
+}
+
+// CancelScheduledWipe cancels a wipe that has not started yet.
+func CancelScheduledWipe(id string) (*ScheduledWipe, error) {
+	schedulesMutex.Lock()
+	s, ok := schedules[id]
+	if !ok || s.Status != SchedulePending {
+		schedulesMutex.Unlock()
+		return nil, fmt.Errorf("no pending scheduled wipe %s", id)
+	}
+	s.Status = ScheduleCancelled
+	schedulesMutex.Unlock()
+	s.save()
+	log.Printf("Cancelled scheduled wipe %s of %s", id, s.Config.DevicePath)
+	return s.snapshot(), nil
+}
+
+// ListScheduledWipes returns every schedule, soonest first.
+func ListScheduledWipes() []*ScheduledWipe {
+	schedulesMutex.Lock()
+	list := make([]*ScheduledWipe, 0, len(schedules))
+	for _, s := range schedules {
+		snapshot := *s
+		snapshot.Runs = append([]ScheduleRun(nil), s.Runs...)
+		list = append(list, &snapshot)
+	}
+	schedulesMutex.Unlock()
+	sort.Slice(list, func(a, b int) bool { return list[a].NextRun.Before(list[b].NextRun) })
+	return list
+}
+
+func (s *ScheduledWipe) snapshot() *ScheduledWipe {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+	snapshot := *s
+	snapshot.Runs = append([]ScheduleRun(nil), s.Runs...)
+	return &snapshot
+}
+
+// StartScheduler loads persisted schedules and starts due wipes through run.
+// Countdown messages for pending wipes are sent on events.
+func StartScheduler(run ScheduleRunner, events chan<- string) {
+	loadSchedules()
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			tickSchedules(now, run, events)
+		}
+	}()
+}
+
+func tickSchedules(now time.Time, run ScheduleRunner, events chan<- string) {
+	var due, changed []*ScheduledWipe
+	var countdowns [][]byte
+
+	schedulesMutex.Lock()
+	for _, s := range schedules {
+		if s.Status != SchedulePending {
+			continue
+		}
+		remaining := s.NextRun.Sub(now)
+		switch {
+		case remaining > 0:
+			// Every 10 seconds, then every second during the last minute.
+			secs := int(remaining.Round(time.Second).Seconds())
+			if secs <= 60 || secs%10 == 0 {
+				msg, _ := json.Marshal(map[string]interface{}{
+					"status":           "scheduled",
+					"scheduleId":       s.ID,
+					"deviceId":         s.Config.DevicePath,
+					"method":           s.Config.Method,
+					"startAt":          s.NextRun,
+					"secondsRemaining": secs,
+				})
+				countdowns = append(countdowns, msg)
+			}
+		case s.Cron == "" && -remaining > scheduleGrace:
+			log.Printf("Warning: scheduled wipe %s of %s was due at %s and is now missed", s.ID, s.Config.DevicePath, s.NextRun.Format(time.RFC3339))
+			s.Status = ScheduleMissed
+			changed = append(changed, s)
+		default:
+			started := now.UTC()
+			s.LastRun = &started
+			if s.Cron == "" {
+				s.Status = ScheduleStarted
+			} else if next, err := s.nextRunAfter(now); err == nil {
+				s.NextRun = next.UTC()
+			} else {
+				s.Status = ScheduleStarted
+			}
+			due = append(due, s)
+			changed = append(changed, s)
+		}
+	}
+	schedulesMutex.Unlock()
+
+	for _, s := range changed {
+		s.save()
+	}
+	for _, msg := range countdowns {
+		events <- string(msg)
+	}
+	for _, s := range due {
+		go s.start(run, events)
+	}
+}
+
+// start repeats the preflight, including the identity and system disk
+// checks, and runs the wipe only if it passes. Both outcomes are recorded in
+// the run history.
+func (s *ScheduledWipe) start(run ScheduleRunner, events chan<- string) {
+	snapshot := s.snapshot()
+	config := snapshot.Config
+	startedAt := *snapshot.LastRun
+
+	result, err := Preflight(config)
+	if err == nil && !result.OK {
+		var failed []string
+		for _, c := range result.Checks {
+			if !c.Passed {
+				failed = append(failed, fmt.Sprintf("%s: %s", c.Name, c.Detail))
+			}
+		}
+		err = fmt.Errorf("preflight failed (%s)", strings.Join(failed, "; "))
+	}
+	if err != nil {
+		log.Printf("Warning: scheduled wipe %s of %s refused: %v", s.ID, config.DevicePath, err)
+		s.recordRun(ScheduleRun{StartedAt: startedAt, Status: ScheduleRefused, Error: err.Error()})
+		msg, _ := json.Marshal(map[string]interface{}{
+			"status":     "schedule_refused",
+			"scheduleId": s.ID,
+			"deviceId":   config.DevicePath,
+			"error":      err.Error(),
+		})
+		events <- string(msg)
+		return
+	}
+
+	log.Printf("Starting scheduled wipe %s of %s", s.ID, config.DevicePath)
+	s.recordRun(ScheduleRun{StartedAt: startedAt, Status: JobRunning})
+	job, err := run(config)
+	s.updateRun(startedAt, func(r *ScheduleRun) {
+		if job != nil {
+			r.JobID, r.Status = job.ID, job.Status
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+	})
+}
+
+// recordRun appends r to the run history. A refused one-off wipe is marked
+// refused rather than started.
+func (s *ScheduledWipe) recordRun(r ScheduleRun) {
+	schedulesMutex.Lock()
+	s.Runs = append(s.Runs, r)
+	if len(s.Runs) > scheduleRunHistory {
+		s.Runs = s.Runs[len(s.Runs)-scheduleRunHistory:]
+	}
+	if r.Status == ScheduleRefused && s.Cron == "" {
+		s.Status = ScheduleRefused
+	}
+	schedulesMutex.Unlock()
+	s.save()
+}
+
+// updateRun changes the run that started at startedAt.
+func (s *ScheduledWipe) updateRun(startedAt time.Time, fn func(*ScheduleRun)) {
+	schedulesMutex.Lock()
+	for i := range s.Runs {
+		if s.Runs[i].StartedAt.Equal(startedAt) {
+			fn(&s.Runs[i])
+		}
+	}
+	schedulesMutex.Unlock()
+	s.save()
+}
+
+func (s *ScheduledWipe) save() {
+	dir, err := configPath("schedules")
+	if err != nil {
+		log.Printf("Warning: could not persist schedule %s: %v", s.ID, err)
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("Warning: could not create schedules directory: %v", err)
+		return
+	}
+
+	schedulesMutex.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	schedulesMutex.Unlock()
+	if err != nil {
+		log.Printf("Warning: could not encode schedule %s: %v", s.ID, err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, s.ID+".json"), data, 0600); err != nil {
+		log.Printf("Warning: could not persist schedule %s: %v", s.ID, err)
+	}
+}
+
+// loadSchedules restores schedules after a restart. A cron schedule resumes at
+// its next match rather than catching up on the runs it missed.
+func loadSchedules() {
+	dir, err := configPath("schedules")
+	if err != nil {
+		return
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	loaded := 0
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		s := &ScheduledWipe{}
+		if err := json.Unmarshal(data, s); err != nil {
+			log.Printf("Warning: could not parse schedule %s: %v", file.Name(), err)
+			continue
+		}
+		if s.Status == SchedulePending && s.Cron != "" && s.NextRun.Before(now) {
+			if next, err := s.nextRunAfter(now); err == nil {
+				s.NextRun = next.UTC()
+			}
+		}
+		schedulesMutex.Lock()
+		schedules[s.ID] = s
+		schedulesMutex.Unlock()
+		loaded++
+	}
+	log.Printf("Loaded %d scheduled wipes", loaded)
+}
@@ -0,0 +1,335 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiskSignature is one recognizable on-disk structure, as wipefs would report it.
+type DiskSignature struct {
+	Offset int64  `json:"offset"`
+	Type   string `json:"type"`
+	Usage  string `json:"usage"`
+	Label  string `json:"label,omitempty"`
+	UUID   string `json:"uuid,omitempty"`
+}
+
+// SignatureScan is a snapshot of every signature found on a device.
+type SignatureScan struct {
+	Device         string          `json:"device"`
+	ScannedAt      time.Time       `json:"scannedAt"`
+	PartitionTable string          `json:"partitionTable,omitempty"`
+	Signatures     []DiskSignature `json:"signatures"`
+	Clean          bool            `json:"clean"`
+}
+
+// Summary lists the signature types, e.g. "gpt@0x200, ext4@0x100400".
+func (s *SignatureScan) Summary() string {
+	if len(s.Signatures) == 0 {
+		return "no signatures"
+	}
+	var parts []string
+	for _, sig := range s.Signatures {
+		parts = append(parts, fmt.Sprintf("%s@%#x", sig.Type, sig.Offset))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// onlyPartitionTables reports whether every signature found is a partition
+// table, with no filesystem or container left inside it.
+func (s *SignatureScan) onlyPartitionTables() bool {
+	for _, sig := range s.Signatures {
+		if !strings.HasPrefix(sig.Usage, "partition table") {
+			return false
+		}
+	}
+	return true
+}
+
+// ScanSignatures probes devicePath for partition tables and filesystem, RAID,
+// LVM, LUKS and swap signatures, including inside each partition it finds.
+// The probe runs wipefs, so it is recorded in the job log; the built-in prober
+// takes over when wipefs fails and looks inside partitions the kernel has not
+// read, such as those of a disk image.
+func ScanSignatures(devicePath string) (*SignatureScan, error) {
+	p, file, err := openProber(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scan := &SignatureScan{Device: devicePath, ScannedAt: time.Now().UTC(), Signatures: []DiskSignature{}}
+	tableSigs, partitions := p.partitionTables()
+	sigs, probed, err := wipefsSignatures(devicePath)
+	if err != nil {
+		log.Printf("Warning: wipefs could not probe %s, using the built-in prober: %v", devicePath, err)
+		sigs = append(tableSigs, p.contentSignatures(0)...)
+	}
+	scan.Signatures = append(scan.Signatures, sigs...)
+	for _, start := range partitions {
+		if !probed[start] {
+			scan.Signatures = append(scan.Signatures, p.contentSignatures(start)...)
+		}
+	}
+
+	for _, sig := range scan.Signatures {
+		if sig.Usage == "partition table" && sig.Type != "PMBR" {
+			scan.PartitionTable = sig.Type
+			break
+		}
+	}
+	scan.Clean = len(scan.Signatures) == 0
+	return scan, nil
+}
+
+// wipefsSignatures runs wipefs on devicePath and the partitions the kernel
+// knows on it. It returns the signatures with their offsets on devicePath and
+// the starts of the partitions it probed.
+func wipefsSignatures(devicePath string) ([]DiskSignature, map[int64]bool, error) {
+	targets := []string{devicePath}
+	starts := map[string]int64{filepath.Base(devicePath): 0}
+	probed := make(map[int64]bool)
+	if strings.HasPrefix(devicePath, "/dev/") {
+		for _, part := range partitionNames(filepath.Base(devicePath)) {
+			targets = append(targets, "/dev/"+part)
+			starts[part] = partitionStart(part)
+			probed[starts[part]] = true
+		}
+	}
+
+	args := append([]string{"--no-act", "--json", "--output", "DEVICE,OFFSET,TYPE,USAGE,LABEL,UUID"}, targets...)
+	out, err := backend.Output("wipefs", args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wipefs command failed: %w", err)
+	}
+	var data struct {
+		Signatures []struct {
+			Device string `json:"device"`
+			Offset string `json:"offset"`
+			Type   string `json:"type"`
+			Usage  string `json:"usage"`
+			Label  string `json:"label"`
+			UUID   string `json:"uuid"`
+		} `json:"signatures"`
+	}
+	// wipefs prints nothing at all for some devices without signatures.
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &data); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse wipefs JSON: %w", err)
+		}
+	}
+
+	var sigs []DiskSignature
+	for _, sig := range data.Signatures {
+		start, ok := starts[filepath.Base(sig.Device)]
+		if !ok {
+			return nil, nil, fmt.Errorf("wipefs reported an unexpected device %q", sig.Device)
+		}
+		offset, err := strconv.ParseInt(sig.Offset, 0, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("wipefs reported an invalid offset %q", sig.Offset)
+		}
+		sigs = append(sigs, DiskSignature{Offset: start + offset, Type: sig.Type, Usage: sig.Usage, Label: sig.Label, UUID: sig.UUID})
+	}
+	return sigs, probed, nil
+}
+
+// probeSignatures runs the built-in prober on devicePath alone, as wipefs
+// does, without looking inside its partitions.
+func probeSignatures(devicePath string) ([]DiskSignature, error) {
+	p, file, err := openProber(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sigs, _ := p.partitionTables()
+	return append(sigs, p.contentSignatures(0)...), nil
+}
+
+// openProber opens devicePath for the built-in prober. The caller closes the
+// returned file.
+func openProber(devicePath string) (*prober, *os.File, error) {
+	file, err := os.Open(backend.DevicePath(devicePath))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s for signature scan: %w", devicePath, err)
+	}
+	device, err := deviceExtent(file, devicePath)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return &prober{file: io.NewSectionReader(file, device.offset, device.length), size: device.length}, file, nil
+}
+
+type prober struct {
+	file io.ReaderAt
+	size int64
+}
+
+// read returns n bytes at off, or nil if the range is outside the device.
+func (p *prober) read(off int64, n int) []byte {
+	if off < 0 || off+int64(n) > p.size {
+		return nil
+	}
+	buf := make([]byte, n)
+	if _, err := p.file.ReadAt(buf, off); err != nil {
+		return nil
+	}
+	return buf
+}
+
+func (p *prober) hasMagic(off int64, magic string) bool {
+	b := p.read(off, len(magic))
+	return b != nil && string(b) == magic
+}
+
+// partitionTables finds DOS and GPT tables (including the GPT backup header)
+// and returns the byte offsets of the partitions they describe.
+func (p *prober) partitionTables() ([]DiskSignature, []int64) {
+	var sigs []DiskSignature
+	var starts []int64
+
+	if hdr := p.read(512, 92); hdr != nil && string(hdr[0:8]) == "EFI PART" {
+		sigs = append(sigs, DiskSignature{Offset: 512, Type: "gpt", Usage: "partition table", UUID: guidString(hdr[56:72])})
+		entriesLBA := int64(binary.LittleEndian.Uint64(hdr[72:80]))
+		count := binary.LittleEndian.Uint32(hdr[80:84])
+		entrySize := binary.LittleEndian.Uint32(hdr[84:88])
+		if count > 1024 {
+			count = 1024
+		}
+		for i := uint32(0); entrySize >= 128 && i < count; i++ {
+			entry := p.read(entriesLBA*512+int64(i)*int64(entrySize), 128)
+			if entry == nil || bytes.Equal(entry[0:16], make([]byte, 16)) {
+				continue
+			}
+			starts = append(starts, int64(binary.LittleEndian.Uint64(entry[32:40]))*512)
+		}
+	}
+	if p.size >= 1024 && p.hasMagic(p.size-512, "EFI PART") {
+		sigs = append(sigs, DiskSignature{Offset: p.size - 512, Type: "gpt", Usage: "partition table (backup)"})
+	}
+
+	if mbr := p.read(0, 512); mbr != nil && mbr[510] == 0x55 && mbr[511] == 0xAA {
+		// A protective MBR is part of GPT; a FAT/NTFS boot sector also ends in
+		// 55AA, so only report "dos" when the partition entries look sane.
+		if len(starts) > 0 || mbr[450] == 0xEE {
+			sigs = append(sigs, DiskSignature{Offset: 510, Type: "PMBR", Usage: "partition table"})
+		} else if validDOSTable(mbr) && !p.hasMagic(3, "NTFS    ") && !p.hasMagic(3, "EXFAT   ") {
+			sigs = append(sigs, DiskSignature{Offset: 510, Type: "dos", Usage: "partition table", UUID: fmt.Sprintf("%08x", binary.LittleEndian.Uint32(mbr[440:444]))})
+			for i := 0; i < 4; i++ {
+				entry := mbr[446+i*16 : 462+i*16]
+				if entry[4] != 0 {
+					starts = append(starts, int64(binary.LittleEndian.Uint32(entry[8:12]))*512)
+				}
+			}
+		}
+	}
+
+	return sigs, starts
+}
+
+func validDOSTable(mbr []byte) bool {
+	used := 0
+	for i := 0; i < 4; i++ {
+		entry := mbr[446+i*16 : 462+i*16]
+		if entry[0] != 0x00 && entry[0] != 0x80 {
+			return false
+		}
+		if entry[4] != 0 {
+			used++
+		}
+	}
+	return used > 0
+}
+
+// contentSignatures probes for filesystem and container signatures at base.
+func (p *prober) contentSignatures(base int64) []DiskSignature {
+	var sigs []DiskSignature
+	add := func(off int64, typ, usage, label, uuid string) {
+		sigs = append(sigs, DiskSignature{Offset: base + off, Type: typ, Usage: usage, Label: label, UUID: uuid})
+	}
+
+	if sb := p.read(base+1024, 256); sb != nil && binary.LittleEndian.Uint16(sb[56:58]) == 0xEF53 {
+		typ := "ext2"
+		if binary.LittleEndian.Uint32(sb[92:96])&0x4 != 0 {
+			typ = "ext3"
+		}
+		if binary.LittleEndian.Uint32(sb[96:100])&0x40 != 0 {
+			typ = "ext4"
+		}
+		add(1024+56, typ, "filesystem", cString(sb[120:136]), guidBigEndian(sb[104:120]))
+	}
+	if b := p.read(base, 512); b != nil {
+		switch {
+		case string(b[3:11]) == "NTFS    ":
+			add(3, "ntfs", "filesystem", "", fmt.Sprintf("%016X", binary.LittleEndian.Uint64(b[72:80])))
+		case string(b[3:11]) == "EXFAT   ":
+			add(3, "exfat", "filesystem", "", fmt.Sprintf("%08X", binary.LittleEndian.Uint32(b[100:104])))
+		case string(b[82:87]) == "FAT32":
+			add(82, "vfat", "filesystem", strings.TrimSpace(string(b[71:82])), fmt.Sprintf("%08X", binary.LittleEndian.Uint32(b[67:71])))
+		case string(b[54:59]) == "FAT16" || string(b[54:59]) == "FAT12":
+			add(54, "vfat", "filesystem", strings.TrimSpace(string(b[43:54])), fmt.Sprintf("%08X", binary.LittleEndian.Uint32(b[39:43])))
+		case string(b[0:4]) == "XFSB":
+			add(0, "xfs", "filesystem", cString(b[108:120]), guidBigEndian(b[32:48]))
+		case string(b[0:6]) == "LUKS\xba\xbe":
+			add(0, "crypto_LUKS", "crypto", "", cString(b[168:208]))
+		}
+	}
+	if b := p.read(base+0x10000, 0x200); b != nil && string(b[0x40:0x48]) == "_BHRfS_M" {
+		add(0x10040, "btrfs", "filesystem", cString(b[0x12b:0x22b]), guidBigEndian(b[0x20:0x30]))
+	}
+	if b := p.read(base+512, 32); b != nil && string(b[0:8]) == "LABELONE" && string(b[24:32]) == "LVM2 001" {
+		add(512+24, "LVM2_member", "raid", "", "")
+	}
+	for _, off := range []int64{0, 4096} {
+		if b := p.read(base+off, 48); b != nil && binary.LittleEndian.Uint32(b[0:4]) == 0xa92b4efc {
+			add(off, "linux_raid_member", "raid", "", guidBigEndian(b[16:32]))
+		}
+	}
+	for _, pageSize := range []int64{4096, 8192, 16384, 65536} {
+		if p.hasMagic(base+pageSize-10, "SWAPSPACE2") {
+			info := p.read(base+1024, 64)
+			add(pageSize-10, "swap", "other", cString(info[28:44]), guidBigEndian(info[12:28]))
+			break
+		}
+	}
+	if p.hasMagic(base+32769, "CD001") {
+		add(32769, "iso9660", "filesystem", "", "")
+	}
+	return sigs
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// guidBigEndian formats 16 raw bytes as a UUID string.
+func guidBigEndian(b []byte) string {
+	if bytes.Equal(b, make([]byte, 16)) {
+		return ""
+	}
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// guidString formats a mixed-endian GPT GUID.
+func guidString(b []byte) string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10], b[10:16])
+}
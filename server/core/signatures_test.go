@@ -0,0 +1,77 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// wipefsBackend answers wipefs with a fixed reply.
+type wipefsBackend struct {
+	Backend
+	out []byte
+	err error
+}
+
+func (b wipefsBackend) Output(name string, args ...string) ([]byte, error) {
+	if name == "wipefs" {
+		return b.out, b.err
+	}
+	return b.Backend.Output(name, args...)
+}
+
+func TestScanSignaturesWipefs(t *testing.T) {
+	sim := useSimBackend(t, "../simdata/default")
+	useBackend(t, wipefsBackend{Backend: sim, out: []byte(`{
+   "signatures": [
+      {"device": "sdc", "offset": "0x1fe", "type": "dos", "usage": "partition table", "label": null, "uuid": "3c1a2b7e"},
+      {"device": "sdc1", "offset": "0x52", "type": "vfat", "usage": "filesystem", "label": "STICK", "uuid": "1A2B-3C4D"},
+      {"device": "sdc1", "offset": "0x0", "type": "zfs_member", "usage": "filesystem", "label": "tank", "uuid": null}
+   ]
+}`)})
+
+	scan, err := ScanSignatures("/dev/sdc")
+	if err != nil {
+		t.Fatalf("ScanSignatures: %v", err)
+	}
+	want := []DiskSignature{
+		{Offset: 0x1fe, Type: "dos", Usage: "partition table", UUID: "3c1a2b7e"},
+		{Offset: 1<<20 + 0x52, Type: "vfat", Usage: "filesystem", Label: "STICK", UUID: "1A2B-3C4D"},
+		{Offset: 1 << 20, Type: "zfs_member", Usage: "filesystem", Label: "tank"},
+	}
+	if len(scan.Signatures) != len(want) {
+		t.Fatalf("signatures = %+v, want %+v", scan.Signatures, want)
+	}
+	for i := range want {
+		if scan.Signatures[i] != want[i] {
+			t.Errorf("signature %d = %+v, want %+v", i, scan.Signatures[i], want[i])
+		}
+	}
+	if scan.PartitionTable != "dos" || scan.Clean {
+		t.Errorf("partition table %q, clean %v; want dos and not clean", scan.PartitionTable, scan.Clean)
+	}
+}
+
+func TestScanSignaturesFallback(t *testing.T) {
+	sim := useSimBackend(t, "../simdata/default")
+	useBackend(t, wipefsBackend{Backend: sim, err: errors.New("exec: \"wipefs\": executable file not found in $PATH")})
+
+	// An ext2 superblock inside sdc1, which starts 1 MiB into the disk.
+	f, err := os.OpenFile(sim.DevicePath("/dev/sdc"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte{0x53, 0xef}, 1<<20+1024+56)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan, err := ScanSignatures("/dev/sdc1")
+	if err != nil {
+		t.Fatalf("ScanSignatures: %v", err)
+	}
+	if len(scan.Signatures) != 1 || scan.Signatures[0].Type != "ext2" || scan.Signatures[0].Offset != 1024+56 {
+		t.Errorf("built-in prober found %+v, want ext2 at 0x438", scan.Signatures)
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// AdbServer starts, on first use, a fake adb server on a local port that
+// answers the host protocol for the scenario's phones: host:version,
+// host:devices, host:track-devices, and shell: after host:transport.
+func (s *SimBackend) AdbServer() string {
+	if len(s.scenario.Phones) == 0 {
+		return ""
+	}
+	s.adbOnce.Do(func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			log.Printf("Warning: could not start the simulated adb server: %v", err)
+			return
+		}
+		s.adbAddr = listener.Addr().String()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go s.serveAdb(conn)
+			}
+		}()
+	})
+	return s.adbAddr
+}
+
+func simAdbReply(conn net.Conn, status, msg string) {
+	fmt.Fprintf(conn, "%s%04x%s", status, len(msg), msg)
+}
+
+func (s *SimBackend) serveAdb(conn net.Conn) {
+	defer conn.Close()
+	var phone *simPhone
+	for {
+		request, err := adbReadMessage(conn)
+		if err != nil {
+			return
+		}
+		switch {
+		case request == "host:version":
+			simAdbReply(conn, "OKAY", fmt.Sprintf("%04x", 41))
+			return
+		case request == "host:devices":
+			s.mutex.Lock()
+			list := s.adbDeviceList()
+			s.mutex.Unlock()
+			simAdbReply(conn, "OKAY", list)
+			return
+		case request == "host:track-devices":
+			conn.Write([]byte("OKAY"))
+			s.trackAdb(conn)
+			return
+		case strings.HasPrefix(request, "host:transport:"):
+			serial := strings.TrimPrefix(request, "host:transport:")
+			s.mutex.Lock()
+			phone = s.adbPhone(serial)
+			state := ""
+			if phone != nil {
+				state = phone.state
+			}
+			s.mutex.Unlock()
+			switch {
+			case phone == nil:
+				simAdbReply(conn, "FAIL", fmt.Sprintf("device '%s' not found", serial))
+				return
+			case state != MobileStateDevice && state != "recovery":
+				simAdbReply(conn, "FAIL", "device "+state)
+				return
+			}
+			conn.Write([]byte("OKAY"))
+		case strings.HasPrefix(request, "shell:") && phone != nil:
+			// Like the legacy shell service, output and errors are mixed and
+			// the exit status is lost.
+			s.mutex.Lock()
+			out, _ := s.adbShell(phone, strings.TrimPrefix(request, "shell:"))
+			s.mutex.Unlock()
+			conn.Write([]byte("OKAY"))
+			conn.Write(out)
+			return
+		default:
+			simAdbReply(conn, "FAIL", "unknown host service")
+			return
+		}
+	}
+}
+
+// trackAdb sends the device list now and after every phone state change,
+// until the client hangs up.
+func (s *SimBackend) trackAdb(conn net.Conn) {
+	changed := make(chan struct{}, 1)
+	s.mutex.Lock()
+	if s.adbWatchers == nil {
+		s.adbWatchers = make(map[chan struct{}]bool)
+	}
+	s.adbWatchers[changed] = true
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.adbWatchers, changed)
+		s.mutex.Unlock()
+	}()
+
+	// The client sends nothing more; a read returns when it hangs up.
+	closed := make(chan struct{})
+	go func() {
+		var b [1]byte
+		conn.Read(b[:])
+		close(closed)
+	}()
+
+	for {
+		s.mutex.Lock()
+		list := s.adbDeviceList()
+		s.mutex.Unlock()
+		if _, err := fmt.Fprintf(conn, "%04x%s", len(list), list); err != nil {
+			return
+		}
+		select {
+		case <-changed:
+		case <-closed:
+			return
+		}
+	}
+}
+
+// adbDeviceList lists the phones adb sees, one "serial<TAB>state" per line.
+// Phones in their bootloader are only visible to fastboot. Callers hold
+// s.mutex.
+func (s *SimBackend) adbDeviceList() string {
+	var out strings.Builder
+	for _, p := range s.scenario.Phones {
+		if p.state != "" && p.state != MobileStateFastboot && !p.adbOff {
+			fmt.Fprintf(&out, "%s\t%s\n", p.Serial, p.state)
+		}
+	}
+	return out.String()
+}
+
+// adbPhone finds a phone adb can see. Callers hold s.mutex.
+func (s *SimBackend) adbPhone(serial string) *simPhone {
+	for _, p := range s.scenario.Phones {
+		if p.Serial == serial && p.state != "" && p.state != MobileStateFastboot && !p.adbOff {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,754 @@
+package core
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed simdata
+var simData embed.FS
+
+const simMajor = 259
+
+// simScenario describes a simulated bench: the disks attached to it and the
+// recorded output of every tool invocation DZap makes against them.
+type simScenario struct {
+	Disks    []*simDisk    `json:"disks"`
+	Commands []*simCommand `json:"commands"`
+	// Phones answer adb natively; without them adb is replayed from Commands.
+	Phones []*simPhone `json:"phones,omitempty"`
+}
+
+type simDisk struct {
+	Name    string `json:"name"`
+	SizeMiB int64  `json:"sizeMiB"`
+	// Image is an existing file or loop device to use instead of a fresh sparse image.
+	Image string `json:"image,omitempty"`
+	// Props holds lsblk columns, keyed by their lower-case JSON names.
+	Props map[string]interface{} `json:"props"`
+	// Sysfs holds extra attribute files written under the device's sysfs directory.
+	Sysfs      map[string]string `json:"sysfs,omitempty"`
+	Partitions []*simDisk        `json:"partitions,omitempty"`
+	// StartMiB places a partition in its disk's image. Partitions without one
+	// follow each other from 1 MiB on.
+	StartMiB int64 `json:"startMiB,omitempty"`
+	// Detached disks start unplugged; see SimBackend.Hotplug.
+	Detached bool `json:"detached,omitempty"`
+	// Zones makes the disk a zoned block device; see simZones.
+	Zones *simZones `json:"zones,omitempty"`
+
+	devNum string
+	parent *simDisk
+}
+
+type simCommand struct {
+	Match      string `json:"match"`
+	Stdout     string `json:"stdout,omitempty"`
+	StdoutFile string `json:"stdoutFile,omitempty"`
+	ExitCode   int    `json:"exitCode,omitempty"`
+	DelayMs    int    `json:"delayMs,omitempty"`
+
+	re *regexp.Regexp
+}
+
+type simExitError struct {
+	code int
+	msg  string
+}
+
+func (e *simExitError) Error() string { return e.msg }
+
+func (e *simExitError) ExitCode() int { return e.code }
+
+// SimBackend replays recorded tool output against file-backed disks. lsblk,
+// umount, swapoff and blkzone are answered from the scenario's disk table and
+// adb, fastboot and the adb server socket from its phones; everything else is
+// matched against the scenario's command recordings.
+type SimBackend struct {
+	scenario simScenario
+	fixtures fs.FS
+	workDir  string
+	mutex    sync.Mutex
+	uevents  chan Uevent
+
+	// The simulated adb server; see AdbServer.
+	adbOnce     sync.Once
+	adbAddr     string
+	adbWatchers map[chan struct{}]bool // host:track-devices clients
+
+	usbEnumerations int // last USB device number handed out
+}
+
+// NewSimBackend loads scenarioDir (or the built-in scenario when empty) and
+// creates its disk images, sysfs and procfs trees under workDir.
+func NewSimBackend(scenarioDir, workDir string) (*SimBackend, error) {
+	var fixtures fs.FS
+	if scenarioDir == "" {
+		sub, err := fs.Sub(simData, "simdata/default")
+		if err != nil {
+			return nil, err
+		}
+		fixtures = sub
+	} else {
+		fixtures = os.DirFS(scenarioDir)
+	}
+
+	raw, err := fs.ReadFile(fixtures, "scenario.json")
+	if err != nil {
+		return nil, fmt.Errorf("could not read scenario: %w", err)
+	}
+
+	sim := &SimBackend{fixtures: fixtures, workDir: workDir, uevents: make(chan Uevent, 64)}
+	if err := json.Unmarshal(raw, &sim.scenario); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario: %w", err)
+	}
+	for _, c := range sim.scenario.Commands {
+		if c.re, err = regexp.Compile(c.Match); err != nil {
+			return nil, fmt.Errorf("invalid command pattern %q: %w", c.Match, err)
+		}
+	}
+	for _, p := range sim.scenario.Phones {
+		p.state = MobileStateDevice
+		if p.Fastboot != nil {
+			p.state = MobileStateFastboot
+		}
+		sim.usbEnumerations++
+		p.usbDevnum = sim.usbEnumerations
+	}
+
+	if err := sim.createImages(); err != nil {
+		return nil, err
+	}
+	if err := sim.writeSysfs(); err != nil {
+		return nil, err
+	}
+	if err := sim.writeProc(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Simulated backend ready with %d disks in %s", len(sim.scenario.Disks), workDir)
+	return sim, nil
+}
+
+func (s *SimBackend) Name() string { return "sim" }
+
+func (s *SimBackend) SysfsRoot() string { return filepath.Join(s.workDir, "sys") }
+
+func (s *SimBackend) ProcRoot() string { return filepath.Join(s.workDir, "proc") }
+
+// LookPath reports a tool as installed if the scenario has recordings for it.
+func (s *SimBackend) LookPath(name string) (string, error) {
+	if name == "lsblk" || name == "umount" || name == "swapoff" || name == "blkzone" || name == "wipefs" {
+		return "(simulated) " + name, nil
+	}
+	if (name == "adb" || name == "fastboot") && len(s.scenario.Phones) > 0 {
+		return "(simulated) " + name, nil
+	}
+	for _, c := range s.scenario.Commands {
+		if strings.HasPrefix(c.Match, "^"+regexp.QuoteMeta(name)+" ") {
+			return "(simulated) " + name, nil
+		}
+	}
+	return "", &simExitError{code: 127, msg: name + ": not recorded in this scenario"}
+}
+
+// Uevents delivers the events generated by Hotplug.
+func (s *SimBackend) Uevents() (<-chan Uevent, error) {
+	return s.uevents, nil
+}
+
+// Hotplug attaches ("add") or detaches ("remove") a scenario disk and emits the
+// uevents the kernel would send for it and its partitions.
+func (s *SimBackend) Hotplug(name, action string) error {
+	s.mutex.Lock()
+	var disk *simDisk
+	for _, d := range s.scenario.Disks {
+		if d.Name == strings.TrimPrefix(name, "/dev/") {
+			disk = d
+		}
+	}
+	switch {
+	case disk == nil:
+		s.mutex.Unlock()
+		return fmt.Errorf("no disk %s in this scenario", name)
+	case action != "add" && action != "remove":
+		s.mutex.Unlock()
+		return fmt.Errorf("unknown hot-plug action %q (expected add or remove)", action)
+	case disk.Detached == (action == "remove"):
+		s.mutex.Unlock()
+		if disk.Detached {
+			return fmt.Errorf("disk %s is already detached", disk.Name)
+		}
+		return fmt.Errorf("disk %s is already attached", disk.Name)
+	}
+	disk.Detached = action == "remove"
+	err := s.writeSysfs()
+	if err == nil {
+		err = s.writeProc()
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	entries := append([]*simDisk{disk}, disk.Partitions...)
+	if action == "remove" {
+		// The kernel removes partitions before their disk.
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	for _, e := range entries {
+		devType, devPath := "disk", "/devices/sim/block/"+disk.Name
+		if e != disk {
+			devType, devPath = "partition", devPath+"/"+e.Name
+		}
+		s.uevents <- Uevent{
+			Action:    action,
+			DevPath:   devPath,
+			Subsystem: "block",
+			DevType:   devType,
+			DevName:   e.Name,
+			Env:       map[string]string{"MAJOR": fmt.Sprint(simMajor)},
+		}
+	}
+	log.Printf("Simulated %s of %s", action, disk.Name)
+	return nil
+}
+
+// SimHotplug plugs or unplugs a disk on the simulated backend.
+func SimHotplug(name, action string) error {
+	sim, ok := backend.(recordingBackend).Backend.(*SimBackend)
+	if !ok {
+		return fmt.Errorf("hot-plug simulation needs the sim backend")
+	}
+	return sim.Hotplug(name, action)
+}
+
+func (s *SimBackend) DevicePath(path string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if d := s.find(path); d != nil {
+		if d.parent != nil {
+			d = d.parent
+		}
+		return s.imagePath(d)
+	}
+	return path
+}
+
+// DeviceRange backs each partition with its own range of the disk's image.
+func (s *SimBackend) DeviceRange(path string) (int64, int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if d := s.find(path); d != nil && d.parent != nil {
+		return d.StartMiB << 20, d.size()
+	}
+	return 0, 0
+}
+
+func (s *SimBackend) Output(name string, args ...string) ([]byte, error) {
+	return s.run(context.Background(), name, args)
+}
+
+func (s *SimBackend) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return s.run(ctx, name, args)
+}
+
+func (s *SimBackend) StreamOutput(ctx context.Context, onLine func(string), name string, args ...string) error {
+	out, err := s.replay(ctx, name, args, onLine)
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if line != "" {
+			onLine(line)
+		}
+	}
+	return err
+}
+
+func (s *SimBackend) run(ctx context.Context, name string, args []string) ([]byte, error) {
+	return s.replay(ctx, name, args, nil)
+}
+
+// replay answers a command from the scenario. With onLine set, the recorded
+// output is streamed line by line with the delay spread across the lines, and
+// only output from the native tools is returned.
+func (s *SimBackend) replay(ctx context.Context, name string, args []string, onLine func(string)) ([]byte, error) {
+	// runCommand wraps tools in "ionice -c 3"; match on the tool itself.
+	if name == "ionice" && len(args) >= 3 && args[0] == "-c" {
+		name, args = args[2], args[3:]
+	}
+
+	switch name {
+	case "lsblk":
+		return s.lsblk(args)
+	case "umount":
+		return s.umount(args)
+	case "swapoff":
+		return s.swapoff(args)
+	case "blkzone":
+		return s.blkzone(args)
+	case "wipefs":
+		return s.wipefs(args)
+	case "adb":
+		if len(s.scenario.Phones) > 0 {
+			return s.adb(args)
+		}
+	case "fastboot":
+		if len(s.scenario.Phones) > 0 {
+			return s.fastboot(args)
+		}
+	}
+
+	line := strings.Join(append([]string{name}, args...), " ")
+	for _, c := range s.scenario.Commands {
+		if !c.re.MatchString(line) {
+			continue
+		}
+		out := []byte(c.Stdout)
+		if c.StdoutFile != "" {
+			var err error
+			if out, err = fs.ReadFile(s.fixtures, c.StdoutFile); err != nil {
+				return nil, fmt.Errorf("missing recording %s: %w", c.StdoutFile, err)
+			}
+		}
+		if onLine == nil {
+			if err := simDelay(ctx, c.DelayMs); err != nil {
+				return nil, err
+			}
+		} else {
+			lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+			for _, l := range lines {
+				if err := simDelay(ctx, c.DelayMs/len(lines)); err != nil {
+					return nil, err
+				}
+				onLine(l)
+			}
+			out = nil
+		}
+		if c.ExitCode != 0 {
+			return out, &simExitError{code: c.ExitCode, msg: fmt.Sprintf("exit status %d", c.ExitCode)}
+		}
+		return out, nil
+	}
+	return nil, &simExitError{code: 127, msg: "simulated backend has no recording for: " + line}
+}
+
+func simDelay(ctx context.Context, ms int) error {
+	if ms <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		return nil
+	}
+}
+
+// find looks up a disk or partition by name or /dev path. Callers hold s.mutex.
+func (s *SimBackend) find(path string) *simDisk {
+	name := strings.TrimPrefix(path, "/dev/")
+	for _, d := range s.scenario.Disks {
+		if d.Detached {
+			continue
+		}
+		if d.Name == name {
+			return d
+		}
+		for _, p := range d.Partitions {
+			if p.Name == name {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SimBackend) imagePath(d *simDisk) string {
+	if d.Image != "" {
+		return d.Image
+	}
+	return filepath.Join(s.workDir, "disks", d.Name+".img")
+}
+
+func (s *SimBackend) createImages() error {
+	if err := os.MkdirAll(filepath.Join(s.workDir, "disks"), 0755); err != nil {
+		return fmt.Errorf("failed to create simulated disk directory: %w", err)
+	}
+	for _, d := range s.scenario.Disks {
+		next := int64(1)
+		for _, e := range append([]*simDisk{d}, d.Partitions...) {
+			if e.Props == nil {
+				e.Props = make(map[string]interface{})
+			}
+			if e == d {
+				continue
+			}
+			e.parent = d
+			if e.StartMiB == 0 {
+				e.StartMiB = next
+			}
+			next = e.StartMiB + e.SizeMiB
+			if next > d.SizeMiB {
+				return fmt.Errorf("partition %s does not fit on %s", e.Name, d.Name)
+			}
+		}
+		if d.Image != "" {
+			continue
+		}
+		file, err := os.OpenFile(s.imagePath(d), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create image for %s: %w", d.Name, err)
+		}
+		err = file.Truncate(d.SizeMiB << 20)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to size image for %s: %w", d.Name, err)
+		}
+	}
+	return nil
+}
+
+func (d *simDisk) size() int64 {
+	return d.SizeMiB << 20
+}
+
+// writeSysfs lays out the subset of /sys that detection and the system disk
+// protection read: class/block, dev/block and per-device attributes.
+func (s *SimBackend) writeSysfs() error {
+	root := s.SysfsRoot()
+	if err := os.RemoveAll(root); err != nil {
+		return err
+	}
+
+	minor := 0
+	for _, d := range s.scenario.Disks {
+		if d.Detached {
+			// Keep device numbers stable across hot-plugs.
+			minor += 1 + len(d.Partitions)
+			continue
+		}
+		devDir := filepath.Join(root, "devices", "sim", "block", d.Name)
+		for i, e := range append([]*simDisk{d}, d.Partitions...) {
+			dir := devDir
+			if e != d {
+				dir = filepath.Join(devDir, e.Name)
+			}
+			e.devNum = fmt.Sprintf("%d:%d", simMajor, minor)
+			minor++
+
+			attrs := map[string]string{
+				"dev":  e.devNum,
+				"size": fmt.Sprint(e.size() / 512),
+			}
+			if e == d {
+				rotational := "0"
+				if rota, _ := d.Props["rota"].(bool); rota {
+					rotational = "1"
+				}
+				attrs["queue/rotational"] = rotational
+				if d.Zones != nil {
+					for k, v := range d.Zones.sysfs(d) {
+						attrs[k] = v
+					}
+				}
+				os.MkdirAll(filepath.Join(dir, "slaves"), 0755)
+				os.MkdirAll(filepath.Join(dir, "holders"), 0755)
+			} else {
+				attrs["partition"] = fmt.Sprint(i)
+				attrs["start"] = fmt.Sprint(e.StartMiB << 20 / 512)
+			}
+			for k, v := range e.Sysfs {
+				attrs[k] = v
+			}
+			for k, v := range attrs {
+				path := filepath.Join(dir, k)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					return err
+				}
+				if err := os.WriteFile(path, []byte(v+"\n"), 0644); err != nil {
+					return err
+				}
+			}
+
+			links := map[string]string{
+				filepath.Join(root, "class", "block", e.Name): dir,
+				filepath.Join(root, "dev", "block", e.devNum): dir,
+			}
+			if e == d {
+				links[filepath.Join(root, "block", e.Name)] = dir
+			}
+			for link, target := range links {
+				if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+					return err
+				}
+				rel, _ := filepath.Rel(filepath.Dir(link), target)
+				if err := os.Symlink(rel, link); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return s.writeUSBSysfs()
+}
+
+// writeProc writes mountinfo and swaps from the disks' mountpoints.
+func (s *SimBackend) writeProc() error {
+	root := s.ProcRoot()
+	if err := os.MkdirAll(filepath.Join(root, "self"), 0755); err != nil {
+		return err
+	}
+
+	var mountinfo strings.Builder
+	swaps := "Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n"
+	id := 100
+	for _, d := range s.scenario.Disks {
+		if d.Detached {
+			continue
+		}
+		for _, e := range append([]*simDisk{d}, d.Partitions...) {
+			for _, mp := range e.mountpoints() {
+				if mp == "[SWAP]" {
+					swaps += fmt.Sprintf("/dev/%s\t\t\t\tpartition\t%d\t\t0\t\t-2\n", e.Name, e.size()/1024)
+					continue
+				}
+				fstype, _ := e.Props["fstype"].(string)
+				fmt.Fprintf(&mountinfo, "%d 1 %s / %s rw,relatime - %s /dev/%s rw\n", id, e.devNum, mp, fstype, e.Name)
+				id++
+			}
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "self", "mountinfo"), []byte(mountinfo.String()), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(root, "swaps"), []byte(swaps), 0644)
+}
+
+func (d *simDisk) mountpoints() []string {
+	raw, _ := d.Props["mountpoints"].([]interface{})
+	var mps []string
+	for _, mp := range raw {
+		if s, ok := mp.(string); ok && s != "" {
+			mps = append(mps, s)
+		}
+	}
+	return mps
+}
+
+// lsblk answers -J queries with the requested columns from the disk table.
+func (s *SimBackend) lsblk(args []string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var columns []string
+	var targets []string
+	bytes, noDeps := false, false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-b":
+			bytes = true
+		case "-d":
+			noDeps = true
+		case "-o":
+			if i+1 < len(args) {
+				columns = strings.Split(strings.ToLower(args[i+1]), ",")
+				i++
+			}
+		default:
+			if !strings.HasPrefix(args[i], "-") {
+				targets = append(targets, args[i])
+			}
+		}
+	}
+
+	render := func(d *simDisk) map[string]interface{} {
+		row := make(map[string]interface{})
+		for _, col := range columns {
+			switch col {
+			case "name":
+				row[col] = d.Name
+			case "size":
+				if bytes {
+					row[col] = d.size()
+				} else {
+					row[col] = fmt.Sprintf("%dM", d.SizeMiB)
+				}
+			case "type":
+				if t, ok := d.Props[col].(string); ok {
+					row[col] = t
+				} else if d.parent != nil {
+					row[col] = "part"
+				} else {
+					row[col] = "disk"
+				}
+			case "mountpoints":
+				mps := make([]interface{}, 0)
+				for _, mp := range d.mountpoints() {
+					mps = append(mps, mp)
+				}
+				if len(mps) == 0 {
+					mps = append(mps, nil)
+				}
+				row[col] = mps
+			default:
+				row[col] = d.Props[col]
+			}
+		}
+		return row
+	}
+
+	var roots []*simDisk
+	for _, d := range s.scenario.Disks {
+		if !d.Detached {
+			roots = append(roots, d)
+		}
+	}
+	if len(targets) > 0 {
+		roots = nil
+		for _, target := range targets {
+			d := s.find(target)
+			if d == nil {
+				return nil, &simExitError{code: 32, msg: fmt.Sprintf("lsblk: %s: not a block device", target)}
+			}
+			roots = append(roots, d)
+		}
+	}
+
+	devices := make([]interface{}, 0, len(roots))
+	for _, d := range roots {
+		row := render(d)
+		if !noDeps && len(d.Partitions) > 0 {
+			var children []interface{}
+			for _, p := range d.Partitions {
+				children = append(children, render(p))
+			}
+			row["children"] = children
+		}
+		devices = append(devices, row)
+	}
+
+	return json.MarshalIndent(map[string]interface{}{"blockdevices": devices}, "", "   ")
+}
+
+// umount clears a mountpoint from the disk table and rewrites mountinfo.
+func (s *SimBackend) umount(args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, &simExitError{code: 1, msg: "umount: bad usage"}
+	}
+	target := args[len(args)-1]
+
+	s.mutex.Lock()
+	found := false
+	for _, d := range s.scenario.Disks {
+		for _, e := range append([]*simDisk{d}, d.Partitions...) {
+			var kept []interface{}
+			for _, mp := range e.mountpoints() {
+				if mp == target || "/dev/"+e.Name == target {
+					found = true
+					continue
+				}
+				kept = append(kept, mp)
+			}
+			e.Props["mountpoints"] = kept
+		}
+	}
+	s.mutex.Unlock()
+
+	if !found {
+		return []byte("umount: " + target + ": not mounted.\n"), &simExitError{code: 32, msg: "exit status 32"}
+	}
+	return nil, s.writeProc()
+}
+
+// swapoff removes a partition's [SWAP] entry and rewrites /proc/swaps.
+func (s *SimBackend) swapoff(args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, &simExitError{code: 1, msg: "swapoff: bad usage"}
+	}
+	target := args[len(args)-1]
+
+	s.mutex.Lock()
+	found := false
+	for _, d := range s.scenario.Disks {
+		for _, e := range append([]*simDisk{d}, d.Partitions...) {
+			if "/dev/"+e.Name != target {
+				continue
+			}
+			var kept []interface{}
+			for _, mp := range e.mountpoints() {
+				if mp == "[SWAP]" {
+					found = true
+					continue
+				}
+				kept = append(kept, mp)
+			}
+			e.Props["mountpoints"] = kept
+		}
+	}
+	s.mutex.Unlock()
+
+	if !found {
+		return []byte("swapoff: " + target + ": swapoff failed: Invalid argument\n"), &simExitError{code: 255, msg: "exit status 255"}
+	}
+	return nil, s.writeProc()
+}
+
+// wipefs answers "wipefs --no-act --json" with the built-in prober, one device
+// at a time and without looking inside partitions, as wipefs does.
+func (s *SimBackend) wipefs(args []string) ([]byte, error) {
+	var targets []string
+	noAct := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--no-act", "-n":
+			noAct = true
+		case "--output", "-O":
+			i++
+		default:
+			if !strings.HasPrefix(args[i], "-") {
+				targets = append(targets, args[i])
+			}
+		}
+	}
+	if !noAct {
+		return nil, &simExitError{code: 1, msg: "wipefs: the simulator only probes (--no-act)"}
+	}
+
+	rows := make([]interface{}, 0)
+	for _, target := range targets {
+		s.mutex.Lock()
+		missing := strings.HasPrefix(target, "/dev/") && s.find(target) == nil
+		s.mutex.Unlock()
+		if missing {
+			return nil, &simExitError{code: 1, msg: fmt.Sprintf("wipefs: error: %s: probing initialization failed: No such file or directory", target)}
+		}
+		sigs, err := probeSignatures(target)
+		if err != nil {
+			return nil, &simExitError{code: 1, msg: "wipefs: " + err.Error()}
+		}
+		for _, sig := range sigs {
+			row := map[string]interface{}{"device": filepath.Base(target), "offset": fmt.Sprintf("%#x", sig.Offset), "type": sig.Type, "usage": sig.Usage, "label": nil, "uuid": nil}
+			if sig.Label != "" {
+				row["label"] = sig.Label
+			}
+			if sig.UUID != "" {
+				row["uuid"] = sig.UUID
+			}
+			rows = append(rows, row)
+		}
+	}
+	return json.MarshalIndent(map[string]interface{}{"signatures": rows}, "", "   ")
+}
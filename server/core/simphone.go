@@ -0,0 +1,376 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// simPhone is an Android device answering adb. A factory reset takes it
+// through the same disconnect, recovery and reconnect sequence as a real
+// phone and brings it back in setup state.
+type simPhone struct {
+	Serial string `json:"serial"`
+	// Props answers getprop. "ro.debuggable": "1" lets adbd run as root.
+	Props map[string]string `json:"props"`
+	// Settings answers "settings get", keyed "<namespace>/<key>".
+	Settings map[string]string `json:"settings,omitempty"`
+	// DeviceOwner is the component of a device-owner DPC that honours the
+	// DZap wipe broadcast, unless OrdinaryOwner makes it an MDM app that
+	// ignores it.
+	DeviceOwner   string `json:"deviceOwner,omitempty"`
+	OrdinaryOwner bool   `json:"ordinaryOwner,omitempty"`
+	// GoogleAccounts is the number of Google accounts "dumpsys account"
+	// lists. A factory reset removes them.
+	GoogleAccounts int `json:"googleAccounts,omitempty"`
+	// Fastboot starts the phone in its bootloader, where it answers fastboot
+	// instead of adb.
+	Fastboot *simBootloader `json:"fastboot,omitempty"`
+	// NoCache models A/B devices without a /cache partition.
+	NoCache bool `json:"noCache,omitempty"`
+	// ResetSeconds is how long a factory reset keeps the phone away.
+	ResetSeconds int `json:"resetSeconds,omitempty"`
+
+	state       string // adb state; "" while the phone is not visible
+	root        bool   // adbd runs as root
+	wipeCommand bool   // /cache/recovery/command holds --wipe_data
+	// adbOff hides the phone from adb while it stays on USB, as after a
+	// factory reset, which turns USB debugging off.
+	adbOff bool
+	// usbDevnum is the USB device number while the phone is on the bus. It
+	// changes with every enumeration; emulators are never on USB.
+	usbDevnum int
+}
+
+// simBootloader is a phone's bootloader as seen through fastboot.
+type simBootloader struct {
+	Product  string `json:"product"`
+	Unlocked bool   `json:"unlocked"`
+	// Partitions maps partition names to filesystem types, e.g.
+	// "userdata": "f2fs".
+	Partitions map[string]string `json:"partitions"`
+}
+
+func (p *simPhone) resetDuration() time.Duration {
+	if p.ResetSeconds > 0 {
+		return time.Duration(p.ResetSeconds) * time.Second
+	}
+	return 10 * time.Second
+}
+
+func simAdbError(msg string, code int) ([]byte, error) {
+	return []byte(msg + "\n"), &simExitError{code: code, msg: fmt.Sprintf("exit status %d", code)}
+}
+
+// adb answers the adb commands DZap uses from the scenario's phones.
+func (s *SimBackend) adb(args []string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case len(args) == 1 && args[0] == "devices":
+		return []byte("List of devices attached\n" + s.adbDeviceList() + "\n"), nil
+	case len(args) == 1 && args[0] == "start-server":
+		s.AdbServer()
+		return nil, nil
+	case len(args) < 3 || args[0] != "-s":
+		return simAdbError("adb: usage: unsupported command "+strings.Join(args, " "), 1)
+	}
+
+	phone := s.adbPhone(args[1])
+	if phone == nil {
+		return simAdbError(fmt.Sprintf("adb: device '%s' not found", args[1]), 1)
+	}
+	if phone.state != "device" && phone.state != "recovery" {
+		return simAdbError("adb: device "+phone.state, 1)
+	}
+
+	switch args[2] {
+	case "wait-for-device":
+		return nil, nil
+	case "root":
+		if phone.Props["ro.debuggable"] != "1" {
+			return simAdbError("adbd cannot run as root in production builds", 1)
+		}
+		phone.root = true
+		return []byte("restarting adbd as root\n"), nil
+	case "reboot":
+		if len(args) == 4 && args[3] == "recovery" {
+			s.rebootPhone(phone, phone.wipeCommand)
+			return nil, nil
+		}
+		s.rebootPhone(phone, false)
+		return nil, nil
+	case "shell":
+		return s.adbShell(phone, strings.Join(args[3:], " "))
+	}
+	return simAdbError("adb: unknown command "+args[2], 1)
+}
+
+// fastboot answers fastboot for phones in their bootloader, with fastboot's
+// habit of reporting on stderr.
+func (s *SimBackend) fastboot(args []string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(args) == 1 && args[0] == "devices" {
+		var out strings.Builder
+		for _, p := range s.scenario.Phones {
+			if p.state == MobileStateFastboot {
+				fmt.Fprintf(&out, "%s\tfastboot\n", p.Serial)
+			}
+		}
+		return []byte(out.String()), nil
+	}
+	if len(args) < 3 || args[0] != "-s" {
+		return simAdbError("fastboot: usage: unsupported command "+strings.Join(args, " "), 1)
+	}
+	var phone *simPhone
+	for _, p := range s.scenario.Phones {
+		if p.Serial == args[1] && p.state == MobileStateFastboot {
+			phone = p
+		}
+	}
+	if phone == nil {
+		// fastboot waits for a device that is not there.
+		return simAdbError("< waiting for "+args[1]+" >", 1)
+	}
+	bl := phone.Fastboot
+
+	erase := func(partition string) string {
+		switch {
+		case !bl.Unlocked:
+			return fmt.Sprintf("Erasing '%s'  FAILED (remote: 'Erase is not allowed in Lock State')\n", partition)
+		case bl.Partitions[partition] == "":
+			return fmt.Sprintf("Erasing '%s'  FAILED (remote: 'Partition not found')\n", partition)
+		}
+		log.Printf("Simulated fastboot erase of %s on %s", partition, phone.Serial)
+		return fmt.Sprintf("Erasing '%s'  OKAY [  0.052s]\n", partition)
+	}
+	finish := func(out string) ([]byte, error) {
+		if strings.Contains(out, "FAILED") {
+			return simAdbError(out+"fastboot: error: Command failed", 1)
+		}
+		return []byte(out + "Finished. Total time: 0.061s\n"), nil
+	}
+
+	switch {
+	case len(args) == 4 && args[2] == "getvar" && args[3] == "all":
+		var out strings.Builder
+		unlocked := "no"
+		if bl.Unlocked {
+			unlocked = "yes"
+		}
+		fmt.Fprintf(&out, "(bootloader) product:%s\n(bootloader) version-bootloader:%s-1.2-9876543\n", bl.Product, bl.Product)
+		fmt.Fprintf(&out, "(bootloader) unlocked:%s\n(bootloader) is-userspace:no\n", unlocked)
+		names := make([]string, 0, len(bl.Partitions))
+		for name := range bl.Partitions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&out, "(bootloader) partition-type:%s:%s\n", name, bl.Partitions[name])
+		}
+		return []byte(out.String() + "all:\nFinished. Total time: 0.020s\n"), nil
+	case len(args) == 4 && args[2] == "erase":
+		return finish(erase(args[3]))
+	case len(args) == 3 && args[2] == "-w":
+		var out string
+		for _, p := range []string{"userdata", "cache", "metadata"} {
+			if bl.Partitions[p] != "" {
+				out += erase(p)
+			}
+		}
+		return finish(out)
+	}
+	return simAdbError("fastboot: usage: unknown command "+strings.Join(args[2:], " "), 1)
+}
+
+// adbShell runs a shell command on a phone. Callers hold s.mutex.
+func (s *SimBackend) adbShell(phone *simPhone, cmd string) ([]byte, error) {
+	fields := strings.Fields(cmd)
+	switch {
+	case len(fields) == 2 && fields[0] == "getprop":
+		return []byte(phone.Props[fields[1]] + "\n"), nil
+	case len(fields) == 1 && fields[0] == "getprop":
+		keys := make([]string, 0, len(phone.Props))
+		for k := range phone.Props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var out strings.Builder
+		for _, k := range keys {
+			fmt.Fprintf(&out, "[%s]: [%s]\n", k, phone.Props[k])
+		}
+		return []byte(out.String()), nil
+	case len(fields) == 4 && fields[0] == "settings" && fields[1] == "get":
+		if v, ok := phone.Settings[fields[2]+"/"+fields[3]]; ok {
+			return []byte(v + "\n"), nil
+		}
+		return []byte("null\n"), nil
+	case cmd == "id -u":
+		if phone.root {
+			return []byte("0\n"), nil
+		}
+		return []byte("2000\n"), nil
+	case cmd == "dpm list-owners":
+		if phone.DeviceOwner == "" {
+			return []byte("no owners\n"), nil
+		}
+		return []byte(fmt.Sprintf("1 owner:\nUser  0: admin=%s,DeviceOwner\n", phone.DeviceOwner)), nil
+	case cmd == "dumpsys account":
+		var out strings.Builder
+		fmt.Fprintf(&out, "User UserInfo{0:Owner:c13}:\n  Accounts: %d\n", phone.GoogleAccounts)
+		for i := 0; i < phone.GoogleAccounts; i++ {
+			fmt.Fprintf(&out, "    Account {name=user%d@example.com, type=com.google}\n", i+1)
+		}
+		return []byte(out.String()), nil
+	case cmd == "test -d /cache/recovery":
+		if phone.NoCache {
+			return nil, &simExitError{code: 1, msg: "exit status 1"}
+		}
+		return nil, nil
+	case strings.HasPrefix(cmd, "echo --wipe_data"):
+		if !phone.root || phone.NoCache {
+			return simAdbError("/system/bin/sh: can't create /cache/recovery/command: Permission denied", 1)
+		}
+		phone.wipeCommand = true
+		return nil, nil
+	case len(fields) > 2 && fields[0] == "am" && fields[1] == "broadcast":
+		return s.adbBroadcast(phone, fields[2:])
+	}
+	return simAdbError("/system/bin/sh: "+fields[0]+": inaccessible or not found", 127)
+}
+
+// adbBroadcast honours the DZap wipe broadcast to the device owner and, as
+// root, the framework's factory reset broadcast. Callers hold s.mutex.
+func (s *SimBackend) adbBroadcast(phone *simPhone, args []string) ([]byte, error) {
+	var action, component string
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-a":
+			action = args[i+1]
+		case "-n":
+			component = args[i+1]
+		}
+	}
+	out := fmt.Sprintf("Broadcasting: Intent { act=%s flg=0x400000 }\n", action)
+	switch {
+	case action == androidWipeAction && component != "" && component == phone.DeviceOwner && !phone.OrdinaryOwner:
+		s.rebootPhone(phone, true)
+		return []byte(out + "Broadcast completed: result=-1\n"), nil
+	case action == androidFactoryResetAction && !phone.root:
+		return simAdbError("Security exception: Permission Denial: not allowed to send broadcast "+action+" from pid=4242, uid=2000", 255)
+	case action == androidFactoryResetAction:
+		s.rebootPhone(phone, true)
+	}
+	return []byte(out + "Broadcast completed: result=0\n"), nil
+}
+
+// rebootPhone takes the phone off the bus and brings it back after the
+// reset duration, through recovery. With wipe set the phone comes back
+// factory reset, otherwise unchanged. Callers hold s.mutex.
+func (s *SimBackend) rebootPhone(phone *simPhone, wipe bool) {
+	log.Printf("Simulated reboot of %s (wipe: %v)", phone.Serial, wipe)
+	d := phone.resetDuration()
+	s.setPhoneState(phone, "")
+	time.AfterFunc(2*time.Second, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.setPhoneState(phone, "recovery")
+	})
+	time.AfterFunc(d-2*time.Second, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.setPhoneState(phone, "")
+	})
+	time.AfterFunc(d, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		phone.root, phone.wipeCommand = false, false
+		if wipe {
+			if phone.Settings == nil {
+				phone.Settings = make(map[string]string)
+			}
+			phone.Settings["secure/user_setup_complete"] = "0"
+			phone.Settings["global/device_provisioned"] = "0"
+			phone.DeviceOwner = ""
+			// Production builds come back with USB debugging off.
+			phone.adbOff = phone.Props["ro.debuggable"] != "1"
+		}
+		s.setPhoneState(phone, "device")
+	})
+}
+
+// writeUSBSysfs lists the phones on the bus under bus/usb/devices, with the
+// serial number and device number the kernel exposes. Callers hold s.mutex.
+func (s *SimBackend) writeUSBSysfs() error {
+	root := filepath.Join(s.SysfsRoot(), "bus", "usb", "devices")
+	for i, phone := range s.scenario.Phones {
+		dir := filepath.Join(root, fmt.Sprintf("1-%d", i+1))
+		if phone.usbDevnum == 0 || strings.HasPrefix(phone.Serial, "emulator-") {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			continue
+		}
+		serial := phone.Serial
+		if s := phone.Props["ro.serialno"]; s != "" {
+			serial = s
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		attrs := map[string]string{"serial": serial, "busnum": "1", "devnum": fmt.Sprint(phone.usbDevnum)}
+		for name, value := range attrs {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setPhoneState changes the adb state and emits the USB uevent a real phone
+// would cause. Callers hold s.mutex.
+func (s *SimBackend) setPhoneState(phone *simPhone, state string) {
+	action := "change"
+	switch {
+	case phone.state == "" && state != "":
+		action = "add"
+	case phone.state != "" && state == "":
+		action = "remove"
+	}
+	phone.state = state
+	switch action {
+	case "add":
+		s.usbEnumerations++
+		phone.usbDevnum = s.usbEnumerations
+	case "remove":
+		phone.usbDevnum = 0
+	}
+	if err := s.writeUSBSysfs(); err != nil {
+		log.Printf("Warning: could not update the simulated USB devices: %v", err)
+	}
+	for changed := range s.adbWatchers {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	event := Uevent{
+		Action:    action,
+		DevPath:   "/devices/sim/usb/" + phone.Serial,
+		Subsystem: "usb",
+		DevType:   "usb_device",
+		Env:       map[string]string{},
+	}
+	select {
+	case s.uevents <- event:
+	default:
+	}
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// simZones turns a scenario disk into a zoned block device. Zones are laid
+// out back to back from the start of the disk.
+type simZones struct {
+	Model   string `json:"model"` // "host-managed" or "host-aware"
+	SizeMiB int64  `json:"sizeMiB"`
+	// CapacityMiB is the writable part of each zone, smaller than the zone on
+	// ZNS drives. It defaults to the zone size.
+	CapacityMiB int64 `json:"capacityMiB,omitempty"`
+	// Conventional is the number of leading zones that allow random writes.
+	Conventional int `json:"conventional,omitempty"`
+
+	// wp holds each zone's write pointer in bytes from the zone start.
+	// Sequential zones start full, as if the disk held data.
+	wp []int64
+}
+
+func (z *simZones) count(d *simDisk) int {
+	return int(d.SizeMiB / z.SizeMiB)
+}
+
+func (z *simZones) capacity() int64 {
+	if z.CapacityMiB > 0 {
+		return z.CapacityMiB << 20
+	}
+	return z.SizeMiB << 20
+}
+
+// init fills in the write pointers the first time the disk is used.
+func (z *simZones) init(d *simDisk) {
+	if z.wp != nil {
+		return
+	}
+	z.wp = make([]int64, z.count(d))
+	for i := z.Conventional; i < len(z.wp); i++ {
+		z.wp[i] = z.capacity()
+	}
+}
+
+// sysfs returns the queue attributes the kernel exposes for zoned devices.
+func (z *simZones) sysfs(d *simDisk) map[string]string {
+	return map[string]string{
+		"queue/zoned":         z.Model,
+		"queue/chunk_sectors": fmt.Sprint((z.SizeMiB << 20) / 512),
+		"queue/nr_zones":      fmt.Sprint(z.count(d)),
+	}
+}
+
+// ResetZones resets the zone state of the disk file was opened from.
+func (s *SimBackend) ResetZones(file *os.File, path string) error {
+	if file.Name() != s.DevicePath(path) {
+		return fmt.Errorf("%s is not open on %s", path, file.Name())
+	}
+	_, err := s.blkzone([]string{"reset", path})
+	return err
+}
+
+// ZoneWriter puts the zone rules in front of writes to a simulated zoned disk.
+func (s *SimBackend) ZoneWriter(file *os.File, path string) DeviceWriter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.find(path)
+	if d == nil || d.Zones == nil || file.Name() != s.imagePath(d) {
+		return file
+	}
+	return &simZoneWriter{sim: s, file: file, disk: d}
+}
+
+// simZoneWriter accepts writes the way a zoned drive does. On host-managed
+// drives a write to a sequential zone must start at its write pointer and
+// stay within its capacity, and no write may cross from one zone into a
+// sequential one; anything else fails with EIO, as the kernel reports it.
+// Host-aware drives accept any write. Writes to sequential zones advance the
+// write pointer.
+type simZoneWriter struct {
+	sim  *SimBackend
+	file *os.File
+	disk *simDisk
+}
+
+func (w *simZoneWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.sim.mutex.Lock()
+	defer w.sim.mutex.Unlock()
+	z := w.disk.Zones
+	z.init(w.disk)
+
+	size := z.SizeMiB << 20
+	first, last := int(off/size), int((off+int64(len(p))-1)/size)
+	if off < 0 || last >= len(z.wp) {
+		return 0, fmt.Errorf("write at byte %d is past the last zone: %w", off, syscall.EIO)
+	}
+	hostManaged := z.Model != "host-aware"
+	if hostManaged && first != last && last >= z.Conventional {
+		return 0, fmt.Errorf("write at byte %d crosses into sequential zone %d: %w", off, last, syscall.EIO)
+	}
+	rel := off - int64(first)*size
+	if hostManaged && first >= z.Conventional {
+		switch {
+		case rel != z.wp[first]:
+			return 0, fmt.Errorf("unaligned write at byte %d: zone %d has its write pointer at byte %d: %w", off, first, z.wp[first], syscall.EIO)
+		case rel+int64(len(p)) > z.capacity():
+			return 0, fmt.Errorf("write at byte %d exceeds the capacity of zone %d: %w", off, first, syscall.EIO)
+		}
+	}
+
+	n, err := w.file.WriteAt(p, off)
+	if first >= z.Conventional && rel+int64(n) > z.wp[first] {
+		z.wp[first] = min(rel+int64(n), z.capacity())
+	}
+	return n, err
+}
+
+func (w *simZoneWriter) Sync() error { return w.file.Sync() }
+
+// blkzone answers "blkzone report <dev>" and "blkzone reset <dev>" from the
+// zone state, in util-linux's output format.
+func (s *SimBackend) blkzone(args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, &simExitError{code: 1, msg: "blkzone: bad usage"}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	d := s.find(args[1])
+	if d == nil || d.Zones == nil {
+		return []byte("blkzone: " + args[1] + ": unable to determine zone size\n"), &simExitError{code: 1, msg: "exit status 1"}
+	}
+	z := d.Zones
+	z.init(d)
+
+	switch args[0] {
+	case "reset":
+		for i := z.Conventional; i < len(z.wp); i++ {
+			z.wp[i] = 0
+		}
+		return nil, nil
+	case "report":
+		seqType := "2(SEQ_WRITE_REQUIRED)"
+		if z.Model == "host-aware" {
+			seqType = "3(SEQ_WRITE_PREFERRED)"
+		}
+		var out strings.Builder
+		size, capacity := (z.SizeMiB<<20)/512, z.capacity()/512
+		for i, wp := range z.wp {
+			start := int64(i) * size
+			cond, typ := " 0(nw)", "1(CONVENTIONAL)"
+			if i >= z.Conventional {
+				typ = seqType
+				switch {
+				case wp == 0:
+					cond = " 1(em)"
+				case wp >= z.capacity():
+					cond = "14(fu)"
+				default:
+					cond = " 4(cl)"
+				}
+			}
+			fmt.Fprintf(&out, "  start: 0x%09x, len 0x%06x, cap 0x%06x, wptr 0x%06x reset:0 non-seq:0, zcond:%s [type: %s]\n",
+				start, size, capacity, wp/512, cond, typ)
+		}
+		return []byte(out.String()), nil
+	}
+	return nil, &simExitError{code: 1, msg: "blkzone: unsupported command " + args[0]}
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Netlink multicast groups of NETLINK_KOBJECT_UEVENT.
+const (
+	ueventKernelGroup = 1
+	ueventUdevGroup   = 2
+)
+
+// udevMagic marks messages re-broadcast by udevd after its rules have run.
+const udevMagic = 0xfeedcafe
+
+// Uevent is a device event from the kernel or udev.
+type Uevent struct {
+	Action    string // add, remove, change, bind, ...
+	DevPath   string // sysfs path below /sys, e.g. /devices/.../block/sdb
+	Subsystem string // block, usb, ...
+	DevType   string // disk, partition, usb_device, ...
+	DevName   string // node name below /dev, e.g. sdb
+	Env       map[string]string
+}
+
+// parseUevent decodes a kernel ("add@/devices/...\0KEY=value\0...") or udev
+// ("libudev\0" header followed by KEY=value\0 pairs) netlink message.
+func parseUevent(msg []byte) (*Uevent, error) {
+	var props []byte
+	if bytes.HasPrefix(msg, []byte("libudev\x00")) {
+		// struct monitor_netlink_header: prefix[8], magic (big endian),
+		// header_size, properties_off, properties_len (host order), ...
+		if len(msg) < 24 || binary.BigEndian.Uint32(msg[8:12]) != udevMagic {
+			return nil, fmt.Errorf("bad udev message header")
+		}
+		off := binary.NativeEndian.Uint32(msg[16:20])
+		length := binary.NativeEndian.Uint32(msg[20:24])
+		if uint64(off)+uint64(length) > uint64(len(msg)) {
+			return nil, fmt.Errorf("truncated udev message")
+		}
+		props = msg[off : off+length]
+	} else {
+		header, rest, ok := bytes.Cut(msg, []byte{0})
+		if !ok || !bytes.Contains(header, []byte("@")) {
+			return nil, fmt.Errorf("not a uevent")
+		}
+		props = rest
+	}
+
+	event := &Uevent{Env: make(map[string]string)}
+	for _, field := range bytes.Split(props, []byte{0}) {
+		key, value, ok := strings.Cut(string(field), "=")
+		if ok {
+			event.Env[key] = value
+		}
+	}
+	event.Action = event.Env["ACTION"]
+	event.DevPath = event.Env["DEVPATH"]
+	event.Subsystem = event.Env["SUBSYSTEM"]
+	event.DevType = event.Env["DEVTYPE"]
+	event.DevName = event.Env["DEVNAME"]
+	if event.Action == "" || event.DevPath == "" {
+		return nil, fmt.Errorf("uevent without ACTION or DEVPATH")
+	}
+	return event, nil
+}
+
+// Uevents listens on the uevent netlink socket. When udevd is running its
+// re-broadcasts are used, since by then the udev database (and so lsblk) knows
+// the device; otherwise the raw kernel events are used.
+func (hostBackend) Uevents() (<-chan Uevent, error) {
+	group := uint32(ueventKernelGroup)
+	if _, err := os.Stat("/run/udev/control"); err == nil {
+		group = ueventUdevGroup
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("could not open uevent socket: %w", err)
+	}
+	// Hot-plug bursts (a hub full of drives) can overflow the default buffer.
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1<<20)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: group}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("could not bind uevent socket: %w", err)
+	}
+
+	events := make(chan Uevent, 64)
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := syscall.Recvfrom(fd, buf, 0)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.ENOBUFS {
+				// Events were dropped; an empty event makes the registry rescan.
+				log.Printf("Warning: uevent buffer overflow, rescanning devices")
+				events <- Uevent{Action: "rescan"}
+				continue
+			}
+			if err != nil {
+				log.Printf("Warning: uevent socket failed: %v", err)
+				close(events)
+				return
+			}
+			// Only the kernel (port 0) or udevd may speak on these groups.
+			if nl, ok := from.(*syscall.SockaddrNetlink); ok && group == ueventKernelGroup && nl.Pid != 0 {
+				continue
+			}
+			event, err := parseUevent(buf[:n])
+			if err != nil {
+				continue
+			}
+			events <- *event
+		}
+	}()
+	log.Printf("Listening for device uevents (netlink group %d)", group)
+	return events, nil
+}
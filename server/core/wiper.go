@@ -7,7 +7,6 @@ import (
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"strings"
 	"sync"
 	"time"
@@ -46,6 +45,15 @@ type WipeConfig struct {
 	DeviceSerial string
 	DeviceType   string
 	DeviceModel  string `json:"deviceModel,omitempty"`
+	// Identity is the serial/WWN/size the client saw for DevicePath. It is
+	// re-checked right before each pass so a renumbered device is never wiped.
+	Identity DeviceIdentity `json:"identity"`
+	// AllowSystemDisk overrides the system disk protection in CheckSystemDisk.
+	AllowSystemDisk bool `json:"allowSystemDisk,omitempty"`
+	// ConfirmationToken is issued by Preflight and is required to start the wipe.
+	ConfirmationToken string `json:"confirmationToken,omitempty"`
+	// Reprovision optionally partitions and formats the disk after a successful wipe.
+	Reprovision *ReprovisionConfig `json:"reprovision,omitempty"`
 }
 
 type WipeMethod struct {
@@ -55,12 +63,19 @@ type WipeMethod struct {
 }
 
 var wipeMethodNames = map[string]string{
-	"nvme_format":           "Purge: NVMe Format",
-	"overwrite_1_pass":      "Clear: 1-Pass Overwrite",
-	"sata_secure_erase":     "Purge: ATA Secure Erase",
-	"overwrite_3_pass":      "Purge: 3-Pass Overwrite",
-	"overwrite_2_pass":      "Clear: 2-Pass Overwrite",
-	"android_factory_reset": "Clear: Factory Reset",
+	"nvme_format":             "Purge: NVMe Format",
+	"overwrite_1_pass":        "Clear: 1-Pass Overwrite",
+	"sata_secure_erase":       "Purge: ATA Secure Erase",
+	"overwrite_3_pass":        "Purge: 3-Pass Overwrite",
+	"overwrite_2_pass":        "Clear: 2-Pass Overwrite",
+	"android_factory_reset":   "Clear: Factory Reset",
+	"qcow2_cluster_wipe":      "Clear: qcow2 Cluster Wipe",
+	"luks_crypto_erase":       "Purge: LUKS Cryptographic Erase",
+	"luks_erase_overwrite":    "Purge: LUKS Cryptographic Erase + Overwrite",
+	"optical_blank_full":      "Clear: Full Blank",
+	"zoned_overwrite":         "Clear: Zone-Aware Overwrite",
+	"fastboot_erase_userdata": "Clear: Fastboot Userdata Erase",
+	"fastboot_wipe":           "Clear: Fastboot Wipe",
 }
 
 func getWipeMethodName(methodId string) string {
@@ -72,6 +87,23 @@ func getWipeMethodName(methodId string) string {
 
 // GetWipeMethodsForDrive returns NIST-compliant methods for standard storage.
 func GetWipeMethodsForDrive(drive Drive) []WipeMethod {
+	// The kernel marks most discs read-only; blanking bypasses the block layer.
+	if drive.ReadOnly && drive.Type != OPTICAL {
+		return []WipeMethod{}
+	}
+	if len(drive.LUKS) > 0 {
+		var methods []WipeMethod
+		// Unencrypted partitions would survive a cryptographic erase alone.
+		if checkLUKSCoverage(&drive) == nil {
+			methods = append(methods, WipeMethod{ID: "luks_crypto_erase", Name: "Purge: LUKS Cryptographic Erase", Description: "Destroys every LUKS header copy and keyslot, leaving the encrypted data unrecoverable. The partition table is kept."})
+		}
+		methods = append(methods, WipeMethod{ID: "luks_erase_overwrite", Name: "Purge: LUKS Cryptographic Erase + Overwrite", Description: "Destroys the LUKS headers and keyslots, then overwrites the whole drive once."})
+		return append(methods, methodsForDrive(drive)...)
+	}
+	return methodsForDrive(drive)
+}
+
+func methodsForDrive(drive Drive) []WipeMethod {
 	switch drive.Type {
 	case NVME:
 		return []WipeMethod{
@@ -88,6 +120,52 @@ func GetWipeMethodsForDrive(drive Drive) []WipeMethod {
 			{ID: "overwrite_1_pass", Name: "Clear: 1-Pass Overwrite", Description: "A single pass of a fixed pattern, per NIST SP 800-88r1 guidelines."},
 			{ID: "overwrite_3_pass", Name: "Purge: 3-Pass Overwrite", Description: "Three passes of a pseudorandom pattern, an optional NIST Purge method."},
 		}
+	case SAS:
+		if drive.Rotational {
+			return methodsForDrive(Drive{Type: HDD})
+		}
+		return []WipeMethod{
+			{ID: "overwrite_1_pass", Name: "Clear: Overwrite", Description: "Not fully effective for flash media due to wear-leveling and over-provisioning."},
+		}
+	case MMC:
+		return []WipeMethod{
+			{ID: "overwrite_1_pass", Name: "Clear: Overwrite", Description: "Not fully effective for flash media due to wear-leveling and over-provisioning."},
+		}
+	case VIRTIO, XEN:
+		return []WipeMethod{
+			{ID: "overwrite_1_pass", Name: "Clear: Overwrite", Description: "Overwrites the virtual disk once. Snapshots or thin provisioning on the host may keep older copies of the data."},
+		}
+	case LOOP:
+		return []WipeMethod{
+			{ID: "overwrite_1_pass", Name: "Clear: Overwrite", Description: "Overwrites the loop device's backing file through the loop device."},
+		}
+	case DM:
+		return []WipeMethod{
+			{ID: "overwrite_1_pass", Name: "Clear: Overwrite", Description: "Overwrites the mapped volume only; the rest of the underlying disks is not touched."},
+		}
+	case ZONED:
+		methods := []WipeMethod{
+			{ID: "zoned_overwrite", Name: "Clear: Zone-Aware Overwrite", Description: "Resets every zone, writes each zone sequentially from its write pointer, then resets all zones again."},
+		}
+		if strings.HasPrefix(drive.Name, "/dev/nvme") {
+			methods = append(methods, methodsForDrive(Drive{Type: NVME})[0])
+		}
+		// Host-managed zones only accept sequential writes, so a plain
+		// overwrite would fail part-way.
+		if drive.Zoned == "host-aware" {
+			methods = append(methods, methodsForDrive(Drive{Type: HDD})...)
+		}
+		return methods
+	case OPTICAL:
+		// Pressed and write-once discs can only be destroyed (Drive.Optical.DestroyOnly).
+		// A fast blank only drops the table of contents and leaves the data
+		// readable, so it is not offered.
+		if drive.Optical == nil || drive.Optical.Class != MediaRewritable {
+			return []WipeMethod{}
+		}
+		return []WipeMethod{
+			{ID: "optical_blank_full", Name: "Clear: Full Blank", Description: "Blanks the entire disc. NIST SP 800-88r1 still recommends destroying optical media that held sensitive data."},
+		}
 	case USB, UNKN:
 		return []WipeMethod{
 			{ID: "overwrite_2_pass", Name: "Clear: 2-Pass Overwrite", Description: "A pattern and its complement, per NIST guidelines for USB/removable media."},
@@ -101,8 +179,21 @@ func GetWipeMethodsForDrive(drive Drive) []WipeMethod {
 func GetWipeMethodsForMobile(device MobileDevice) []WipeMethod {
 	switch device.Type {
 	case "Android":
+		if device.State == MobileStateFastboot {
+			if checkFastbootUnlocked(device.Fastboot) != nil {
+				return []WipeMethod{}
+			}
+			return []WipeMethod{
+				{ID: "fastboot_erase_userdata", Name: "Clear: Fastboot Userdata Erase", Description: "Erases the userdata partition from the bootloader, along with metadata and cache where the device has them. Android formats them on the next boot."},
+				{ID: "fastboot_wipe", Name: "Clear: Fastboot Wipe", Description: "Runs fastboot -w, which erases and formats userdata and cache (and metadata with current platform-tools)."},
+			}
+		}
+		// A factory reset only sanitizes a phone that encrypts its storage.
+		if checkAndroidEncrypted(device.Attestation) != nil {
+			return []WipeMethod{}
+		}
 		return []WipeMethod{
-			{ID: "android_factory_reset", Name: "Clear: Factory Reset", Description: "Initiates the device's built-in factory data reset, as per NIST guidelines."},
+			{ID: "android_factory_reset", Name: "Clear: Factory Reset", Description: "Triggers the device's built-in factory data reset, as per NIST guidelines, and confirms the device returns in setup state."},
 		}
 	default:
 		return []WipeMethod{}
@@ -130,6 +221,9 @@ func GetWipeMethods(devicePath string) ([]WipeMethod, error) {
 	}
 	for _, device := range mobileDevices {
 		if device.Serial == devicePath { // Assuming devicePath is the serial for mobile
+			if err := attestAndroid(&device); err != nil {
+				return nil, fmt.Errorf("could not read %s: %w", devicePath, err)
+			}
 			return GetWipeMethodsForMobile(device), nil
 		}
 	}
@@ -137,9 +231,14 @@ func GetWipeMethods(devicePath string) ([]WipeMethod, error) {
 	return nil, fmt.Errorf("device %s not found", devicePath)
 }
 
+// SanitizeDevice wipes a storage drive or file target. Phones are wiped by
+// runAndroidJob, which also records how the wipe was verified.
 func SanitizeDevice(config WipeConfig, progress chan<- string) error {
 	if config.DeviceType == "Android" {
-		return sanitizeAndroid(config.DeviceSerial, progress)
+		return fmt.Errorf("android devices are wiped through a wipe job")
+	}
+	if isFileTarget(config.DevicePath) {
+		return sanitizeFileTarget(config, progress)
 	}
 	return sanitizeStorageDrive(config, progress)
 }
@@ -162,6 +261,19 @@ func sanitizeStorageDrive(config WipeConfig, progress chan<- string) error {
 	if targetDrive == nil {
 		return fmt.Errorf("drive %s not found", config.DevicePath)
 	}
+	if config.Identity.IsZero() {
+		return fmt.Errorf("wipe request for %s does not pin a device identity", config.DevicePath)
+	}
+	if targetDrive.Identity != config.Identity {
+		return fmt.Errorf("device identity mismatch on %s: expected %s, found %s", config.DevicePath, config.Identity, targetDrive.Identity)
+	}
+	if !config.AllowSystemDisk {
+		if err := CheckSystemDisk(config.DevicePath); err != nil {
+			return err
+		}
+	} else if targetDrive.IsOSDrive {
+		log.Printf("WARNING: system disk protection overridden for %s (%s)", config.DevicePath, strings.Join(targetDrive.SystemDiskReasons, ", "))
+	}
 	if targetDrive.IsMounted {
 		return fmt.Errorf("cannot wipe a mounted drive")
 	}
@@ -171,43 +283,40 @@ func sanitizeStorageDrive(config WipeConfig, progress chan<- string) error {
 
 	switch config.Method {
 	case "nvme_format":
-		return sanitizeNVMe(config.DevicePath, progress)
+		return sanitizeNVMe(config, progress)
 	case "sata_secure_erase":
-		return sanitizeSATA(config.DevicePath, progress)
+		return sanitizeSATA(config, progress)
 	case "overwrite_1_pass":
 		return sanitizeOverwrite(config, 1, progress)
 	case "overwrite_3_pass":
 		return sanitizeOverwrite(config, 3, progress)
 	case "overwrite_2_pass":
 		return sanitizeOverwriteTwoPass(config, progress)
+	case "luks_crypto_erase":
+		return sanitizeLUKS(config, targetDrive, false, progress)
+	case "luks_erase_overwrite":
+		return sanitizeLUKS(config, targetDrive, true, progress)
+	case "optical_blank_full":
+		return sanitizeOptical(config, targetDrive, progress)
+	case "zoned_overwrite":
+		return sanitizeZoned(config, progress)
 	default:
 		return fmt.Errorf("unknown sanitization method: %s", config.Method)
 	}
 }
 
-func sanitizeAndroid(serial string, progress chan<- string) error {
-	progress <- fmt.Sprintf("Executing Android Factory Reset (NIST Clear) on device %s...", serial)
-	cmd := exec.Command("adb", "-s", serial, "reboot", "recovery")
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to send reboot to recovery command: %w", err)
-	}
-	progress <- "Reboot to recovery command sent. The device will now perform a factory reset."
-	return nil
-}
-
 func runCommand(ctx context.Context, name string, args ...string) error {
 	// Prepend ionice to the command to set I/O scheduling class to Idle
 	fullArgs := append([]string{"-c", "3", name}, args...)
-	cmd := exec.CommandContext(ctx, "ionice", fullArgs...)
-	output, err := cmd.CombinedOutput()
+	output, err := backend.CombinedOutput(ctx, "ionice", fullArgs...)
 	if err != nil {
 		return fmt.Errorf("command %s failed: %w. Output: %s", name, err, string(output))
 	}
 	return nil
 }
 
-func sanitizeNVMe(path string, progress chan<- string) error {
+func sanitizeNVMe(config WipeConfig, progress chan<- string) error {
+	path := config.DevicePath
 	progress <- "Executing NVMe Format..."
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -226,10 +335,17 @@ func sanitizeNVMe(path string, progress chan<- string) error {
 		wipeMutex.Unlock()
 	}()
 
+	claim, err := openPinnedDevice(config, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer claim.Close()
+
 	return runCommand(ctx, "nvme", "format", path, "-s", "1")
 }
 
-func sanitizeSATA(path string, progress chan<- string) error {
+func sanitizeSATA(config WipeConfig, progress chan<- string) error {
+	path := config.DevicePath
 	progress <- "Executing ATA Secure Erase..."
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -248,29 +364,54 @@ func sanitizeSATA(path string, progress chan<- string) error {
 		wipeMutex.Unlock()
 	}()
 
-	err := runCommand(ctx, "hdparm", "--user-master", "user", "--security-set-pass", "dZap", path)
+	claim, err := openPinnedDevice(config, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer claim.Close()
+
+	err = runCommand(ctx, "hdparm", "--user-master", "user", "--security-set-pass", "dZap", path)
 	if err != nil {
 		return fmt.Errorf("failed to set security password: %w", err)
 	}
 	progress <- "Security password set. Issuing erase..."
 
+	if err := verifyDeviceIdentity(path, config.Identity); err != nil {
+		return err
+	}
+
 	return runCommand(ctx, "hdparm", "--user-master", "user", "--security-erase", "dZap", path)
 }
 
 func overwritePass(ctx context.Context, controls *WipeControls, config WipeConfig, pattern byte, passNum int, totalPasses int, progress chan<- string) error {
-	file, err := os.OpenFile(config.DevicePath, os.O_WRONLY, 0)
+	file, err := openPinnedDevice(config, os.O_WRONLY)
 	if err != nil {
-		return fmt.Errorf("failed to open device: %w", err)
+		return fmt.Errorf("refusing to start pass %d: %w", passNum, err)
 	}
 	defer file.Close()
 
-	size, err := file.Seek(0, io.SeekEnd)
+	device, err := deviceExtent(file, config.DevicePath)
 	if err != nil {
-		return fmt.Errorf("could not determine device size: %w", err)
+		return err
 	}
-	log.Printf("overwritePass pass %d, device size: %d", passNum, size)
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("could not seek to start: %w", err)
+	log.Printf("overwritePass pass %d, device size: %d", passNum, device.length)
+
+	return overwriteExtents(ctx, controls, config, file, []extent{device}, pattern, passNum, totalPasses, progress)
+}
+
+// extent is a byte range of the target to overwrite.
+type extent struct {
+	offset int64
+	length int64
+}
+
+// overwriteExtents fills every extent with pattern, reporting progress across
+// all of them as one pass. Whole devices are a single extent; image formats
+// like qcow2 pass only the host clusters that hold guest data.
+func overwriteExtents(ctx context.Context, controls *WipeControls, config WipeConfig, file DeviceWriter, extents []extent, pattern byte, passNum int, totalPasses int, progress chan<- string) error {
+	var size int64
+	for _, e := range extents {
+		size += e.length
 	}
 
 	buffer := make([]byte, 128*1024) // 128KB buffer
@@ -279,6 +420,7 @@ func overwritePass(ctx context.Context, controls *WipeControls, config WipeConfi
 	}
 
 	var written int64
+	current, offsetInExtent := 0, int64(0)
 	startTime := time.Now()
 
 	ticker := time.NewTicker(500 * time.Millisecond)
@@ -290,8 +432,15 @@ func overwritePass(ctx context.Context, controls *WipeControls, config WipeConfi
 
 	for written < size {
 		if !writing {
+			e := extents[current]
+			// Never write past the end of an extent, so file targets don't grow.
+			chunk := buffer
+			if remaining := e.length - offsetInExtent; remaining < int64(len(chunk)) {
+				chunk = chunk[:remaining]
+			}
+			offset := e.offset + offsetInExtent
 			go func() {
-				n, err := file.Write(buffer)
+				n, err := file.WriteAt(chunk, offset)
 				if err != nil {
 					errChan <- err
 					return
@@ -311,6 +460,11 @@ func overwritePass(ctx context.Context, controls *WipeControls, config WipeConfi
 			}
 		case n := <-writeDone:
 			written += int64(n)
+			offsetInExtent += int64(n)
+			for current < len(extents) && offsetInExtent >= extents[current].length {
+				current++
+				offsetInExtent = 0
+			}
 			writing = false
 		case err := <-errChan:
 			log.Printf("overwritePass pass %d, write error: %v", passNum, err)
@@ -347,6 +501,11 @@ func overwritePass(ctx context.Context, controls *WipeControls, config WipeConfi
 		}
 	}
 
+	// Make sure the pass is on the media, not just in the page cache.
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to flush pass %d: %w", passNum, err)
+	}
+
 	// Final progress update for the pass
 	finalProgress := (float64(passNum) * 100) / float64(totalPasses)
 	progressMsg := WipeProgress{
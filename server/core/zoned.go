@@ -0,0 +1,202 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Zone types and conditions, as numbered by the kernel's blkzoned.h.
+const (
+	zoneTypeConventional = 1
+
+	zoneCondEmpty    = 0x1
+	zoneCondReadOnly = 0xd
+	zoneCondOffline  = 0xf
+)
+
+// blkzoneLine matches a zone in "blkzone report" output. Values are in
+// 512-byte sectors and the write pointer is relative to the zone start. Older
+// util-linux versions do not print the capacity.
+var blkzoneLine = regexp.MustCompile(`start:\s*0x([0-9a-f]+),\s*len\s*0x([0-9a-f]+),(?:\s*cap\s*0x([0-9a-f]+),)?\s*wptr\s*0x([0-9a-f]+).*zcond:\s*(\d+)\(\w+\)\s*\[type:\s*(\d+)\(`)
+
+// blockZone is one zone of a zoned block device, in bytes.
+type blockZone struct {
+	Start    int64
+	Length   int64
+	Capacity int64
+	WP       int64 // relative to Start
+	Cond     int
+	Type     int
+}
+
+func (z blockZone) sequential() bool { return z.Type != zoneTypeConventional }
+
+func reportZones(devicePath string) ([]blockZone, error) {
+	out, err := backend.Output("blkzone", "report", devicePath)
+	if err != nil {
+		return nil, fmt.Errorf("blkzone report failed: %w", err)
+	}
+	zones := parseBlkzoneReport(string(out))
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("blkzone reported no zones on %s", devicePath)
+	}
+	return zones, nil
+}
+
+func parseBlkzoneReport(output string) []blockZone {
+	var zones []blockZone
+	for _, line := range strings.Split(output, "\n") {
+		m := blkzoneLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		sectors := func(hex string) int64 {
+			v, _ := strconv.ParseInt(hex, 16, 64)
+			return v * 512
+		}
+		z := blockZone{Start: sectors(m[1]), Length: sectors(m[2]), WP: sectors(m[4])}
+		z.Capacity = z.Length
+		if m[3] != "" {
+			z.Capacity = sectors(m[3])
+		}
+		z.Cond, _ = strconv.Atoi(m[5])
+		z.Type, _ = strconv.Atoi(m[6])
+		zones = append(zones, z)
+	}
+	return zones
+}
+
+// sanitizeZoned overwrites a zoned drive without ever writing out of order:
+// it resets every sequential zone, writes each zone in order from its write
+// pointer up to its capacity (conventional zones are written whole), and
+// finally resets all zones again so the drive is handed back empty.
+func sanitizeZoned(config WipeConfig, progress chan<- string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controls := &WipeControls{
+		cancel: cancel,
+		pause:  make(chan bool),
+	}
+	wipeMutex.Lock()
+	activeWipes[config.DevicePath] = controls
+	wipeMutex.Unlock()
+
+	defer func() {
+		wipeMutex.Lock()
+		delete(activeWipes, config.DevicePath)
+		wipeMutex.Unlock()
+	}()
+
+	// Claim and verify the drive before the first reset; every reset and
+	// write goes through this handle.
+	file, err := openZonedDevice(config)
+	if err != nil {
+		return fmt.Errorf("refusing to reset zones: %w", err)
+	}
+	defer file.Close()
+
+	zones, err := reportZones(config.DevicePath)
+	if err != nil {
+		return err
+	}
+	// Offline and read-only zones cannot be written, so their data would survive.
+	for _, z := range zones {
+		if z.Cond == zoneCondOffline || z.Cond == zoneCondReadOnly {
+			return fmt.Errorf("zone at byte %d is offline or read-only and cannot be sanitized", z.Start)
+		}
+	}
+
+	progress <- fmt.Sprintf("Resetting all %d zones...", len(zones))
+	if err := resetZones(file, config.DevicePath); err != nil {
+		return err
+	}
+	if zones, err = reportZones(config.DevicePath); err != nil {
+		return err
+	}
+
+	var extents []extent
+	for _, z := range zones {
+		switch {
+		case !z.sequential():
+			extents = append(extents, extent{offset: z.Start, length: z.Length})
+		case z.WP < z.Capacity:
+			extents = append(extents, extent{offset: z.Start + z.WP, length: z.Capacity - z.WP})
+		}
+	}
+
+	progress <- fmt.Sprintf("Writing %d zones sequentially...", len(extents))
+	if err := overwriteExtents(ctx, controls, config, backend.ZoneWriter(file, config.DevicePath), extents, 0x00, 1, 1, progress); err != nil {
+		return err
+	}
+
+	progress <- "Resetting all zones..."
+	if err := resetZones(file, config.DevicePath); err != nil {
+		return err
+	}
+	if zones, err = reportZones(config.DevicePath); err != nil {
+		return err
+	}
+	for _, z := range zones {
+		if z.sequential() && z.Cond != zoneCondEmpty {
+			return fmt.Errorf("zone at byte %d is not empty after the final reset", z.Start)
+		}
+	}
+
+	completion := WipeProgress{
+		DeviceID: config.DevicePath,
+		Status:   "done",
+		Progress: 100,
+	}
+	jsonMsg, _ := json.Marshal(completion)
+	progress <- string(jsonMsg)
+	return nil
+}
+
+// resetZones resets every sequential zone of the pinned drive, moving its
+// write pointer back to the zone start.
+func resetZones(file *os.File, devicePath string) error {
+	if err := backend.ResetZones(file, devicePath); err != nil {
+		return fmt.Errorf("zone reset failed: %w", err)
+	}
+	return nil
+}
+
+// blkResetZone is BLKRESETZONE, _IOW(0x12, 131, struct blk_zone_range).
+const blkResetZone = 0x40101283
+
+// ResetZones issues BLKRESETZONE over the whole device, which the kernel
+// applies to every sequential zone.
+func (hostBackend) ResetZones(file *os.File, path string) error {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("could not determine the size of %s: %w", path, err)
+	}
+	zoneRange := struct{ sector, sectors uint64 }{0, uint64(size / 512)}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), blkResetZone, uintptr(unsafe.Pointer(&zoneRange))); errno != 0 {
+		return fmt.Errorf("BLKRESETZONE on %s: %w", path, errno)
+	}
+	return nil
+}
+
+// openZonedDevice opens the drive for direct I/O, so writes reach each zone
+// in the order they were issued instead of whenever the page cache flushes
+// them. File-backed devices that do not support O_DIRECT are opened buffered.
+func openZonedDevice(config WipeConfig) (*os.File, error) {
+	file, err := openPinnedDevice(config, os.O_WRONLY|syscall.O_DIRECT)
+	if errors.Is(err, syscall.EINVAL) {
+		log.Printf("Warning: %s does not support direct I/O, writing zones buffered", config.DevicePath)
+		return openPinnedDevice(config, os.O_WRONLY)
+	}
+	return file, err
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestParseBlkzoneReport(t *testing.T) {
+	report := `  start: 0x000000000, len 0x080000, cap 0x080000, wptr 0x000000 reset:0 non-seq:0, zcond: 0(nw) [type: 1(CONVENTIONAL)]
+  start: 0x000080000, len 0x080000, cap 0x060000, wptr 0x000800 reset:0 non-seq:0, zcond: 4(cl) [type: 2(SEQ_WRITE_REQUIRED)]
+  start: 0x000100000, len 0x080000, cap 0x060000, wptr 0x060000 reset:0 non-seq:0, zcond:14(fu) [type: 2(SEQ_WRITE_REQUIRED)]
+  start: 0x000180000, len 0x080000, wptr 0x000000 reset:0 non-seq:0, zcond: 1(em) [type: 3(SEQ_WRITE_PREFERRED)]
+  start: 0x000200000, len 0x080000, cap 0x080000, wptr 0x000000 reset:0 non-seq:0, zcond:15(of) [type: 2(SEQ_WRITE_REQUIRED)]
+blkzone: some unrelated line
+`
+	const zone = 0x80000 * 512
+	want := []blockZone{
+		{Start: 0, Length: zone, Capacity: zone, WP: 0, Cond: 0, Type: zoneTypeConventional},
+		{Start: zone, Length: zone, Capacity: 0x60000 * 512, WP: 0x800 * 512, Cond: 4, Type: 2},
+		{Start: 2 * zone, Length: zone, Capacity: 0x60000 * 512, WP: 0x60000 * 512, Cond: 14, Type: 2},
+		// Without a cap column the capacity is the zone length.
+		{Start: 3 * zone, Length: zone, Capacity: zone, WP: 0, Cond: zoneCondEmpty, Type: 3},
+		{Start: 4 * zone, Length: zone, Capacity: zone, WP: 0, Cond: zoneCondOffline, Type: 2},
+	}
+
+	got := parseBlkzoneReport(report)
+	if len(got) != len(want) {
+		t.Fatalf("parsed %d zones, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("zone %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got[0].sequential() || !got[1].sequential() || !got[3].sequential() {
+		t.Error("sequential() does not follow the zone type")
+	}
+}
+
+// zonedTestDrive returns the wipe config for a drive of the zoned scenario.
+func zonedTestDrive(t *testing.T, name string) WipeConfig {
+	t.Helper()
+	drives, err := detectStorageDrives()
+	if err != nil {
+		t.Fatalf("detectStorageDrives: %v", err)
+	}
+	for _, d := range drives {
+		if d.Name == name {
+			return WipeConfig{DevicePath: name, Method: "zoned_overwrite", Identity: d.Identity}
+		}
+	}
+	t.Fatalf("%s not in the zoned scenario", name)
+	return WipeConfig{}
+}
+
+func drainProgress(t *testing.T) chan<- string {
+	progress := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range progress {
+		}
+	}()
+	t.Cleanup(func() {
+		close(progress)
+		<-done
+	})
+	return progress
+}
+
+func TestSimZoneWriter(t *testing.T) {
+	sim := useSimBackend(t, "zoned")
+	const zone = 4 << 20
+	file, err := os.OpenFile(sim.DevicePath("/dev/sdf"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := sim.ZoneWriter(file, "/dev/sdf")
+	block := make([]byte, 4096)
+
+	expectEIO := func(what string, off int64) {
+		t.Helper()
+		if _, err := w.WriteAt(block, off); !errors.Is(err, syscall.EIO) {
+			t.Errorf("%s: error = %v, want EIO", what, err)
+		}
+	}
+	if _, err := w.WriteAt(block, 100); err != nil {
+		t.Errorf("random write to a conventional zone: %v", err)
+	}
+	expectEIO("write to a full sequential zone", 2*zone)
+	expectEIO("write crossing into a sequential zone", 2*zone-2048)
+
+	if err := sim.ResetZones(file, "/dev/sdf"); err != nil {
+		t.Fatalf("ResetZones: %v", err)
+	}
+	expectEIO("write ahead of the write pointer", 2*zone+4096)
+	if _, err := w.WriteAt(block, 2*zone); err != nil {
+		t.Fatalf("write at the write pointer: %v", err)
+	}
+	if _, err := w.WriteAt(block, 2*zone+4096); err != nil {
+		t.Errorf("write at the advanced write pointer: %v", err)
+	}
+	expectEIO("rewrite behind the write pointer", 2*zone)
+
+	zones, err := reportZones("/dev/sdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z := zones[2]; z.WP != 8192 || z.Cond == zoneCondEmpty {
+		t.Errorf("zone 2 after two writes = %+v, want its write pointer at 8192", z)
+	}
+}
+
+func TestSanitizeZoned(t *testing.T) {
+	for _, tt := range []struct {
+		device       string
+		zone         int64
+		capacity     int64
+		conventional int
+	}{
+		{device: "/dev/sdf", zone: 4 << 20, capacity: 4 << 20, conventional: 2},
+		{device: "/dev/nvme1n1", zone: 4 << 20, capacity: 3 << 20},
+	} {
+		t.Run(tt.device, func(t *testing.T) {
+			sim := useSimBackend(t, "zoned")
+			config := zonedTestDrive(t, tt.device)
+			image := sim.DevicePath(tt.device)
+			info, err := os.Stat(image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(image, bytes.Repeat([]byte{0xab}, int(info.Size())), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := sanitizeZoned(config, drainProgress(t)); err != nil {
+				t.Fatalf("sanitizeZoned: %v", err)
+			}
+
+			zones, err := reportZones(tt.device)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, z := range zones {
+				if z.sequential() && z.Cond != zoneCondEmpty {
+					t.Errorf("zone %d is not empty after the wipe: %+v", i, z)
+				}
+			}
+			data, err := os.ReadFile(image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range zones {
+				start := int64(i) * tt.zone
+				end := start + tt.capacity
+				if i < tt.conventional {
+					end = start + tt.zone
+				}
+				if j := bytes.IndexFunc(data[start:end], func(r rune) bool { return r != 0 }); j >= 0 {
+					t.Errorf("zone %d still holds data at byte %d", i, start+int64(j))
+				}
+			}
+		})
+	}
+}
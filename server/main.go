@@ -2,16 +2,28 @@ package main
 
 import (
 	"dzap-backend/api"
+	"dzap-backend/core"
 	"dzap-backend/realtime"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	ort "github.com/yalue/onnxruntime_go"
 )
 
 func main() {
+	backendName := flag.String("backend", "host", "device backend: \"host\" for real hardware or \"sim\" for simulated disks")
+	simScenario := flag.String("sim-scenario", "", "directory containing a simulated scenario.json (defaults to the built-in bench)")
+	simDir := flag.String("sim-dir", filepath.Join(os.TempDir(), "dzap-sim"), "working directory for simulated disk images, sysfs and procfs")
+	hooksDir := flag.String("hooks-dir", "", "directory with pre-wipe, post-wipe and wipe-failed hook executables (defaults to ~/.config/DZap/hooks)")
+	imageDirs := flag.String("image-dirs", "", "directories, separated like PATH, whose files and disk images may be wiped (file targets are refused elsewhere)")
+	flag.Parse()
+	core.SetHooksDir(*hooksDir)
+	core.SetImageDirs(filepath.SplitList(*imageDirs))
+
 	ort.SetSharedLibraryPath("/usr/lib/onnxruntime.so")
 	err := ort.InitializeEnvironment()
 	if err != nil {
@@ -20,22 +32,48 @@ func main() {
 		defer ort.DestroyEnvironment()
 	}
 
-	if os.Geteuid() != 0 {
-		log.Fatalf("\n[FATAL] Root privileges are required. Please run with sudo.\n")
+	switch *backendName {
+	case "host":
+		if os.Geteuid() != 0 {
+			log.Fatalf("\n[FATAL] Root privileges are required. Please run with sudo.\n")
+		}
+	case "sim":
+		sim, err := core.NewSimBackend(*simScenario, *simDir)
+		if err != nil {
+			log.Fatalf("Failed to start simulated backend: %v", err)
+		}
+		core.SetBackend(sim)
+	default:
+		log.Fatalf("Unknown backend %q (expected host or sim)", *backendName)
 	}
 
 	hub := realtime.NewHub()
 	go hub.Run()
 	api.RegisterHub(hub)
+	api.StartDeviceMonitor()
+	api.StartScheduler()
+	api.StartKiosk()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/drives", api.GetDrivesHandler)
 	mux.HandleFunc("/api/wipe/pause", api.PauseWipeHandler)
 	mux.HandleFunc("/api/wipe/abort", api.AbortWipeHandler)
+	mux.HandleFunc("/api/jobs", api.ListJobsHandler)
+	mux.HandleFunc("/api/jobs/", api.GetJobHandler)
+	mux.HandleFunc("/api/schedules", api.SchedulesHandler)
+	mux.HandleFunc("/api/schedules/", api.ScheduleHandler)
+	mux.HandleFunc("/api/kiosk", api.KioskHandler)
 	mux.HandleFunc("/api/certificates", api.ListCertificatesHandler)
+	mux.HandleFunc("/api/certificates/", api.GetCertificateHandler)
 	mux.HandleFunc("/api/certificate/generate", api.GenerateCertificateHandler)
+	mux.HandleFunc("/api/certificate/verify", api.VerifyCertificateHandler)
 	mux.HandleFunc("/api/unmount", api.UnmountDriveHandler)
+	mux.HandleFunc("/api/file-target", api.InspectFileTargetHandler)
+	mux.HandleFunc("/api/wipe/preflight", api.PreflightWipeHandler)
 	mux.HandleFunc("/api/wipe", api.WipeDriveHandler)
+	if core.CurrentBackend() == "sim" {
+		mux.HandleFunc("/api/sim/hotplug", api.SimHotplugHandler)
+	}
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		realtime.ServeWs(hub, w, r)
 	})
@@ -44,6 +82,8 @@ func main() {
 			api.GetDriveHealthHandler(w, r)
 		} else if strings.HasSuffix(r.URL.Path, "/wipe-methods") {
 			api.GetWipeMethodsHandler(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/processes") {
+			api.GetDriveProcessesHandler(w, r)
 		} else {
 			http.NotFound(w, r)
 		}